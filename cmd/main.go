@@ -0,0 +1,53 @@
+// The superchat command serves the API and manages its database.
+package main
+
+import (
+	"fmt"
+	"github.com/jessehorne/superchat-core/server"
+	"github.com/joho/godotenv"
+	"os"
+)
+
+const usage = `usage: superchat <command> [arguments]
+
+commands:
+  serve                   serve the API on APP_HOST:APP_PORT
+  migrate up [N]          apply every pending migration, or only the next N
+  migrate down [N|all]    roll back the last N migrations (1 by default) or all of them
+  migrate to VERSION      migrate up or down to VERSION
+  migrate force VERSION   set the version without running anything, to recover from a failed migration
+  migrate status          list every migration and whether it has been applied
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Print(usage)
+		return
+	}
+
+	if err := godotenv.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "serve":
+		err = server.Run()
+	case "migrate":
+		err = migrateCommand(os.Args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
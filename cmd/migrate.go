@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/jessehorne/superchat-core/database"
+	"github.com/jessehorne/superchat-core/database/migrations"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// migrateLog prints what migrate is doing as it goes
+type migrateLog struct{}
+
+func (migrateLog) Printf(format string, v ...any) {
+	fmt.Printf(format, v...)
+}
+
+func (migrateLog) Verbose() bool {
+	return false
+}
+
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("migrate needs a subcommand: up, down, to, force or status")
+	}
+
+	if _, err := database.InitDB(); err != nil {
+		return fmt.Errorf("problem with database: %w", err)
+	}
+
+	m, err := database.NewMigrate()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	m.Log = migrateLog{}
+
+	switch args[0] {
+	case "up":
+		if len(args) > 1 {
+			n, convErr := strconv.Atoi(args[1])
+			if convErr != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+			err = m.Steps(n)
+		} else {
+			err = m.Up()
+		}
+	case "down":
+		if len(args) > 1 && args[1] == "all" {
+			err = m.Down()
+			break
+		}
+
+		n := 1
+		if len(args) > 1 {
+			var convErr error
+			n, convErr = strconv.Atoi(args[1])
+			if convErr != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		err = m.Steps(-n)
+	case "to":
+		if len(args) < 2 {
+			return errors.New("migrate to needs a version")
+		}
+
+		version, convErr := strconv.ParseUint(args[1], 10, 64)
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = m.Migrate(uint(version))
+	case "force":
+		if len(args) < 2 {
+			return errors.New("migrate force needs a version, -1 means no migrations have run")
+		}
+
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = m.Force(version)
+	case "status":
+		return migrateStatus(m)
+	default:
+		return fmt.Errorf("unknown migrate subcommand %q", args[0])
+	}
+
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Println("nothing to migrate")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't run migrations: %w", err)
+	}
+
+	return migrateStatus(m)
+}
+
+// migrateStatus prints every embedded migration for the database in use and whether it has been applied
+func migrateStatus(m *migrate.Migrate) error {
+	current, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		current, err = 0, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entries, err := fs.ReadDir(migrations.FS, database.Driver())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("driver: %s\n", database.Driver())
+	for _, entry := range entries {
+		mig, err := source.DefaultParse(entry.Name())
+		if err != nil || mig.Direction != source.Up {
+			continue
+		}
+
+		state := "pending"
+		if mig.Version <= current {
+			state = "applied"
+		}
+		if mig.Version == current && dirty {
+			state = "dirty"
+		}
+
+		fmt.Printf("%-8s %d %s\n", state, mig.Version, strings.ReplaceAll(mig.Identifier, "_", " "))
+	}
+
+	if dirty {
+		fmt.Printf("\nversion %d failed partway through, fix it by hand then run `migrate force VERSION`\n", current)
+	}
+
+	return nil
+}
@@ -2,29 +2,100 @@ package database
 
 import (
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+
+	defaultSQLitePath = "superchat.db"
 )
 
 var DB *sql.DB
 var GDB *gorm.DB
 
+// Driver is the database in use, set with DB_DRIVER. It's MySQL unless told otherwise.
+func Driver() string {
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		return DriverMySQL
+	}
+	return driver
+}
+
+// GetDSN builds the connection string for Driver. MySQL uses the MYSQL_* settings, PostgreSQL the POSTGRES_* ones and
+// SQLite opens SQLITE_PATH.
 func GetDSN() string {
-	user := os.Getenv("MYSQL_USER")
-	pass := os.Getenv("MYSQL_PASS")
-	host := os.Getenv("MYSQL_HOST")
-	port := os.Getenv("MYSQL_PORT")
-	name := os.Getenv("MYSQL_DB")
-
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?multiStatements=true&parseTime=true",
-		user, pass, host, port, name)
+	switch Driver() {
+	case DriverPostgres:
+		sslMode := os.Getenv("POSTGRES_SSLMODE")
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASS")),
+			Host:     os.Getenv("POSTGRES_HOST") + ":" + os.Getenv("POSTGRES_PORT"),
+			Path:     os.Getenv("POSTGRES_DB"),
+			RawQuery: "sslmode=" + url.QueryEscape(sslMode),
+		}
+		return dsn.String()
+	case DriverSQLite:
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = defaultSQLitePath
+		}
+
+		// sqlite leaves foreign keys off and fails straight away on a locked database unless asked not to
+		return fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL", path)
+	default:
+		user := os.Getenv("MYSQL_USER")
+		pass := os.Getenv("MYSQL_PASS")
+		host := os.Getenv("MYSQL_HOST")
+		port := os.Getenv("MYSQL_PORT")
+		name := os.Getenv("MYSQL_DB")
+
+		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?multiStatements=true&parseTime=true",
+			user, pass, host, port, name)
+	}
+}
+
+// sqlDriverName is the database/sql driver registered for Driver
+func sqlDriverName() (string, error) {
+	switch Driver() {
+	case DriverMySQL:
+		return "mysql", nil
+	case DriverPostgres:
+		return "postgres", nil
+	case DriverSQLite:
+		return "sqlite3", nil
+	}
+
+	return "", fmt.Errorf("unknown DB_DRIVER %q, it should be %s, %s or %s", Driver(), DriverMySQL, DriverPostgres,
+		DriverSQLite)
 }
 
 func InitDB() (*sql.DB, error) {
-	db, err := sql.Open("mysql", GetDSN())
+	name, err := sqlDriverName()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(name, GetDSN())
 
 	if err != nil {
 		return nil, err
@@ -36,7 +107,22 @@ func InitDB() (*sql.DB, error) {
 }
 
 func InitGDB() (*gorm.DB, error) {
-	db, err := gorm.Open(mysql.Open(GetDSN()), &gorm.Config{})
+	if _, err := sqlDriverName(); err != nil {
+		return nil, err
+	}
+
+	dialector := mysql.Open(GetDSN())
+	switch Driver() {
+	case DriverPostgres:
+		dialector = postgres.Open(GetDSN())
+	case DriverSQLite:
+		dialector = sqlite.Open(GetDSN())
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		// lets duplicate keys be told apart from other errors the same way on every database
+		TranslateError: true,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"errors"
+	"github.com/golang-migrate/migrate/v4"
+	migratedb "github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jessehorne/superchat-core/database/migrations"
+)
+
+// NewMigrate sets up migrations for DB using the embedded set written for Driver. InitDB has to be called first.
+func NewMigrate() (*migrate.Migrate, error) {
+	if DB == nil {
+		return nil, errors.New("the database has to be set up before migrating")
+	}
+
+	var driver migratedb.Driver
+	var err error
+	switch Driver() {
+	case DriverMySQL:
+		driver, err = mysql.WithInstance(DB, &mysql.Config{})
+	case DriverPostgres:
+		driver, err = postgres.WithInstance(DB, &postgres.Config{})
+	case DriverSQLite:
+		driver, err = sqlite3.WithInstance(DB, &sqlite3.Config{})
+	default:
+		_, err = sqlDriverName()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := iofs.New(migrations.FS, Driver())
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.NewWithInstance("iofs", source, Driver(), driver)
+}
@@ -0,0 +1,8 @@
+// Package migrations holds the SQL migrations for every supported database, one directory per DB_DRIVER, so they're
+// built into the binary.
+package migrations
+
+import "embed"
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var FS embed.FS
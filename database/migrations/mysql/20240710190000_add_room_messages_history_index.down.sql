@@ -0,0 +1 @@
+DROP INDEX room_messages_room_id_created_at_id ON room_messages;
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX room_messages_room_id_created_at_id ON room_messages (room_id, created_at, id);
\ No newline at end of file
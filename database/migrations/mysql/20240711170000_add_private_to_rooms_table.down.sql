@@ -0,0 +1 @@
+ALTER TABLE rooms DROP COLUMN private;
\ No newline at end of file
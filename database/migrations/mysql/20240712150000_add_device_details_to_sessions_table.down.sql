@@ -0,0 +1,7 @@
+DROP INDEX sessions_user_id_token ON sessions;
+
+ALTER TABLE sessions
+    DROP COLUMN device,
+    DROP COLUMN user_agent,
+    DROP COLUMN ip,
+    DROP COLUMN last_used_at;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE sessions
+    ADD COLUMN device VARCHAR(255),
+    ADD COLUMN user_agent VARCHAR(255),
+    ADD COLUMN ip VARCHAR(45),
+    ADD COLUMN last_used_at TIMESTAMP NULL;
+
+CREATE INDEX sessions_user_id_token ON sessions (user_id, token);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE sessions DROP COLUMN refresh_expires_at;
\ No newline at end of file
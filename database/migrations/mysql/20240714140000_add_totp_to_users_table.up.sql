@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(255),
+    ADD COLUMN totp_enabled BOOL DEFAULT FALSE NOT NULL,
+    ADD COLUMN totp_last_step BIGINT DEFAULT 0 NOT NULL;
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+
+    user_id VARCHAR(36) NOT NULL,
+    lookup VARCHAR(16) NOT NULL,
+    code VARCHAR(255) NOT NULL,
+    code_salt VARCHAR(255) NOT NULL,
+    used_at TIMESTAMP NULL,
+
+    INDEX (user_id, lookup)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP NULL;
\ No newline at end of file
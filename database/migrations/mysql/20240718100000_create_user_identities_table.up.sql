@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+
+    user_id VARCHAR(36) NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+
+    UNIQUE (issuer, subject),
+    INDEX (user_id)
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN bot,
+    DROP COLUMN owner_id;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN bot BOOL DEFAULT FALSE NOT NULL,
+    ADD COLUMN owner_id VARCHAR(36);
\ No newline at end of file
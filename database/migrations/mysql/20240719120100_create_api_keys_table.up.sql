@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+
+    user_id VARCHAR(36) NOT NULL,
+    created_by_id VARCHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    room_ids TEXT,
+    expires_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+
+    UNIQUE (token),
+    INDEX (user_id),
+    INDEX (created_by_id)
+);
\ No newline at end of file
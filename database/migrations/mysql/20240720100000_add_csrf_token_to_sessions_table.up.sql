@@ -0,0 +1,2 @@
+ALTER TABLE sessions
+    ADD COLUMN csrf_token VARCHAR(255);
\ No newline at end of file
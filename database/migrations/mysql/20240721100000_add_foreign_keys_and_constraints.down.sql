@@ -0,0 +1,31 @@
+ALTER TABLE api_keys
+    DROP FOREIGN KEY api_keys_user_id_fk,
+    DROP FOREIGN KEY api_keys_created_by_id_fk;
+ALTER TABLE user_identities
+    DROP FOREIGN KEY user_identities_user_id_fk;
+ALTER TABLE password_resets
+    DROP FOREIGN KEY password_resets_user_id_fk;
+ALTER TABLE recovery_codes
+    DROP FOREIGN KEY recovery_codes_user_id_fk;
+ALTER TABLE room_messages
+    DROP FOREIGN KEY room_messages_room_id_fk,
+    DROP FOREIGN KEY room_messages_user_id_fk;
+ALTER TABLE room_users
+    DROP FOREIGN KEY room_users_room_id_fk,
+    DROP FOREIGN KEY room_users_user_id_fk;
+ALTER TABLE room_mods
+    DROP FOREIGN KEY room_mods_room_id_fk,
+    DROP FOREIGN KEY room_mods_user_id_fk;
+ALTER TABLE refresh_tokens
+    DROP FOREIGN KEY refresh_tokens_session_id_fk;
+ALTER TABLE sessions
+    DROP FOREIGN KEY sessions_user_id_fk;
+
+ALTER TABLE room_mods DROP INDEX room_mods_room_id_user_id;
+ALTER TABLE room_users DROP INDEX room_users_room_id_user_id;
+
+DROP INDEX users_owner_id ON users;
+DROP INDEX room_messages_user_id ON room_messages;
+DROP INDEX room_users_user_id ON room_users;
+DROP INDEX room_mods_user_id ON room_mods;
+DROP INDEX refresh_tokens_session_id ON refresh_tokens;
@@ -0,0 +1,5 @@
+CREATE INDEX room_messages_room_id_created_at_id ON room_messages (room_id, created_at, id);
+DROP INDEX room_messages_room_id_seq ON room_messages;
+
+ALTER TABLE room_messages
+    DROP COLUMN seq;
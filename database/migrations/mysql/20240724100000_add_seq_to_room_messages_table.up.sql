@@ -0,0 +1,14 @@
+-- created_at only keeps whole seconds so messages are ordered by a sequence number instead, numbering existing
+-- messages in the order they were listed in before
+ALTER TABLE room_messages
+    ADD COLUMN seq BIGINT;
+UPDATE room_messages
+    JOIN (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS n FROM room_messages) numbered
+        ON numbered.id = room_messages.id
+    SET room_messages.seq = numbered.n;
+ALTER TABLE room_messages
+    MODIFY COLUMN seq BIGINT NOT NULL AUTO_INCREMENT,
+    ADD CONSTRAINT room_messages_seq UNIQUE (seq);
+
+CREATE INDEX room_messages_room_id_seq ON room_messages (room_id, seq);
+DROP INDEX room_messages_room_id_created_at_id ON room_messages;
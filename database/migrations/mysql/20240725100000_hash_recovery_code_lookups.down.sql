@@ -0,0 +1,4 @@
+-- hashed lookups can't be turned back into plaintext so the codes are dropped and have to be generated again
+DELETE FROM recovery_codes;
+ALTER TABLE recovery_codes
+    MODIFY COLUMN lookup VARCHAR(16) NOT NULL;
@@ -0,0 +1,5 @@
+-- lookups used to be the first half of the code, they're an HMAC now. The old ones are cleared so no part of a code
+-- is left in the database, codes without a lookup are checked one by one.
+ALTER TABLE recovery_codes
+    MODIFY COLUMN lookup VARCHAR(64) NOT NULL;
+UPDATE recovery_codes SET lookup = '';
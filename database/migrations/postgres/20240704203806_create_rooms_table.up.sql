@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS rooms (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMPTZ,
+
+    name VARCHAR(255) NOT NULL,
+    password_protected BOOL NOT NULL,
+    password VARCHAR(255),
+    password_salt VARCHAR(255)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS room_mods;
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS room_mods (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMPTZ,
+
+    user_id VARCHAR(36) NOT NULL,
+    room_id VARCHAR(36) NOT NULL,
+    role SMALLINT NOT NULL -- 0 = Owner (all perms), 1 = Moderator (Most perms)
+);
\ No newline at end of file
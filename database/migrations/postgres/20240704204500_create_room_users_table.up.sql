@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS room_users (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMPTZ,
+
+    room_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    muted BOOL DEFAULT FALSE NOT NULL
+);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE rooms ADD COLUMN private BOOL DEFAULT FALSE NOT NULL;
\ No newline at end of file
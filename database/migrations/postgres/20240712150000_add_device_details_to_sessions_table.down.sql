@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS sessions_user_id_token;
+
+ALTER TABLE sessions
+    DROP COLUMN device,
+    DROP COLUMN user_agent,
+    DROP COLUMN ip,
+    DROP COLUMN last_used_at;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP COLUMN totp_secret,
+    DROP COLUMN totp_enabled,
+    DROP COLUMN totp_last_step;
\ No newline at end of file
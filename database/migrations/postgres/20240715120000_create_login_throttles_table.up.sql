@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMPTZ,
+
+    throttle_key VARCHAR(320) NOT NULL,
+    failures INT DEFAULT 0 NOT NULL,
+    last_failed_at TIMESTAMPTZ,
+    locked_until TIMESTAMPTZ,
+
+    UNIQUE (throttle_key)
+);
\ No newline at end of file
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMPTZ,
+
+    user_id VARCHAR(36) NOT NULL,
+    created_by_id VARCHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    room_ids TEXT,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+
+    UNIQUE (token)
+);
+
+CREATE INDEX api_keys_user_id ON api_keys (user_id);
+CREATE INDEX api_keys_created_by_id ON api_keys (created_by_id);
@@ -0,0 +1,2 @@
+ALTER TABLE sessions
+    DROP COLUMN csrf_token;
\ No newline at end of file
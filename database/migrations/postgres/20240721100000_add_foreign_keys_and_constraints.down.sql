@@ -0,0 +1,31 @@
+ALTER TABLE api_keys
+    DROP CONSTRAINT api_keys_user_id_fk,
+    DROP CONSTRAINT api_keys_created_by_id_fk;
+ALTER TABLE user_identities
+    DROP CONSTRAINT user_identities_user_id_fk;
+ALTER TABLE password_resets
+    DROP CONSTRAINT password_resets_user_id_fk;
+ALTER TABLE recovery_codes
+    DROP CONSTRAINT recovery_codes_user_id_fk;
+ALTER TABLE room_messages
+    DROP CONSTRAINT room_messages_room_id_fk,
+    DROP CONSTRAINT room_messages_user_id_fk;
+ALTER TABLE room_users
+    DROP CONSTRAINT room_users_room_id_fk,
+    DROP CONSTRAINT room_users_user_id_fk;
+ALTER TABLE room_mods
+    DROP CONSTRAINT room_mods_room_id_fk,
+    DROP CONSTRAINT room_mods_user_id_fk;
+ALTER TABLE refresh_tokens
+    DROP CONSTRAINT refresh_tokens_session_id_fk;
+ALTER TABLE sessions
+    DROP CONSTRAINT sessions_user_id_fk;
+
+ALTER TABLE room_mods DROP CONSTRAINT room_mods_room_id_user_id;
+ALTER TABLE room_users DROP CONSTRAINT room_users_room_id_user_id;
+
+DROP INDEX IF EXISTS users_owner_id;
+DROP INDEX IF EXISTS room_messages_user_id;
+DROP INDEX IF EXISTS room_users_user_id;
+DROP INDEX IF EXISTS room_mods_user_id;
+DROP INDEX IF EXISTS refresh_tokens_session_id;
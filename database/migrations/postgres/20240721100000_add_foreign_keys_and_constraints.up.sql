@@ -0,0 +1,72 @@
+-- people leaving used to soft delete their membership, it's hard deleted now so they can join again
+DELETE FROM room_users WHERE deleted_at IS NOT NULL;
+DELETE FROM room_mods WHERE deleted_at IS NOT NULL;
+
+-- keep one membership per room and user, preferring the highest mod role
+DELETE FROM room_users u USING room_users other
+    WHERE other.room_id = u.room_id AND other.user_id = u.user_id AND other.id < u.id;
+DELETE FROM room_mods m USING room_mods other
+    WHERE other.room_id = m.room_id AND other.user_id = m.user_id
+        AND (other.role < m.role OR (other.role = m.role AND other.id < m.id));
+
+-- rows pointing at something that was never there or was hard deleted can't be kept
+DELETE FROM sessions WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM refresh_tokens WHERE session_id NOT IN (SELECT id FROM sessions);
+DELETE FROM room_mods WHERE room_id NOT IN (SELECT id FROM rooms) OR user_id NOT IN (SELECT id FROM users);
+DELETE FROM room_users WHERE room_id NOT IN (SELECT id FROM rooms) OR user_id NOT IN (SELECT id FROM users);
+DELETE FROM room_messages WHERE room_id NOT IN (SELECT id FROM rooms) OR user_id NOT IN (SELECT id FROM users);
+DELETE FROM recovery_codes WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM password_resets WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM user_identities WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM api_keys WHERE user_id NOT IN (SELECT id FROM users) OR created_by_id NOT IN (SELECT id FROM users);
+
+-- catch up on everything that should have been soft deleted along with its room or user
+UPDATE sessions SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND (
+    user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+);
+UPDATE room_mods SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND (
+    room_id IN (SELECT id FROM rooms WHERE deleted_at IS NOT NULL)
+    OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+);
+UPDATE room_users SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND (
+    room_id IN (SELECT id FROM rooms WHERE deleted_at IS NOT NULL)
+    OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+);
+UPDATE room_messages SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND (
+    room_id IN (SELECT id FROM rooms WHERE deleted_at IS NOT NULL)
+    OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+);
+UPDATE refresh_tokens SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL
+    AND session_id IN (SELECT id FROM sessions WHERE deleted_at IS NOT NULL);
+
+CREATE INDEX refresh_tokens_session_id ON refresh_tokens (session_id);
+CREATE INDEX room_mods_user_id ON room_mods (user_id);
+CREATE INDEX room_users_user_id ON room_users (user_id);
+CREATE INDEX room_messages_user_id ON room_messages (user_id);
+CREATE INDEX users_owner_id ON users (owner_id);
+
+-- users.owner_id is left without a foreign key because it's an empty string for everyone but bots
+ALTER TABLE sessions
+    ADD CONSTRAINT sessions_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens
+    ADD CONSTRAINT refresh_tokens_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE;
+ALTER TABLE room_mods
+    ADD CONSTRAINT room_mods_room_id_user_id UNIQUE (room_id, user_id),
+    ADD CONSTRAINT room_mods_room_id_fk FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    ADD CONSTRAINT room_mods_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE room_users
+    ADD CONSTRAINT room_users_room_id_user_id UNIQUE (room_id, user_id),
+    ADD CONSTRAINT room_users_room_id_fk FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    ADD CONSTRAINT room_users_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE room_messages
+    ADD CONSTRAINT room_messages_room_id_fk FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    ADD CONSTRAINT room_messages_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE recovery_codes
+    ADD CONSTRAINT recovery_codes_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE password_resets
+    ADD CONSTRAINT password_resets_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE user_identities
+    ADD CONSTRAINT user_identities_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE api_keys
+    ADD CONSTRAINT api_keys_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    ADD CONSTRAINT api_keys_created_by_id_fk FOREIGN KEY (created_by_id) REFERENCES users (id) ON DELETE CASCADE;
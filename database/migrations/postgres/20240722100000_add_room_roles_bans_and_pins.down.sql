@@ -0,0 +1,11 @@
+ALTER TABLE room_messages
+    DROP COLUMN pinned_at;
+
+DROP TABLE IF EXISTS room_bans;
+
+-- mods with a custom role go back to being plain mods
+UPDATE room_mods SET role = 1 WHERE role = 2;
+ALTER TABLE room_mods
+    DROP COLUMN role_id;
+
+DROP TABLE IF EXISTS room_roles;
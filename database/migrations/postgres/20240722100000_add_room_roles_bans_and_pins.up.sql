@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS room_roles (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMPTZ,
+
+    room_id VARCHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    permissions VARCHAR(255) NOT NULL,
+
+    CONSTRAINT room_roles_room_id_name UNIQUE (room_id, name),
+    CONSTRAINT room_roles_room_id_fk FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE
+);
+
+-- a role can't be deleted while mods still have it
+ALTER TABLE room_mods
+    ADD COLUMN role_id VARCHAR(36),
+    ADD CONSTRAINT room_mods_role_id_fk FOREIGN KEY (role_id) REFERENCES room_roles (id);
+CREATE INDEX room_mods_role_id ON room_mods (role_id);
+
+CREATE TABLE IF NOT EXISTS room_bans (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMPTZ,
+
+    room_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+
+    CONSTRAINT room_bans_room_id_user_id UNIQUE (room_id, user_id),
+    CONSTRAINT room_bans_room_id_fk FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    CONSTRAINT room_bans_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX room_bans_user_id ON room_bans (user_id);
+
+ALTER TABLE room_messages
+    ADD COLUMN pinned_at TIMESTAMPTZ;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS room_audit_entries (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMPTZ,
+
+    room_id VARCHAR(36) NOT NULL,
+    actor_id VARCHAR(36) NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    target_id VARCHAR(36) NOT NULL,
+
+    CONSTRAINT room_audit_entries_room_id_fk FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE
+);
+
+CREATE INDEX room_audit_entries_room_id_created_at ON room_audit_entries (room_id, created_at);
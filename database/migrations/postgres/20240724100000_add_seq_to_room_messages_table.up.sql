@@ -0,0 +1,17 @@
+-- messages are ordered by a sequence number instead of created_at and id, numbering existing messages in the order
+-- they were listed in before
+ALTER TABLE room_messages
+    ADD COLUMN seq BIGINT;
+UPDATE room_messages SET seq = numbered.n
+    FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS n FROM room_messages) AS numbered
+    WHERE numbered.id = room_messages.id;
+
+CREATE SEQUENCE room_messages_seq_seq OWNED BY room_messages.seq;
+SELECT setval('room_messages_seq_seq', COALESCE(MAX(seq), 0) + 1, false) FROM room_messages;
+ALTER TABLE room_messages
+    ALTER COLUMN seq SET DEFAULT nextval('room_messages_seq_seq'),
+    ALTER COLUMN seq SET NOT NULL,
+    ADD CONSTRAINT room_messages_seq UNIQUE (seq);
+
+CREATE INDEX room_messages_room_id_seq ON room_messages (room_id, seq);
+DROP INDEX IF EXISTS room_messages_room_id_created_at_id;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+
+    email VARCHAR(255),
+    name VARCHAR(255),
+    password VARCHAR(255),
+    password_salt VARCHAR(255),
+
+    UNIQUE (email)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS room_users;
\ No newline at end of file
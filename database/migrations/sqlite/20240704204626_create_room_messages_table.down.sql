@@ -0,0 +1 @@
+DROP TABLE IF EXISTS room_messages;
\ No newline at end of file
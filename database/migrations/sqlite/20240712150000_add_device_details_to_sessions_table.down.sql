@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS sessions_user_id_token;
+
+ALTER TABLE sessions DROP COLUMN device;
+ALTER TABLE sessions DROP COLUMN user_agent;
+ALTER TABLE sessions DROP COLUMN ip;
+ALTER TABLE sessions DROP COLUMN last_used_at;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE sessions ADD COLUMN device VARCHAR(255);
+ALTER TABLE sessions ADD COLUMN user_agent VARCHAR(255);
+ALTER TABLE sessions ADD COLUMN ip VARCHAR(45);
+ALTER TABLE sessions ADD COLUMN last_used_at TIMESTAMP;
+
+CREATE INDEX sessions_user_id_token ON sessions (user_id, token);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN refresh_expires_at TIMESTAMP;
+
+UPDATE sessions SET refresh_expires_at = expires_at;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(255);
+ALTER TABLE users ADD COLUMN totp_enabled BOOL DEFAULT FALSE NOT NULL;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT DEFAULT 0 NOT NULL;
\ No newline at end of file
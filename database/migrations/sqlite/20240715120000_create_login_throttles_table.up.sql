@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+
+    throttle_key VARCHAR(320) NOT NULL,
+    failures INT DEFAULT 0 NOT NULL,
+    last_failed_at TIMESTAMP,
+    locked_until TIMESTAMP,
+
+    UNIQUE (throttle_key)
+);
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+
+    user_id VARCHAR(36) NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id ON user_identities (user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN bot;
+ALTER TABLE users DROP COLUMN owner_id;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN bot BOOL DEFAULT FALSE NOT NULL;
+ALTER TABLE users ADD COLUMN owner_id VARCHAR(36);
\ No newline at end of file
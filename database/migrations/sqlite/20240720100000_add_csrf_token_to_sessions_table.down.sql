@@ -0,0 +1 @@
+ALTER TABLE sessions DROP COLUMN csrf_token;
\ No newline at end of file
@@ -0,0 +1,229 @@
+-- people leaving used to soft delete their membership, it's hard deleted now so they can join again
+DELETE FROM room_users WHERE deleted_at IS NOT NULL;
+DELETE FROM room_mods WHERE deleted_at IS NOT NULL;
+
+-- keep one membership per room and user, preferring the highest mod role
+DELETE FROM room_users WHERE EXISTS (
+    SELECT 1 FROM room_users other
+    WHERE other.room_id = room_users.room_id AND other.user_id = room_users.user_id AND other.id < room_users.id
+);
+DELETE FROM room_mods WHERE EXISTS (
+    SELECT 1 FROM room_mods other
+    WHERE other.room_id = room_mods.room_id AND other.user_id = room_mods.user_id
+        AND (other.role < room_mods.role OR (other.role = room_mods.role AND other.id < room_mods.id))
+);
+
+-- rows pointing at something that was never there or was hard deleted can't be kept
+DELETE FROM sessions WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM refresh_tokens WHERE session_id NOT IN (SELECT id FROM sessions);
+DELETE FROM room_mods WHERE room_id NOT IN (SELECT id FROM rooms) OR user_id NOT IN (SELECT id FROM users);
+DELETE FROM room_users WHERE room_id NOT IN (SELECT id FROM rooms) OR user_id NOT IN (SELECT id FROM users);
+DELETE FROM room_messages WHERE room_id NOT IN (SELECT id FROM rooms) OR user_id NOT IN (SELECT id FROM users);
+DELETE FROM recovery_codes WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM password_resets WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM user_identities WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM api_keys WHERE user_id NOT IN (SELECT id FROM users) OR created_by_id NOT IN (SELECT id FROM users);
+
+-- catch up on everything that should have been soft deleted along with its room or user
+UPDATE sessions SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND (
+    user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+);
+UPDATE room_mods SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND (
+    room_id IN (SELECT id FROM rooms WHERE deleted_at IS NOT NULL)
+    OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+);
+UPDATE room_users SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND (
+    room_id IN (SELECT id FROM rooms WHERE deleted_at IS NOT NULL)
+    OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+);
+UPDATE room_messages SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL AND (
+    room_id IN (SELECT id FROM rooms WHERE deleted_at IS NOT NULL)
+    OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+);
+UPDATE refresh_tokens SET deleted_at = CURRENT_TIMESTAMP WHERE deleted_at IS NULL
+    AND session_id IN (SELECT id FROM sessions WHERE deleted_at IS NOT NULL);
+
+-- sqlite can't add constraints to a table so each one is rebuilt, parents before children
+-- users.owner_id is left without a foreign key because it's an empty string for everyone but bots
+
+CREATE TABLE sessions_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    token VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    device VARCHAR(255),
+    user_agent VARCHAR(255),
+    ip VARCHAR(45),
+    last_used_at TIMESTAMP,
+    refresh_expires_at TIMESTAMP,
+    csrf_token VARCHAR(255),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO sessions_new (id, created_at, updated_at, deleted_at, token, expires_at, user_id, device, user_agent, ip, last_used_at, refresh_expires_at, csrf_token)
+    SELECT id, created_at, updated_at, deleted_at, token, expires_at, user_id, device, user_agent, ip, last_used_at, refresh_expires_at, csrf_token FROM sessions;
+DROP TABLE sessions;
+ALTER TABLE sessions_new RENAME TO sessions;
+CREATE INDEX sessions_user_id_token ON sessions (user_id, token);
+
+CREATE TABLE refresh_tokens_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    session_id VARCHAR(36) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+
+    UNIQUE (token),
+    FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE
+);
+INSERT INTO refresh_tokens_new (id, created_at, updated_at, deleted_at, session_id, token, expires_at, used_at)
+    SELECT id, created_at, updated_at, deleted_at, session_id, token, expires_at, used_at FROM refresh_tokens;
+DROP TABLE refresh_tokens;
+ALTER TABLE refresh_tokens_new RENAME TO refresh_tokens;
+CREATE INDEX refresh_tokens_session_id ON refresh_tokens (session_id);
+
+CREATE TABLE room_mods_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    user_id VARCHAR(36) NOT NULL,
+    room_id VARCHAR(36) NOT NULL,
+    role TINYINT NOT NULL,
+
+    UNIQUE (room_id, user_id),
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO room_mods_new (id, created_at, updated_at, deleted_at, user_id, room_id, role)
+    SELECT id, created_at, updated_at, deleted_at, user_id, room_id, role FROM room_mods;
+DROP TABLE room_mods;
+ALTER TABLE room_mods_new RENAME TO room_mods;
+CREATE INDEX room_mods_user_id ON room_mods (user_id);
+
+CREATE TABLE room_users_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    room_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    muted BOOL DEFAULT FALSE NOT NULL,
+
+    UNIQUE (room_id, user_id),
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO room_users_new (id, created_at, updated_at, deleted_at, room_id, user_id, muted)
+    SELECT id, created_at, updated_at, deleted_at, room_id, user_id, muted FROM room_users;
+DROP TABLE room_users;
+ALTER TABLE room_users_new RENAME TO room_users;
+CREATE INDEX room_users_user_id ON room_users (user_id);
+
+CREATE TABLE room_messages_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    room_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    message TEXT NOT NULL,
+
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO room_messages_new (id, created_at, updated_at, deleted_at, room_id, user_id, message)
+    SELECT id, created_at, updated_at, deleted_at, room_id, user_id, message FROM room_messages;
+DROP TABLE room_messages;
+ALTER TABLE room_messages_new RENAME TO room_messages;
+CREATE INDEX room_messages_room_id_created_at_id ON room_messages (room_id, created_at, id);
+CREATE INDEX room_messages_user_id ON room_messages (user_id);
+
+CREATE TABLE recovery_codes_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    user_id VARCHAR(36) NOT NULL,
+    lookup VARCHAR(16) NOT NULL,
+    code VARCHAR(255) NOT NULL,
+    code_salt VARCHAR(255) NOT NULL,
+    used_at TIMESTAMP,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO recovery_codes_new (id, created_at, updated_at, deleted_at, user_id, lookup, code, code_salt, used_at)
+    SELECT id, created_at, updated_at, deleted_at, user_id, lookup, code, code_salt, used_at FROM recovery_codes;
+DROP TABLE recovery_codes;
+ALTER TABLE recovery_codes_new RENAME TO recovery_codes;
+CREATE INDEX recovery_codes_user_id_lookup ON recovery_codes (user_id, lookup);
+
+CREATE TABLE password_resets_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    user_id VARCHAR(36) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+
+    UNIQUE (token),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO password_resets_new (id, created_at, updated_at, deleted_at, user_id, token, expires_at, used_at)
+    SELECT id, created_at, updated_at, deleted_at, user_id, token, expires_at, used_at FROM password_resets;
+DROP TABLE password_resets;
+ALTER TABLE password_resets_new RENAME TO password_resets;
+CREATE INDEX password_resets_user_id ON password_resets (user_id);
+
+CREATE TABLE user_identities_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    user_id VARCHAR(36) NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+
+    UNIQUE (issuer, subject),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO user_identities_new (id, created_at, updated_at, deleted_at, user_id, issuer, subject)
+    SELECT id, created_at, updated_at, deleted_at, user_id, issuer, subject FROM user_identities;
+DROP TABLE user_identities;
+ALTER TABLE user_identities_new RENAME TO user_identities;
+CREATE INDEX user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE api_keys_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    user_id VARCHAR(36) NOT NULL,
+    created_by_id VARCHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    room_ids TEXT,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+
+    UNIQUE (token),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO api_keys_new (id, created_at, updated_at, deleted_at, user_id, created_by_id, name, token, scopes, room_ids, expires_at, last_used_at)
+    SELECT id, created_at, updated_at, deleted_at, user_id, created_by_id, name, token, scopes, room_ids, expires_at, last_used_at FROM api_keys;
+DROP TABLE api_keys;
+ALTER TABLE api_keys_new RENAME TO api_keys;
+CREATE INDEX api_keys_user_id ON api_keys (user_id);
+CREATE INDEX api_keys_created_by_id ON api_keys (created_by_id);
+
+CREATE INDEX users_owner_id ON users (owner_id);
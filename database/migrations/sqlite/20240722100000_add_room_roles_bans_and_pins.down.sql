@@ -0,0 +1,28 @@
+ALTER TABLE room_messages DROP COLUMN pinned_at;
+
+DROP TABLE IF EXISTS room_bans;
+
+-- mods with a custom role go back to being plain mods
+UPDATE room_mods SET role = 1 WHERE role = 2;
+
+-- sqlite can't drop a column with a foreign key so room_mods is rebuilt without it
+CREATE TABLE room_mods_new (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+    user_id VARCHAR(36) NOT NULL,
+    room_id VARCHAR(36) NOT NULL,
+    role TINYINT NOT NULL,
+
+    UNIQUE (room_id, user_id),
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO room_mods_new (id, created_at, updated_at, deleted_at, user_id, room_id, role)
+    SELECT id, created_at, updated_at, deleted_at, user_id, room_id, role FROM room_mods;
+DROP TABLE room_mods;
+ALTER TABLE room_mods_new RENAME TO room_mods;
+CREATE INDEX room_mods_user_id ON room_mods (user_id);
+
+DROP TABLE IF EXISTS room_roles;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS room_roles (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+
+    room_id VARCHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    permissions VARCHAR(255) NOT NULL,
+
+    UNIQUE (room_id, name),
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE
+);
+
+-- a role can't be deleted while mods still have it
+ALTER TABLE room_mods ADD COLUMN role_id VARCHAR(36) REFERENCES room_roles (id);
+CREATE INDEX room_mods_role_id ON room_mods (role_id);
+
+CREATE TABLE IF NOT EXISTS room_bans (
+    id VARCHAR(36) PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP,
+
+    room_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+
+    UNIQUE (room_id, user_id),
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX room_bans_user_id ON room_bans (user_id);
+
+ALTER TABLE room_messages ADD COLUMN pinned_at TIMESTAMP;
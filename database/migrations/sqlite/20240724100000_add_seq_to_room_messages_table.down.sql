@@ -0,0 +1,6 @@
+CREATE INDEX room_messages_room_id_created_at_id ON room_messages (room_id, created_at, id);
+DROP INDEX IF EXISTS room_messages_room_id_seq;
+DROP INDEX IF EXISTS room_messages_seq;
+DROP TRIGGER IF EXISTS room_messages_set_seq;
+
+ALTER TABLE room_messages DROP COLUMN seq;
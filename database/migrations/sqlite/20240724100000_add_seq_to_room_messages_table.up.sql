@@ -0,0 +1,17 @@
+-- messages are ordered by a sequence number instead of created_at and id, numbering existing messages in the order
+-- they were listed in before
+ALTER TABLE room_messages ADD COLUMN seq INTEGER;
+UPDATE room_messages SET seq = numbered.n
+    FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS n FROM room_messages) AS numbered
+    WHERE numbered.id = room_messages.id;
+CREATE UNIQUE INDEX room_messages_seq ON room_messages (seq);
+
+-- sqlite can't add a column that numbers itself so new messages are numbered here, writes are serialized so the
+-- numbers only ever go up
+CREATE TRIGGER room_messages_set_seq AFTER INSERT ON room_messages WHEN NEW.seq IS NULL
+BEGIN
+    UPDATE room_messages SET seq = (SELECT COALESCE(MAX(seq), 0) + 1 FROM room_messages) WHERE id = NEW.id;
+END;
+
+CREATE INDEX room_messages_room_id_seq ON room_messages (room_id, seq);
+DROP INDEX IF EXISTS room_messages_room_id_created_at_id;
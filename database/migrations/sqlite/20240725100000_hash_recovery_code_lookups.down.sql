@@ -0,0 +1,2 @@
+-- hashed lookups can't be turned back into plaintext so the codes are dropped and have to be generated again
+DELETE FROM recovery_codes;
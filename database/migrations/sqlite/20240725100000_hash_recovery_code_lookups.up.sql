@@ -0,0 +1,3 @@
+-- lookups used to be the first half of the code, they're an HMAC now. The old ones are cleared so no part of a code
+-- is left in the database, codes without a lookup are checked one by one. sqlite doesn't enforce the column's length.
+UPDATE recovery_codes SET lookup = '';
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRoomsRead     = "rooms:read"
+	ScopeRoomsWrite    = "rooms:write"
+	ScopeModsWrite     = "mods:write"
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+)
+
+var Scopes = []string{ScopeRoomsRead, ScopeRoomsWrite, ScopeModsWrite, ScopeMessagesRead, ScopeMessagesWrite}
+
+// APIKey is a long-lived credential that acts as UserID, which is either the person who made it or one of their bots.
+// Scopes and RoomIDs are space separated. An empty RoomIDs means every room.
+type APIKey struct {
+	GivenFields
+
+	UserID      string
+	CreatedByID string
+	Name        string
+	Token       string
+	Scopes      string
+	RoomIDs     string
+	ExpiresAt   *time.Time
+	LastUsedAt  *time.Time
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range strings.Fields(k.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k APIKey) AllowsRoom(roomID string) bool {
+	rooms := strings.Fields(k.RoomIDs)
+	if len(rooms) == 0 {
+		return true
+	}
+
+	for _, r := range rooms {
+		if r == roomID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+type LoginThrottle struct {
+	GivenFields
+
+	ThrottleKey  string
+	Failures     int
+	LastFailedAt *time.Time
+	LockedUntil  *time.Time
+}
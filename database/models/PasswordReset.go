@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+type PasswordReset struct {
+	GivenFields
+
+	UserID    string
+	Token     string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+type RecoveryCode struct {
+	GivenFields
+
+	UserID string
+	// Lookup is an HMAC of the code under APP_KEY so it can be found without storing any part of it
+	Lookup   string
+	Code     string
+	CodeSalt string
+	UsedAt   *time.Time
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+type RefreshToken struct {
+	GivenFields
+
+	SessionID string
+	Token     string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
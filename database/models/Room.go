@@ -5,6 +5,7 @@ type Room struct {
 
 	Name              string
 	PasswordProtected bool
+	Private           bool
 	Password          string
 	PasswordSalt      string
 }
@@ -0,0 +1,16 @@
+package models
+
+const (
+	AuditOwnershipTransferred = "ownership_transferred"
+)
+
+// RoomAuditEntry records something done in a room that its owners should be able to look back on. ActorID did Action
+// to TargetID. Neither is a foreign key so entries outlive the users in them.
+type RoomAuditEntry struct {
+	GivenFields
+
+	RoomID   string
+	ActorID  string
+	Action   string
+	TargetID string
+}
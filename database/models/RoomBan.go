@@ -0,0 +1,9 @@
+package models
+
+// RoomBan keeps UserID out of RoomID until it's lifted
+type RoomBan struct {
+	GivenFields
+
+	RoomID string
+	UserID string
+}
@@ -1,9 +1,16 @@
 package models
 
+import (
+	"time"
+)
+
 type RoomMessage struct {
 	GivenFields
 
-	RoomID  string
-	UserID  string
-	Message string
+	// Seq is set by the database and only ever goes up, messages are ordered by it
+	Seq      int64 `gorm:"<-:false"`
+	RoomID   string
+	UserID   string
+	Message  string
+	PinnedAt *time.Time
 }
@@ -3,6 +3,8 @@ package models
 const (
 	RoomModRoleOwner = iota
 	RoomModRoleMod
+	// RoomModRoleCustom mods can do whatever the RoomRole in RoleID allows
+	RoomModRoleCustom
 )
 
 type RoomMod struct {
@@ -11,4 +13,5 @@ type RoomMod struct {
 	UserID string
 	RoomID string
 	Role   int
+	RoleID *string
 }
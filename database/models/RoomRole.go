@@ -0,0 +1,34 @@
+package models
+
+const (
+	PermissionManageRoom     = "manage-room"
+	PermissionManageMods     = "manage-mods"
+	PermissionKick           = "kick"
+	PermissionBan            = "ban"
+	PermissionMute           = "mute"
+	PermissionDeleteMessages = "delete-messages"
+	PermissionPin            = "pin"
+)
+
+// Permissions are everything a mod can be allowed to do in a room. Owners can do all of it.
+var Permissions = []string{
+	PermissionManageRoom,
+	PermissionManageMods,
+	PermissionKick,
+	PermissionBan,
+	PermissionMute,
+	PermissionDeleteMessages,
+	PermissionPin,
+}
+
+// ModPermissions are what mods with the built in RoomModRoleMod role can do
+var ModPermissions = []string{PermissionKick, PermissionMute, PermissionDeleteMessages, PermissionPin}
+
+// RoomRole is a set of permissions defined for one room that its mods can be given. Permissions is space separated.
+type RoomRole struct {
+	GivenFields
+
+	RoomID      string
+	Name        string
+	Permissions string
+}
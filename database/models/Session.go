@@ -10,4 +10,12 @@ type Session struct {
 	Token     string
 	ExpiresAt time.Time
 	UserID    string
+	// RefreshExpiresAt is when the session can no longer be refreshed, ExpiresAt is only for the current access token
+	RefreshExpiresAt time.Time
+	Device           string
+	UserAgent        string
+	IP               string `gorm:"column:ip"`
+	LastUsedAt       *time.Time
+	// CSRFToken is the hashed CSRF token of a session kept in a cookie, it's empty for sessions using bearer tokens
+	CSRFToken string `gorm:"column:csrf_token"`
 }
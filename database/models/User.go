@@ -1,10 +1,21 @@
 package models
 
+import (
+	"time"
+)
+
 type User struct {
 	GivenFields
 
-	Email        string
-	Name         string
-	Password     string
-	PasswordSalt string
+	Email           string
+	Name            string
+	Password        string
+	PasswordSalt    string
+	EmailVerifiedAt *time.Time
+	Bot             bool
+	// OwnerID is the user that made this bot
+	OwnerID      string
+	TOTPSecret   string `gorm:"column:totp_secret"`
+	TOTPEnabled  bool   `gorm:"column:totp_enabled"`
+	TOTPLastStep int64  `gorm:"column:totp_last_step"`
 }
@@ -0,0 +1,10 @@
+package models
+
+// UserIdentity links a user to an account at an outside identity provider
+type UserIdentity struct {
+	GivenFields
+
+	UserID  string
+	Issuer  string
+	Subject string
+}
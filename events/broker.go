@@ -0,0 +1,69 @@
+package events
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Broker carries published events to the Hub of every API instance, including the one that published them.
+type Broker interface {
+	Publish(e Event) error
+	Close() error
+}
+
+var GBroker Broker = NewMemoryBroker(GHub)
+
+// MemoryBroker hands events straight to a local Hub. It's all that's needed when only one instance is running.
+type MemoryBroker struct {
+	hub *Hub
+}
+
+func NewMemoryBroker(hub *Hub) *MemoryBroker {
+	return &MemoryBroker{
+		hub: hub,
+	}
+}
+
+func (b *MemoryBroker) Publish(e Event) error {
+	b.hub.Publish(e)
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// InitBroker sets GBroker using EVENTS_BROKER, which is either "memory" (the default) or "redis".
+func InitBroker() (Broker, error) {
+	var broker Broker
+
+	switch os.Getenv("EVENTS_BROKER") {
+	case "", "memory":
+		broker = NewMemoryBroker(GHub)
+	case "redis":
+		channel := os.Getenv("REDIS_CHANNEL")
+		if channel == "" {
+			channel = "superchat:events"
+		}
+
+		b, err := NewRedisBroker(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASS"), channel, GHub)
+		if err != nil {
+			return nil, err
+		}
+		broker = b
+	default:
+		return nil, fmt.Errorf("unknown EVENTS_BROKER %q", os.Getenv("EVENTS_BROKER"))
+	}
+
+	GBroker = broker
+
+	return broker, nil
+}
+
+// Publish sends e out through GBroker. Events are best effort so failures are only logged.
+func Publish(e Event) {
+	if err := GBroker.Publish(e); err != nil {
+		log.Println("couldn't publish event:", err)
+	}
+}
@@ -0,0 +1,147 @@
+package events
+
+import (
+	"github.com/google/uuid"
+	"sync"
+)
+
+const (
+	MessageCreated = "message_created"
+	MessageUpdated = "message_updated"
+	MessageDeleted = "message_deleted"
+	MemberJoined   = "member_joined"
+	MemberLeft     = "member_left"
+	MemberMuted    = "member_muted"
+	BanChanged     = "ban_changed"
+	ModChanged     = "mod_changed"
+	RoleChanged    = "role_changed"
+	RoomUpdated    = "room_updated"
+)
+
+// subscriptionBuffer is how many events can queue up for a subscription before it is considered too slow and is
+// dropped from the hub.
+const subscriptionBuffer = 64
+
+type Event struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	RoomID string `json:"roomID"`
+	Data   any    `json:"data"`
+}
+
+// Hub fans events for a room out to every subscription listening to that room.
+type Hub struct {
+	mu    sync.RWMutex
+	rooms map[string]map[*Subscription]struct{}
+}
+
+// Subscription receives events for any number of rooms on C. C is closed when the subscription is closed, either by
+// the caller or by the hub if the subscription falls too far behind.
+type Subscription struct {
+	C chan Event
+
+	hub    *Hub
+	rooms  map[string]struct{}
+	closed bool
+}
+
+var GHub = NewHub()
+
+// NewEvent returns an event with a freshly generated ID
+func NewEvent(eventType string, roomID string, data any) Event {
+	return Event{
+		ID:     uuid.New().String(),
+		Type:   eventType,
+		RoomID: roomID,
+		Data:   data,
+	}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		rooms: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+func (h *Hub) NewSubscription() *Subscription {
+	return &Subscription{
+		C:     make(chan Event, subscriptionBuffer),
+		hub:   h,
+		rooms: make(map[string]struct{}),
+	}
+}
+
+// Publish sends e to every subscription listening to e.RoomID without blocking.
+func (h *Hub) Publish(e Event) {
+	h.mu.RLock()
+	var slow []*Subscription
+	for s := range h.rooms[e.RoomID] {
+		select {
+		case s.C <- e:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, s := range slow {
+		s.Close()
+	}
+}
+
+func (s *Subscription) Subscribe(roomID string) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if s.hub.rooms[roomID] == nil {
+		s.hub.rooms[roomID] = make(map[*Subscription]struct{})
+	}
+	s.hub.rooms[roomID][s] = struct{}{}
+	s.rooms[roomID] = struct{}{}
+}
+
+func (s *Subscription) Unsubscribe(roomID string) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.remove(roomID)
+}
+
+// Subscribed reports whether the subscription is still listening to roomID. Events for a room can already be waiting
+// on C when it's unsubscribed from.
+func (s *Subscription) Subscribed(roomID string) bool {
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+
+	_, ok := s.rooms[roomID]
+	return ok
+}
+
+// Close removes the subscription from every room and closes C. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	for roomID := range s.rooms {
+		s.remove(roomID)
+	}
+	s.closed = true
+	close(s.C)
+}
+
+// remove must be called with the hub lock held
+func (s *Subscription) remove(roomID string) {
+	delete(s.rooms, roomID)
+	delete(s.hub.rooms[roomID], s)
+	if len(s.hub.rooms[roomID]) == 0 {
+		delete(s.hub.rooms, roomID)
+	}
+}
@@ -0,0 +1,63 @@
+package events
+
+import "testing"
+
+func Test_Hub_Publish(t *testing.T) {
+	h := NewHub()
+
+	a := h.NewSubscription()
+	b := h.NewSubscription()
+	a.Subscribe("room1")
+	b.Subscribe("room2")
+
+	h.Publish(NewEvent(MessageCreated, "room1", nil))
+
+	select {
+	case e := <-a.C:
+		if e.RoomID != "room1" {
+			t.Errorf("got event for %s, expected room1", e.RoomID)
+		}
+	default:
+		t.Error("subscriber to room1 didn't get the event")
+	}
+
+	select {
+	case <-b.C:
+		t.Error("subscriber to room2 got an event for room1")
+	default:
+	}
+
+	a.Unsubscribe("room1")
+	if a.Subscribed("room1") {
+		t.Error("unsubscribed subscriber still thinks it's subscribed")
+	}
+	h.Publish(NewEvent(MessageCreated, "room1", nil))
+	select {
+	case <-a.C:
+		t.Error("unsubscribed subscriber still got an event")
+	default:
+	}
+}
+
+func Test_Hub_SlowSubscriber(t *testing.T) {
+	h := NewHub()
+
+	s := h.NewSubscription()
+	s.Subscribe("room1")
+
+	for i := 0; i < subscriptionBuffer+1; i++ {
+		h.Publish(NewEvent(MessageCreated, "room1", nil))
+	}
+
+	count := 0
+	for range s.C {
+		count++
+	}
+
+	if count != subscriptionBuffer {
+		t.Errorf("got %d events, expected %d before being dropped", count, subscriptionBuffer)
+	}
+
+	// closing twice shouldn't panic
+	s.Close()
+}
@@ -0,0 +1,382 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout   = 5 * time.Second
+	redisMaxRetryDelay = 30 * time.Second
+	// redisPublishQueue is how many events can be waiting to go out before Publish starts dropping them
+	redisPublishQueue = 1024
+)
+
+// redisCommandTimeout is how long a command gets to be sent and answered before its connection is given up on
+var redisCommandTimeout = 5 * time.Second
+
+var errRedisQueueFull = errors.New("redis publish queue is full")
+
+// RedisBroker shares events between instances using Redis PUBLISH/SUBSCRIBE on a single channel. It only speaks the
+// handful of RESP commands it needs so it works with Redis and anything else that talks the protocol.
+//
+// Publish only queues events, a single goroutine sends them in order. A slow or hung Redis delays events instead of
+// the requests that publish them.
+type RedisBroker struct {
+	addr     string
+	password string
+	channel  string
+	hub      *Hub
+
+	queue   chan string
+	stop    chan struct{}
+	pubDone chan struct{}
+	// pubConn is only touched by the publishing goroutine
+	pubConn *redisConn
+
+	mu      sync.Mutex
+	subConn *redisConn
+	closed  bool
+	done    chan struct{}
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedisBroker connects to the Redis server at addr and starts relaying events published on channel to hub.
+func NewRedisBroker(addr string, password string, channel string, hub *Hub) (*RedisBroker, error) {
+	b := &RedisBroker{
+		addr:     addr,
+		password: password,
+		channel:  channel,
+		hub:      hub,
+		queue:    make(chan string, redisPublishQueue),
+		stop:     make(chan struct{}),
+		pubDone:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	sub, err := b.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	b.subConn = sub
+
+	go b.listen(sub)
+	go b.publishLoop()
+
+	return b, nil
+}
+
+// Publish queues e to be sent to Redis. It never waits on Redis, if the queue is full e is dropped.
+func (b *RedisBroker) Publish(e Event) error {
+	if b.isClosed() {
+		return errors.New("redis broker is closed")
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case b.queue <- string(payload):
+		return nil
+	default:
+		return errRedisQueueFull
+	}
+}
+
+// publishLoop sends queued events until the broker is closed, then sends whatever is left
+func (b *RedisBroker) publishLoop() {
+	defer close(b.pubDone)
+
+	for {
+		select {
+		case payload := <-b.queue:
+			b.send(payload)
+		case <-b.stop:
+			for {
+				select {
+				case payload := <-b.queue:
+					b.send(payload)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *RedisBroker) send(payload string) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pubConn == nil {
+			b.pubConn, err = b.dial()
+			if err != nil {
+				break
+			}
+		}
+
+		b.pubConn.conn.SetDeadline(time.Now().Add(redisCommandTimeout))
+		if err = b.pubConn.write("PUBLISH", b.channel, payload); err != nil {
+			// nothing went out so it's safe to try again on a fresh connection, in case this one went stale
+			b.closePubConn()
+			continue
+		}
+
+		// once it's written Redis may have published it, sending it again could deliver it twice
+		if _, err = b.pubConn.read(); err != nil {
+			b.closePubConn()
+		}
+		break
+	}
+
+	if err != nil {
+		log.Println("redis broker couldn't publish event:", err)
+	}
+}
+
+func (b *RedisBroker) closePubConn() {
+	b.pubConn.conn.Close()
+	b.pubConn = nil
+}
+
+func (b *RedisBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	if b.subConn != nil {
+		b.subConn.conn.Close()
+	}
+	b.mu.Unlock()
+
+	<-b.done
+
+	close(b.stop)
+	<-b.pubDone
+	if b.pubConn != nil {
+		b.closePubConn()
+	}
+
+	return nil
+}
+
+// listen relays messages from sub to the hub, reconnecting with backoff whenever the connection drops
+func (b *RedisBroker) listen(sub *redisConn) {
+	defer close(b.done)
+
+	delay := time.Second
+	for {
+		if sub != nil {
+			err := b.relay(sub)
+			sub.conn.Close()
+			if b.isClosed() {
+				return
+			}
+			log.Println("redis broker lost subscription:", err)
+			delay = time.Second
+		}
+
+		time.Sleep(delay)
+		if b.isClosed() {
+			return
+		}
+
+		var err error
+		sub, err = b.subscribe()
+		if err != nil {
+			log.Println("redis broker resubscribe:", err)
+			delay = min(delay*2, redisMaxRetryDelay)
+			continue
+		}
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			sub.conn.Close()
+			return
+		}
+		b.subConn = sub
+		b.mu.Unlock()
+	}
+}
+
+func (b *RedisBroker) relay(sub *redisConn) error {
+	for {
+		reply, err := sub.read()
+		if err != nil {
+			return err
+		}
+
+		// pushed messages look like ["message", channel, payload]
+		parts, ok := reply.([]any)
+		if !ok || len(parts) != 3 || parts[0] != "message" {
+			continue
+		}
+
+		payload, ok := parts[2].(string)
+		if !ok {
+			continue
+		}
+
+		var e Event
+		if err := json.Unmarshal([]byte(payload), &e); err != nil {
+			log.Println("redis broker got bad event:", err)
+			continue
+		}
+
+		b.hub.Publish(e)
+	}
+}
+
+func (b *RedisBroker) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *RedisBroker) subscribe() (*redisConn, error) {
+	c, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	// the deadline only covers subscribing, after that the connection waits as long as it takes for messages
+	c.conn.SetDeadline(time.Now().Add(redisCommandTimeout))
+	if err := c.write("SUBSCRIBE", b.channel); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+
+	// wait for ["subscribe", channel, count] so no events are missed after we return
+	reply, err := c.read()
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	if parts, ok := reply.([]any); !ok || len(parts) == 0 || parts[0] != "subscribe" {
+		c.conn.Close()
+		return nil, fmt.Errorf("unexpected reply to SUBSCRIBE: %v", reply)
+	}
+	c.conn.SetDeadline(time.Time{})
+
+	return c, nil
+}
+
+func (b *RedisBroker) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+
+	if b.password != "" {
+		if err := c.do("AUTH", b.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// do sends a command and reads a single reply, returning the reply if it was an error. It gives up after
+// redisCommandTimeout.
+func (c *redisConn) do(args ...string) error {
+	c.conn.SetDeadline(time.Now().Add(redisCommandTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := c.write(args...); err != nil {
+		return err
+	}
+
+	_, err := c.read()
+	return err
+}
+
+func (c *redisConn) write(args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// read parses one RESP reply. Simple and bulk strings come back as string, integers as int64, arrays as []any and
+// nil bulk strings/arrays as nil. Error replies are returned as errors.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed redis reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		out := make([]any, n)
+		for i := range out {
+			if out[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("unknown redis reply type %q", line[0])
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a tiny stand-in for a Redis server that understands just enough PUBLISH/SUBSCRIBE for RedisBroker
+type fakeRedis struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	subs map[string][]net.Conn
+	// publishes counts every PUBLISH received, hang makes them go unanswered like a server that stopped responding
+	publishes int
+	hang      bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{
+		ln:   ln,
+		subs: make(map[string][]net.Conn),
+	}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return
+		}
+
+		parts, _ := reply.([]any)
+		args := make([]string, len(parts))
+		for i, p := range parts {
+			args[i], _ = p.(string)
+		}
+
+		switch args[0] {
+		case "SUBSCRIBE":
+			f.mu.Lock()
+			f.subs[args[1]] = append(f.subs[args[1]], conn)
+			f.mu.Unlock()
+			writeArray(conn, "subscribe", args[1], ":1")
+		case "PUBLISH":
+			f.mu.Lock()
+			f.publishes++
+			if f.hang {
+				f.mu.Unlock()
+				continue
+			}
+			subs := f.subs[args[1]]
+			for _, s := range subs {
+				writeArray(s, "message", args[1], args[2])
+			}
+			f.mu.Unlock()
+			conn.Write([]byte(":" + strconv.Itoa(len(subs)) + "\r\n"))
+		default:
+			conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+	}
+}
+
+// writeArray writes a RESP array of bulk strings, or an integer if the value starts with ':'
+func writeArray(conn net.Conn, values ...string) {
+	out := "*" + strconv.Itoa(len(values)) + "\r\n"
+	for _, v := range values {
+		if v[0] == ':' {
+			out += v + "\r\n"
+			continue
+		}
+		out += "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	}
+	conn.Write([]byte(out))
+}
+
+func Test_RedisBroker_AcrossInstances(t *testing.T) {
+	f := newFakeRedis(t)
+
+	hubA := NewHub()
+	brokerA, err := NewRedisBroker(f.ln.Addr().String(), "", "test", hubA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer brokerA.Close()
+
+	hubB := NewHub()
+	brokerB, err := NewRedisBroker(f.ln.Addr().String(), "", "test", hubB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer brokerB.Close()
+
+	subB := hubB.NewSubscription()
+	subB.Subscribe("room1")
+
+	sent := NewEvent(MessageCreated, "room1", map[string]any{"message": "hi"})
+	if err := brokerA.Publish(sent); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-subB.C:
+		if got.ID != sent.ID || got.Type != sent.Type || got.RoomID != sent.RoomID {
+			t.Errorf("got %+v, expected %+v", got, sent)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event published on instance A never reached instance B")
+	}
+}
+
+func Test_RedisConn_ErrorReply(t *testing.T) {
+	f := newFakeRedis(t)
+
+	b := &RedisBroker{addr: f.ln.Addr().String()}
+	c, err := b.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.conn.Close()
+
+	if err := c.do("FLUSHALL"); err == nil {
+		t.Error("expected an error reply for an unknown command")
+	}
+}
+
+func Test_RedisBroker_HungServer(t *testing.T) {
+	defer func(timeout time.Duration) { redisCommandTimeout = timeout }(redisCommandTimeout)
+	redisCommandTimeout = 100 * time.Millisecond
+
+	f := newFakeRedis(t)
+	f.hang = true
+
+	b, err := NewRedisBroker(f.ln.Addr().String(), "", "test", NewHub())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for range 3 {
+		if err := b.Publish(NewEvent(MessageCreated, "room1", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if waited := time.Since(start); waited > 50*time.Millisecond {
+		t.Errorf("Publish shouldn't wait on Redis, it took %s", waited)
+	}
+
+	// closing sends what's queued, each one giving up on its own
+	b.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.publishes != 3 {
+		t.Errorf("Every event should be sent exactly once even when Redis doesn't answer, got %d PUBLISHes", f.publishes)
+	}
+}
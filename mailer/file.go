@@ -0,0 +1,25 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes each message to its own .eml file in Dir instead of sending it. It's handy for local development.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (f *FileMailer) Send(m Message) error {
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102150405"), uuid.New().String())
+
+	return os.WriteFile(filepath.Join(f.Dir, name), render(f.From, m), 0o644)
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(m Message) error
+}
+
+// GMailer starts out as a MemoryMailer so tests can send mail without setting anything up
+var GMailer Mailer = NewMemoryMailer()
+
+// InitMailer sets GMailer using MAILER, which is either "smtp" or "file". It has to be set, there's no default that
+// would quietly drop verification and password reset emails.
+func InitMailer() (Mailer, error) {
+	var m Mailer
+
+	switch os.Getenv("MAILER") {
+	case "":
+		return nil, errors.New(`MAILER has to be set to "smtp" or "file"`)
+	case "smtp":
+		if os.Getenv("SMTP_HOST") == "" {
+			return nil, errors.New("SMTP_HOST has to be set when MAILER=smtp")
+		}
+		m = &SMTPMailer{
+			Host: os.Getenv("SMTP_HOST"),
+			Port: os.Getenv("SMTP_PORT"),
+			User: os.Getenv("SMTP_USER"),
+			Pass: os.Getenv("SMTP_PASS"),
+			From: os.Getenv("MAIL_FROM"),
+		}
+	case "file":
+		dir := os.Getenv("MAILER_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		m = &FileMailer{
+			Dir:  dir,
+			From: os.Getenv("MAIL_FROM"),
+		}
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", os.Getenv("MAILER"))
+	}
+
+	GMailer = m
+
+	return m, nil
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"testing"
+)
+
+func Test_InitMailer(t *testing.T) {
+	defer func(m Mailer) { GMailer = m }(GMailer)
+
+	for _, mailer := range []string{"", "memory", "carrier pigeon"} {
+		t.Setenv("MAILER", mailer)
+		if _, err := InitMailer(); err == nil {
+			t.Errorf("MAILER=%q should be refused", mailer)
+		}
+	}
+
+	t.Setenv("MAILER", "smtp")
+	t.Setenv("SMTP_HOST", "")
+	if _, err := InitMailer(); err == nil {
+		t.Error("MAILER=smtp should need SMTP_HOST")
+	}
+
+	t.Setenv("MAILER", "file")
+	t.Setenv("MAILER_DIR", t.TempDir())
+	m, err := InitMailer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*FileMailer); !ok || GMailer != m {
+		t.Errorf("MAILER=file should set up a FileMailer, got %T", m)
+	}
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"sync"
+)
+
+// MemoryMailer keeps every message it's asked to send so tests can look at them
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (mm *MemoryMailer) Send(m Message) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.messages = append(mm.messages, m)
+
+	return nil
+}
+
+// Messages returns a copy of everything sent so far
+func (mm *MemoryMailer) Messages() []Message {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return append([]Message(nil), mm.messages...)
+}
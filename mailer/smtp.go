@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer sends mail through an SMTP server. Auth is only used when User is set.
+type SMTPMailer struct {
+	Host string
+	Port string
+	User string
+	Pass string
+	From string
+}
+
+func (s *SMTPMailer) Send(m Message) error {
+	var auth smtp.Auth
+	if s.User != "" {
+		auth = smtp.PlainAuth("", s.User, s.Pass, s.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{m.To}, render(s.From, m))
+}
+
+// render builds the raw RFC 5322 message for m
+func render(from string, m Message) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(m.Body)
+
+	return b.Bytes()
+}
@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP is a stand-in SMTP server that accepts one message and hands back the raw DATA it received
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		write := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		write("220 localhost fake smtp")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"):
+				write("250 ok")
+			case cmd == "DATA":
+				write("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				received <- data.String()
+				write("250 queued")
+			case cmd == "QUIT":
+				write("221 bye")
+				return
+			default:
+				write("502 not implemented")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func Test_SMTPMailer_Send(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	m := &SMTPMailer{
+		Host: host,
+		Port: port,
+		From: "superchat@example.com",
+	}
+
+	err := m.Send(Message{
+		To:      "someone@example.com",
+		Subject: "hello",
+		Body:    "is anyone there",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := <-received
+	if !strings.Contains(data, "To: someone@example.com") {
+		t.Error("message is missing the To header")
+	}
+	if !strings.Contains(data, "Subject: hello") {
+		t.Error("message is missing the Subject header")
+	}
+	if !strings.Contains(data, "is anyone there") {
+		t.Error("message is missing the body")
+	}
+}
+
+func Test_MemoryMailer_Send(t *testing.T) {
+	m := NewMemoryMailer()
+	m.Send(Message{To: "someone@example.com"})
+
+	if len(m.Messages()) != 1 || m.Messages()[0].To != "someone@example.com" {
+		t.Error("memory mailer didn't keep the message")
+	}
+}
@@ -1,60 +1,271 @@
 package middleware
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/jessehorne/superchat-core/database"
 	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
 	"github.com/jessehorne/superchat-core/util"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
+// lastUsedResolution is how stale a session's LastUsedAt can get before a request bumps it. It keeps us from writing
+// to the sessions table on every single request.
+const lastUsedResolution = time.Minute
+
+// APIKeyPrefix starts every API key so they can be told apart from session tokens
+const APIKeyPrefix = "sck_"
+
+// Scope marks the routes API keys are allowed to use. It has to come before AuthMiddleware. API keys are refused on
+// routes without it, so they can never do things like change passwords or make more keys.
+func Scope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("scope", scope)
+		c.Next()
+	}
+}
+
+const (
+	// SessionCookie holds the bearer token of a browser session
+	SessionCookie = "superchat_session"
+	// CSRFHeader has to carry the session's CSRF token on every state-changing request authenticated by SessionCookie
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// authRealm is the realm sent in WWW-Authenticate challenges
+const authRealm = "superchat"
+
+// legacyAuthHeaders is true while clients can still authenticate with the deprecated userID header and a token in
+// Authorization without the Bearer scheme. It's off unless LEGACY_AUTH_HEADERS=true, which should only be set until
+// every client sends Bearer tokens.
+func legacyAuthHeaders() bool {
+	return os.Getenv("LEGACY_AUTH_HEADERS") == "true"
+}
+
+// AuthMiddleware authenticates requests sent with "Authorization: Bearer <token>", where the token is either one
+// handed out at login or an API key
 func AuthMiddleware(c *gin.Context) {
-	userID := c.GetHeader("userID")
-	token := c.GetHeader("Authorization")
+	authorization := c.GetHeader("Authorization")
 
-	if userID == "" || token == "" {
-		c.JSON(http.StatusUnauthorized, nil)
+	scheme, credentials, _ := strings.Cut(authorization, " ")
+	if strings.EqualFold(scheme, "Bearer") {
+		credentials = strings.TrimSpace(credentials)
+
+		if strings.HasPrefix(credentials, APIKeyPrefix) {
+			apiKeyAuth(c, strings.TrimPrefix(credentials, APIKeyPrefix))
+			return
+		}
+
+		sessionID, token, ok := util.DecodeBearerToken(credentials)
+		if !ok {
+			unauthorized(c, "invalid_token", "invalid token")
+			return
+		}
+
+		sesh, err := repository.GRepos.Sessions.FindByID(sessionID)
+		if err != nil {
+			unauthorized(c, "invalid_token", "invalid token")
+			return
+		}
+
+		sessionAuth(c, sesh, token)
+		return
+	}
+
+	// api keys were sent bare before Bearer tokens existed
+	if strings.HasPrefix(authorization, APIKeyPrefix) {
+		apiKeyAuth(c, strings.TrimPrefix(authorization, APIKeyPrefix))
+		return
+	}
+
+	if authorization == "" {
+		if cookie, err := c.Cookie(SessionCookie); err == nil && cookie != "" {
+			cookieAuth(c, cookie)
+			return
+		}
+
+		unauthorized(c, "", "")
+		return
+	}
+
+	if !legacyAuthHeaders() {
+		unauthorized(c, "invalid_request", "use the Authorization: Bearer <token> header")
+		return
+	}
+
+	legacyAuth(c, c.GetHeader("userID"), authorization)
+}
+
+// cookieAuth handles browser sessions. Browsers send cookies along with requests other sites trigger, so anything that
+// isn't a safe method has to prove it came from our client with the session's CSRF token.
+func cookieAuth(c *gin.Context, cookie string) {
+	sessionID, token, ok := util.DecodeBearerToken(cookie)
+	if !ok {
+		unauthorized(c, "invalid_token", "invalid token")
+		return
+	}
+
+	// only sessions started as cookie sessions have a CSRF token
+	sesh, err := repository.GRepos.Sessions.FindByID(sessionID)
+	if err != nil || sesh.CSRFToken == "" {
+		unauthorized(c, "invalid_token", "invalid token")
+		return
+	}
+
+	if !ValidCSRF(c, sesh) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "invalid csrf token",
+		})
 		c.Abort()
 		return
 	}
 
-	// check if session exists with that userID
+	sessionAuth(c, sesh, token)
+}
+
+// ValidCSRF is true if the request is a safe method or carries the CSRF token of sesh in CSRFHeader
+func ValidCSRF(c *gin.Context, sesh models.Session) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	csrfToken := c.GetHeader(CSRFHeader)
+	if csrfToken == "" || sesh.CSRFToken == "" {
+		return false
+	}
+
+	return util.ValidateToken(csrfToken, sesh.CSRFToken)
+}
+
+// legacyAuth handles the deprecated userID header and bare token pair. The token can be a bearer token or a raw one
+// handed out before bearer tokens existed.
+func legacyAuth(c *gin.Context, userID string, token string) {
+	c.Header("Deprecation", "true")
+
+	if userID == "" {
+		unauthorized(c, "", "")
+		return
+	}
+
+	// find the session this token belongs to
 	var sesh models.Session
-	seshResult := database.GDB.First(&sesh, "user_id = ?", userID)
-	if seshResult.RowsAffected == 0 {
-		c.JSON(http.StatusUnauthorized, nil)
-		c.Abort()
+	var err error
+	if sessionID, rawToken, ok := util.DecodeBearerToken(token); ok {
+		sesh, err = repository.GRepos.Sessions.FindByID(sessionID)
+		token = rawToken
+	} else {
+		sesh, err = repository.GRepos.Sessions.FindByToken(userID, util.HashToken(token))
+	}
+	if err != nil || sesh.UserID != userID {
+		unauthorized(c, "invalid_token", "invalid token")
 		return
 	}
 
+	sessionAuth(c, sesh, token)
+}
+
+// sessionAuth finishes authenticating a request once the session its token claims to belong to has been found
+func sessionAuth(c *gin.Context, sesh models.Session, token string) {
 	// check if token is valid
 	if !util.ValidateToken(token, sesh.Token) {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		c.Abort()
+		unauthorized(c, "invalid_token", "invalid token")
 		return
 	}
 
 	// check if expires at isn't past
 	if time.Now().After(sesh.ExpiresAt) {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "expired token",
+		unauthorized(c, "invalid_token", "expired token")
+		return
+	}
+
+	// get user the session belongs to
+	user, err := repository.GRepos.Users.FindByID(sesh.UserID)
+	if err != nil {
+		unauthorized(c, "invalid_token", "")
+		return
+	}
+
+	now := time.Now()
+	if sesh.LastUsedAt == nil || now.Sub(*sesh.LastUsedAt) > lastUsedResolution {
+		sesh.LastUsedAt = &now
+		repository.GRepos.Sessions.Touch(&sesh, now)
+	}
+
+	c.Set("user", user)
+	c.Set("session", sesh)
+	c.Next()
+}
+
+func apiKeyAuth(c *gin.Context, token string) {
+	key, err := repository.GRepos.APIKeys.FindByToken(util.HashToken(token))
+	if err != nil || !util.ValidateToken(token, key.Token) {
+		unauthorized(c, "invalid_token", "invalid api key")
+		return
+	}
+
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		unauthorized(c, "invalid_token", "expired api key")
+		return
+	}
+
+	scope := c.GetString("scope")
+	if scope == "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "api keys can't be used here",
 		})
 		c.Abort()
 		return
 	}
 
-	// get user by userID
-	var user models.User
-	userResult := database.GDB.First(&user, "id = ?", userID)
-	if userResult.RowsAffected == 0 {
-		c.JSON(http.StatusUnauthorized, nil)
+	if !key.HasScope(scope) {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="insufficient_scope", scope="%s"`,
+			authRealm, scope))
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "api key is missing the " + scope + " scope",
+		})
 		c.Abort()
 		return
 	}
 
+	user, err := repository.GRepos.Users.FindByID(key.UserID)
+	if err != nil {
+		unauthorized(c, "invalid_token", "")
+		return
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedResolution {
+		key.LastUsedAt = &now
+		repository.GRepos.APIKeys.Touch(&key, now)
+	}
+
 	c.Set("user", user)
+	c.Set("apiKey", key)
 	c.Next()
 }
+
+// unauthorized aborts with a 401 and a WWW-Authenticate challenge. errorCode is one of the RFC 6750 error codes and is
+// left empty when the request had no credentials at all.
+func unauthorized(c *gin.Context, errorCode string, description string) {
+	challenge := fmt.Sprintf(`Bearer realm="%s"`, authRealm)
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`, error="%s"`, errorCode)
+	}
+	if description != "" {
+		challenge += fmt.Sprintf(`, error_description="%s"`, description)
+	}
+	c.Header("WWW-Authenticate", challenge)
+
+	if description == "" {
+		c.JSON(http.StatusUnauthorized, nil)
+	} else {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": description,
+		})
+	}
+	c.Abort()
+}
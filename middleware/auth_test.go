@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSession makes a user with a session and returns the session along with its raw token
+func testSession(t *testing.T) (models.Session, string) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	user := models.User{
+		GivenFields: models.GivenFields{
+			ID: "user",
+		},
+		Email: "user@example.com",
+		Name:  "user",
+	}
+	if err := repository.GRepos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	token, hash := util.CreateToken()
+	sesh := models.Session{
+		GivenFields: models.GivenFields{
+			ID: "session",
+		},
+		UserID:    user.ID,
+		Token:     hash,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := repository.GRepos.Sessions.Create(&sesh); err != nil {
+		t.Fatal(err)
+	}
+
+	return sesh, token
+}
+
+// testAuth runs a request with headers through AuthMiddleware
+func testAuth(headers map[string]string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/", AuthMiddleware, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"userID": c.MustGet("user").(models.User).ID,
+		})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func Test_Auth_Bearer(t *testing.T) {
+	sesh, token := testSession(t)
+	bearer := util.EncodeBearerToken(sesh.ID, token)
+
+	for _, authorization := range []string{"Bearer " + bearer, "bearer " + bearer, "Bearer  " + bearer + " "} {
+		if w := testAuth(map[string]string{"Authorization": authorization}); w.Code != http.StatusOK {
+			t.Errorf("%q should be let through, got %d: %s", authorization, w.Code, w.Body)
+		}
+	}
+
+	w := testAuth(nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="superchat"` {
+		t.Errorf("A request without credentials should get a bare challenge, got %d with %q", w.Code,
+			w.Header().Get("WWW-Authenticate"))
+	}
+
+	_, otherToken := util.CreateToken()
+	w = testAuth(map[string]string{"Authorization": "Bearer " + util.EncodeBearerToken(sesh.ID, otherToken)})
+	if w.Code != http.StatusUnauthorized ||
+		!strings.HasPrefix(w.Header().Get("WWW-Authenticate"), `Bearer realm="superchat", error="invalid_token"`) {
+		t.Errorf("A wrong token should be challenged with invalid_token, got %d with %q", w.Code,
+			w.Header().Get("WWW-Authenticate"))
+	}
+
+	sesh.ExpiresAt = time.Now().Add(-time.Second)
+	if err := repository.GRepos.Sessions.Save(&sesh); err != nil {
+		t.Fatal(err)
+	}
+	w = testAuth(map[string]string{"Authorization": "Bearer " + bearer})
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Errorf("An expired token should be challenged with invalid_token, got %d with %q", w.Code,
+			w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func Test_Auth_LegacyHeaders(t *testing.T) {
+	sesh, token := testSession(t)
+	legacy := map[string]string{"userID": sesh.UserID, "Authorization": token}
+
+	for _, value := range []string{"", "false", "yes"} {
+		t.Setenv("LEGACY_AUTH_HEADERS", value)
+
+		w := testAuth(legacy)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_request"`) {
+			t.Errorf("The legacy headers should be refused with LEGACY_AUTH_HEADERS=%q, got %d with %q", value, w.Code,
+				w.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	t.Setenv("LEGACY_AUTH_HEADERS", "true")
+
+	w := testAuth(legacy)
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" {
+		t.Errorf("The legacy headers should work but be flagged as deprecated, got %d with %q", w.Code,
+			w.Header().Get("Deprecation"))
+	}
+
+	if w := testAuth(map[string]string{"userID": "someone else", "Authorization": token}); w.Code != http.StatusUnauthorized {
+		t.Errorf("The legacy headers should only work for the user the token belongs to, got %d", w.Code)
+	}
+	if w := testAuth(map[string]string{"Authorization": token}); w.Code != http.StatusUnauthorized {
+		t.Errorf("A bare token without the userID header should be refused, got %d", w.Code)
+	}
+}
+
+func Test_Auth_MalformedToken(t *testing.T) {
+	t.Setenv("LEGACY_AUTH_HEADERS", "true")
+	sesh, _ := testSession(t)
+
+	encode := func(s string) string {
+		return "scs_" + base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	for _, token := range []string{
+		"scs_",
+		"scs_!!!",
+		"scs_" + strings.Repeat("A", 4097),
+		encode("no separator"),
+		encode(":"),
+		encode(sesh.ID + ":"),
+		encode(":token"),
+		encode(sesh.ID + ":not base64!"),
+		encode("nobody:token"),
+	} {
+		for name, headers := range map[string]map[string]string{
+			"bearer": {"Authorization": "Bearer " + token},
+			"legacy": {"userID": sesh.UserID, "Authorization": token},
+			"cookie": {"Cookie": SessionCookie + "=" + token},
+		} {
+			w := testAuth(headers)
+			if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("A malformed %s token %q should be challenged, got %d", name, token, w.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far apart our clock and the provider's can be
+const clockSkew = time.Minute
+
+// keyRefreshInterval stops unknown key IDs from making us hammer the provider's JWKS endpoint
+const keyRefreshInterval = time.Minute
+
+// Claims are the ID token claims superchat cares about
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is a JWT "aud" claim, which can be a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's published keys and validates its issuer,
+// audience, expiry and nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, raw string, nonce string) (*Claims, error) {
+	if _, err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed id token header")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("malformed id token header")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id token signature")
+	}
+
+	key, err := p.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed id token payload")
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed id token payload")
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, fmt.Errorf("id token issuer %q doesn't match %q", claims.Issuer, p.Issuer)
+	}
+
+	if !claims.Audience.contains(p.ClientID) {
+		return nil, errors.New("id token wasn't issued for this client")
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.ClientID {
+		return nil, errors.New("id token wasn't authorized for this client")
+	}
+
+	now := time.Now()
+	if now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)) {
+		return nil, errors.New("id token has expired")
+	}
+
+	if claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)) {
+		return nil, errors.New("id token was issued in the future")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("id token nonce doesn't match")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	return &claims, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("id token key doesn't match its algorithm")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, sum[:], sig); err != nil {
+			return errors.New("invalid id token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return errors.New("id token key doesn't match its algorithm")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(ecKey, sum[:], r, s) {
+			return errors.New("invalid id token signature")
+		}
+	default:
+		// this includes "none", which must never be accepted
+		return fmt.Errorf("unsupported id token algorithm %q", alg)
+	}
+
+	return nil
+}
+
+// keySet caches a provider's JSON Web Key Set
+type keySet struct {
+	uri    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newKeySet(uri string, client *http.Client) *keySet {
+	return &keySet{
+		uri:    uri,
+		client: client,
+	}
+}
+
+// get returns the key with kid, refetching the set if the provider has rotated its keys
+func (ks *keySet) get(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(ks.fetchedAt) < keyRefreshInterval {
+		return nil, errors.New("unknown id token key")
+	}
+
+	if err := ks.fetch(ctx); err != nil {
+		return nil, err
+	}
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, errors.New("unknown id token key")
+}
+
+// lookup must be called with the lock held. Tokens without a kid are only accepted when there's a single key.
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.uri, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := ks.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks endpoint returned %s", res.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+
+	return nil
+}
@@ -0,0 +1,112 @@
+// Package oidctest has a stand-in OpenID Connect identity provider for tests
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// ClientID is the audience of the ID tokens MockProvider hands out
+const ClientID = "superchat"
+
+// MockProvider is a stand-in identity provider that hands out one authorization code. Set Code, Challenge and Claims
+// to what the next token request should expect and get back.
+type MockProvider struct {
+	Server *httptest.Server
+
+	Code      string
+	Challenge string
+	Claims    map[string]any
+
+	t   *testing.T
+	key *rsa.PrivateKey
+}
+
+// NewMockProvider starts a MockProvider that's shut down when the test ends
+func NewMockProvider(t *testing.T) *MockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &MockProvider{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.Server.URL,
+			"authorization_endpoint": m.Server.URL + "/authorize",
+			"token_endpoint":         m.Server.URL + "/token",
+			"jwks_uri":               m.Server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if r.Form.Get("code") != m.Code || base64.RawURLEncoding.EncodeToString(sum[:]) != m.Challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.Sign(m.Claims),
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Server.Close)
+
+	return m
+}
+
+// Sign returns an RS256 ID token with claims signed by the provider's key
+func (m *MockProvider) Sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// ValidClaims returns the claims of a valid ID token for nonce with a verified email
+func (m *MockProvider) ValidClaims(nonce string) map[string]any {
+	return map[string]any{
+		"iss":            m.Server.URL,
+		"sub":            "user-123",
+		"aud":            ClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "someone@example.com",
+		"email_verified": true,
+	}
+}
@@ -0,0 +1,98 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/jessehorne/superchat-core/oidc/oidctest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testProvider returns a Provider for m
+func testProvider(m *oidctest.MockProvider) *Provider {
+	return &Provider{
+		Issuer:      m.Server.URL,
+		ClientID:    oidctest.ClientID,
+		RedirectURL: "http://localhost/callback",
+	}
+}
+
+func Test_Provider_Login(t *testing.T) {
+	m := oidctest.NewMockProvider(t)
+	p := testProvider(m)
+	ctx := context.Background()
+
+	verifier, challenge := NewPKCE()
+	nonce := RandomString(16)
+
+	authURL, err := p.AuthCodeURL(ctx, "state", nonce, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(authURL)
+	if u.Query().Get("code_challenge") != challenge || u.Query().Get("code_challenge_method") != "S256" {
+		t.Error("auth URL is missing the PKCE challenge")
+	}
+
+	// pretend the user logged in and the provider redirected back with a code
+	m.Code = "the-code"
+	m.Challenge = u.Query().Get("code_challenge")
+	m.Claims = m.ValidClaims(nonce)
+
+	raw, err := p.Exchange(ctx, "the-code", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := p.VerifyIDToken(ctx, raw, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "user-123" || claims.Email != "someone@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	if _, err := p.Exchange(ctx, "the-code", "wrong verifier"); err == nil {
+		t.Error("exchange should fail with the wrong PKCE verifier")
+	}
+}
+
+func Test_Provider_RejectsBadTokens(t *testing.T) {
+	m := oidctest.NewMockProvider(t)
+	p := testProvider(m)
+	ctx := context.Background()
+
+	tamper := func(change func(map[string]any)) string {
+		claims := m.ValidClaims("nonce")
+		change(claims)
+		return m.Sign(claims)
+	}
+
+	cases := map[string]string{
+		"expired":      tamper(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }),
+		"wrong issuer": tamper(func(c map[string]any) { c["iss"] = "https://evil.example.com" }),
+		"wrong aud":    tamper(func(c map[string]any) { c["aud"] = "someone-else" }),
+		"wrong nonce":  tamper(func(c map[string]any) { c["nonce"] = "other" }),
+	}
+
+	// a valid token with its payload swapped out
+	valid := strings.Split(m.Sign(m.ValidClaims("nonce")), ".")
+	forged, _ := json.Marshal(map[string]any{"iss": m.Server.URL, "sub": "admin", "aud": "superchat", "nonce": "nonce",
+		"exp": time.Now().Add(time.Hour).Unix()})
+	cases["bad signature"] = valid[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + valid[2]
+
+	// alg none must never be accepted
+	none, _ := json.Marshal(map[string]string{"alg": "none"})
+	cases["alg none"] = base64.RawURLEncoding.EncodeToString(none) + "." + valid[1] + "."
+
+	for name, raw := range cases {
+		if _, err := p.VerifyIDToken(ctx, raw, "nonce"); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+}
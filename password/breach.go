@@ -0,0 +1,65 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// breachPrefixLength is how many hex characters of a SHA-1 hash pick the range file it's in
+const breachPrefixLength = 5
+
+// BreachList checks passwords against a local copy of a k-anonymity breached password list like Pwned Passwords. Dir
+// holds one file per hash prefix, named like "5BAA6.txt", with a "SUFFIX:COUNT" line for every breached password
+// whose SHA-1 hash starts with that prefix. That's the same format the range API serves so downloads can be used
+// as is. Only the one small file a password's prefix points at is ever read.
+type BreachList struct {
+	Dir string
+}
+
+// NewBreachList returns a BreachList reading range files from dir
+func NewBreachList(dir string) (*BreachList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("password breach list: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("password breach list: %s isn't a directory", dir)
+	}
+
+	return &BreachList{
+		Dir: dir,
+	}, nil
+}
+
+// Contains returns true if pass is on the list
+func (b *BreachList) Contains(pass string) (bool, error) {
+	sum := sha1.Sum([]byte(pass))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachPrefixLength], hash[breachPrefixLength:]
+
+	f, err := os.Open(filepath.Join(b.Dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		// nothing breached has this prefix
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,162 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultMinLength  = 8
+	defaultMaxLength  = 255
+	defaultMinEntropy = 35
+)
+
+var ErrBreached = errors.New("password has appeared in a data breach, choose another one")
+
+// Policy decides which passwords are good enough to be set on users and rooms
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// MinEntropy is the least number of bits EstimateEntropy has to give a password
+	MinEntropy float64
+	// Breaches is checked for passwords known to be breached, it can be nil
+	Breaches *BreachList
+}
+
+var GPolicy = &Policy{
+	MinLength:  defaultMinLength,
+	MaxLength:  defaultMaxLength,
+	MinEntropy: defaultMinEntropy,
+}
+
+// InitPolicy sets GPolicy using PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH, PASSWORD_MIN_ENTROPY and
+// PASSWORD_BREACH_DIR. Unset ones keep their defaults and no breach list is used without PASSWORD_BREACH_DIR.
+func InitPolicy() (*Policy, error) {
+	p := &Policy{
+		MinLength:  defaultMinLength,
+		MaxLength:  defaultMaxLength,
+		MinEntropy: defaultMinEntropy,
+	}
+
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", v)
+		}
+		p.MinLength = n
+	}
+
+	if v := os.Getenv("PASSWORD_MAX_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < p.MinLength {
+			return nil, fmt.Errorf("invalid PASSWORD_MAX_LENGTH %q", v)
+		}
+		p.MaxLength = n
+	}
+
+	if v := os.Getenv("PASSWORD_MIN_ENTROPY"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid PASSWORD_MIN_ENTROPY %q", v)
+		}
+		p.MinEntropy = n
+	}
+
+	if dir := os.Getenv("PASSWORD_BREACH_DIR"); dir != "" {
+		b, err := NewBreachList(dir)
+		if err != nil {
+			return nil, err
+		}
+		p.Breaches = b
+	}
+
+	GPolicy = p
+
+	return p, nil
+}
+
+// Check returns an error describing why pass isn't allowed, or nil if it is. The error is meant to be shown to users.
+func (p *Policy) Check(pass string) error {
+	length := utf8.RuneCountInString(pass)
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if length > p.MaxLength {
+		return fmt.Errorf("password can't be longer than %d characters", p.MaxLength)
+	}
+
+	if EstimateEntropy(pass) < p.MinEntropy {
+		return errors.New("password is too easy to guess, use a longer one or mix in other kinds of characters")
+	}
+
+	if p.Breaches != nil {
+		breached, err := p.Breaches.Contains(pass)
+		if err != nil {
+			// a broken breach list shouldn't stop everyone from setting passwords
+			log.Println("password breach list:", err)
+			return nil
+		}
+		if breached {
+			return ErrBreached
+		}
+	}
+
+	return nil
+}
+
+// EstimateEntropy guesses how many bits of entropy pass has from the kinds of characters it uses and its length.
+// Characters that repeat or continue a run from the one before them (like "aaaa" or "1234") aren't counted.
+func EstimateEntropy(pass string) float64 {
+	var lower, upper, digit, symbol, other bool
+	length := 0
+	prev := rune(-1)
+
+	for _, r := range pass {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+
+		if prev < 0 || (r != prev && r != prev+1 && r != prev-1) {
+			length++
+		}
+		prev = r
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(pool))
+}
@@ -0,0 +1,80 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Policy_Length(t *testing.T) {
+	p := &Policy{
+		MinLength: 8,
+		MaxLength: 16,
+	}
+
+	if err := p.Check("Sh0rt!"); err == nil {
+		t.Error("Passwords shorter than MinLength should be refused.")
+	}
+
+	if err := p.Check("this one is way too long"); err == nil {
+		t.Error("Passwords longer than MaxLength should be refused.")
+	}
+
+	if err := p.Check("just right"); err != nil {
+		t.Errorf("A password within the limits should be allowed, got %s", err)
+	}
+}
+
+func Test_Policy_Entropy(t *testing.T) {
+	p := &Policy{
+		MinLength:  8,
+		MaxLength:  255,
+		MinEntropy: defaultMinEntropy,
+	}
+
+	for _, weak := range []string{"password", "12345678", "aaaaaaaaaaaa", "abcdefghijkl"} {
+		if err := p.Check(weak); err == nil {
+			t.Errorf("%q should be too easy to guess.", weak)
+		}
+	}
+
+	for _, strong := range []string{"correct horse battery staple", "kT9$wq2!", "my cabbages"} {
+		if err := p.Check(strong); err != nil {
+			t.Errorf("%q should be allowed, got %s", strong, err)
+		}
+	}
+}
+
+func Test_Policy_Breached(t *testing.T) {
+	dir := t.TempDir()
+
+	// SHA-1 of "my cabbages" is DD424EFB271B647F392D2944F1C9D6E5CC9F04B3, only the part after the prefix is stored
+	contents := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\nEFB271B647F392D2944F1C9D6E5CC9F04B3:42\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "DD424.txt"), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBreachList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Policy{
+		MinLength: 8,
+		MaxLength: 255,
+		Breaches:  b,
+	}
+
+	if err := p.Check("my cabbages"); !errors.Is(err, ErrBreached) {
+		t.Errorf("A breached password should be refused, got %v", err)
+	}
+
+	if err := p.Check("my other cabbages"); err != nil {
+		t.Errorf("A password that isn't on the list should be allowed, got %s", err)
+	}
+
+	if _, err := NewBreachList(filepath.Join(dir, "missing")); err == nil {
+		t.Error("A missing breach list directory should be an error.")
+	}
+}
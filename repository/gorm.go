@@ -0,0 +1,681 @@
+package repository
+
+import (
+	"errors"
+	"github.com/jessehorne/superchat-core/database"
+	"github.com/jessehorne/superchat-core/database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// likeEscaper escapes user input so it's matched literally inside a LIKE pattern. Backslash isn't the default escape
+// character everywhere so likeEscape is given explicitly.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+const likeEscape = "ESCAPE '!'"
+
+// InitRepositories sets GRepos to repositories backed by database.GDB, which has to be set up first
+func InitRepositories() (*Repositories, error) {
+	if database.GDB == nil {
+		return nil, errors.New("the database has to be set up before the repositories")
+	}
+
+	r := NewGormRepositories(database.GDB)
+
+	GRepos = r
+
+	return r, nil
+}
+
+// NewGormRepositories returns repositories backed by db
+func NewGormRepositories(db *gorm.DB) *Repositories {
+	return &Repositories{
+		Users:          &gormUsers{db: db},
+		Sessions:       &gormSessions{db: db},
+		Rooms:          &gormRooms{db: db},
+		Mods:           &gormMods{db: db},
+		Roles:          &gormRoles{db: db},
+		Members:        &gormMembers{db: db},
+		Bans:           &gormBans{db: db},
+		Messages:       &gormMessages{db: db},
+		Audit:          &gormAudit{db: db},
+		RefreshTokens:  &gormRefreshTokens{db: db},
+		RecoveryCodes:  &gormRecoveryCodes{db: db},
+		PasswordResets: &gormPasswordResets{db: db},
+		Identities:     &gormIdentities{db: db},
+		Throttles:      &gormThrottles{db: db},
+		APIKeys:        &gormAPIKeys{db: db},
+		transaction: func(fn func(tx *Repositories) error) error {
+			// nested transactions become savepoints
+			return db.Transaction(func(tx *gorm.DB) error {
+				return fn(NewGormRepositories(tx))
+			})
+		},
+	}
+}
+
+// findError turns the result of a First into ErrNotFound if nothing matched
+func findError(result *gorm.DB) error {
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+// createError turns the result of a Create or Save into ErrDuplicate if it broke a unique constraint
+func createError(result *gorm.DB) error {
+	if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+		return ErrDuplicate
+	}
+	return result.Error
+}
+
+// changeError turns the result of an update or delete into ErrNotFound if it didn't touch any rows
+func changeError(result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r *gormUsers) Create(u *models.User) error {
+	return createError(r.db.Create(u))
+}
+
+func (r *gormUsers) FindByID(id string) (models.User, error) {
+	var u models.User
+	err := findError(r.db.First(&u, "id = ?", id))
+	return u, err
+}
+
+func (r *gormUsers) FindByEmail(email string) (models.User, error) {
+	var u models.User
+	err := findError(r.db.First(&u, "email = ?", email))
+	return u, err
+}
+
+func (r *gormUsers) Save(u *models.User) error {
+	return r.db.Save(u).Error
+}
+
+func (r *gormUsers) Delete(u *models.User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var botIDs []string
+		if err := tx.Model(&models.User{}).Where("bot = ?", true).Where("owner_id = ?", u.ID).
+			Pluck("id", &botIDs).Error; err != nil {
+			return err
+		}
+		userIDs := append([]string{u.ID}, botIDs...)
+
+		sessionIDs := tx.Model(&models.Session{}).Select("id").Where("user_id IN ?", userIDs)
+		if err := tx.Where("session_id IN (?)", sessionIDs).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+
+		for _, dependent := range []any{
+			&models.Session{},
+			&models.RoomMod{},
+			&models.RoomUser{},
+			&models.RoomBan{},
+			&models.RoomMessage{},
+			&models.RecoveryCode{},
+			&models.PasswordReset{},
+			&models.UserIdentity{},
+		} {
+			if err := tx.Where("user_id IN ?", userIDs).Delete(dependent).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("user_id IN ? OR created_by_id IN ?", userIDs, userIDs).
+			Delete(&models.APIKey{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("id IN ?", botIDs).Delete(&models.User{}).Error; err != nil {
+			return err
+		}
+
+		return changeError(tx.Delete(u))
+	})
+}
+
+func (r *gormUsers) UpdatePassword(id string, password string, salt string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]any{
+		"password":      password,
+		"password_salt": salt,
+	}).Error
+}
+
+func (r *gormUsers) MarkEmailVerified(id string, at time.Time) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ?", id).
+		Where("email_verified_at IS NULL").
+		Update("email_verified_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormUsers) ClaimTOTPStep(id string, step int64) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ?", id).
+		Where("totp_last_step < ?", step).
+		Update("totp_last_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormUsers) FindBot(ownerID string, id string) (models.User, error) {
+	var u models.User
+	err := findError(r.db.Where("bot = ?", true).Where("owner_id = ?", ownerID).First(&u, "id = ?", id))
+	return u, err
+}
+
+func (r *gormUsers) ListBots(ownerID string) ([]models.User, error) {
+	var bots []models.User
+	result := r.db.Where("bot = ?", true).Where("owner_id = ?", ownerID).Order("created_at asc").Find(&bots)
+	return bots, result.Error
+}
+
+type gormSessions struct {
+	db *gorm.DB
+}
+
+func (r *gormSessions) Create(s *models.Session) error {
+	return r.db.Create(s).Error
+}
+
+func (r *gormSessions) FindByID(id string) (models.Session, error) {
+	var s models.Session
+	err := findError(r.db.First(&s, "id = ?", id))
+	return s, err
+}
+
+func (r *gormSessions) FindByToken(userID string, tokenHash string) (models.Session, error) {
+	var s models.Session
+	err := findError(r.db.Where("user_id = ?", userID).First(&s, "token = ?", tokenHash))
+	return s, err
+}
+
+func (r *gormSessions) ListActive(userID string, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	result := r.db.Where("user_id = ?", userID).
+		Where("refresh_expires_at > ?", now).
+		Order("created_at desc").
+		Find(&sessions)
+	return sessions, result.Error
+}
+
+func (r *gormSessions) Save(s *models.Session) error {
+	return r.db.Save(s).Error
+}
+
+func (r *gormSessions) Touch(s *models.Session, at time.Time) error {
+	return r.db.Model(s).Update("last_used_at", at).Error
+}
+
+func (r *gormSessions) Delete(s *models.Session) error {
+	return changeError(r.db.Delete(s))
+}
+
+func (r *gormSessions) DeleteAll(userID string) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.Session{}).Error
+}
+
+func (r *gormSessions) DeleteOthers(userID string, keepID string) (int64, error) {
+	result := r.db.Where("user_id = ?", userID).Delete(&models.Session{}, "id <> ?", keepID)
+	return result.RowsAffected, result.Error
+}
+
+func (r *gormSessions) DeleteExpired(userID string, now time.Time) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.Session{}, "refresh_expires_at < ?", now).Error
+}
+
+type gormRooms struct {
+	db *gorm.DB
+}
+
+func (r *gormRooms) Create(room *models.Room) error {
+	return r.db.Create(room).Error
+}
+
+func (r *gormRooms) FindByID(id string) (models.Room, error) {
+	var room models.Room
+	err := findError(r.db.First(&room, "id = ?", id))
+	return room, err
+}
+
+func (r *gormRooms) Lock(id string) error {
+	var room models.Room
+	return findError(r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&room, "id = ?", id))
+}
+
+func (r *gormRooms) Save(room *models.Room) error {
+	return r.db.Save(room).Error
+}
+
+func (r *gormRooms) Delete(room *models.Room) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// mods go before the roles they can have
+		for _, dependent := range []any{
+			&models.RoomMod{},
+			&models.RoomRole{},
+			&models.RoomUser{},
+			&models.RoomBan{},
+			&models.RoomMessage{},
+			&models.RoomAuditEntry{},
+		} {
+			if err := tx.Where("room_id = ?", room.ID).Delete(dependent).Error; err != nil {
+				return err
+			}
+		}
+
+		return changeError(tx.Delete(room))
+	})
+}
+
+func (r *gormRooms) UpdatePassword(id string, password string, salt string) error {
+	return r.db.Model(&models.Room{}).Where("id = ?", id).Updates(map[string]any{
+		"password":      password,
+		"password_salt": salt,
+	}).Error
+}
+
+func (r *gormRooms) ListPublic(opts RoomListOptions) ([]RoomListing, error) {
+	query := r.db.Model(&models.Room{}).
+		Select("rooms.id, rooms.name, rooms.password_protected, COUNT(room_users.id) AS member_count").
+		Joins("LEFT JOIN room_users ON room_users.room_id = rooms.id AND room_users.deleted_at IS NULL").
+		Where("rooms.private = ?", false)
+
+	if opts.Search != "" {
+		query = query.Where("LOWER(rooms.name) LIKE LOWER(?) "+likeEscape, "%"+likeEscaper.Replace(opts.Search)+"%")
+	}
+
+	if opts.HideProtected {
+		query = query.Where("rooms.password_protected = ?", false)
+	}
+
+	if opts.RoomIDs != nil {
+		query = query.Where("rooms.id IN ?", opts.RoomIDs)
+	}
+
+	var rooms []RoomListing
+	result := query.
+		Group("rooms.id, rooms.name, rooms.password_protected").
+		Order("member_count desc, rooms.name asc").
+		Limit(opts.Limit).
+		Offset(opts.Offset).
+		Scan(&rooms)
+	return rooms, result.Error
+}
+
+type gormMods struct {
+	db *gorm.DB
+}
+
+func (r *gormMods) Create(m *models.RoomMod) error {
+	return createError(r.db.Create(m))
+}
+
+func (r *gormMods) Find(roomID string, userID string) (models.RoomMod, error) {
+	var m models.RoomMod
+	err := findError(r.db.Where("room_id = ?", roomID).First(&m, "user_id = ?", userID))
+	return m, err
+}
+
+func (r *gormMods) Save(m *models.RoomMod) error {
+	return r.db.Save(m).Error
+}
+
+func (r *gormMods) Delete(m *models.RoomMod) error {
+	// a soft deleted record would keep the unique constraint from letting them back in
+	return changeError(r.db.Unscoped().Delete(m))
+}
+
+func (r *gormMods) CountRole(roomID string, role int) (int64, error) {
+	var count int64
+	result := r.db.Model(&models.RoomMod{}).
+		Where("room_id = ?", roomID).
+		Where("role = ?", role).
+		Count(&count)
+	return count, result.Error
+}
+
+func (r *gormMods) ListOwned(userIDs []string) ([]OwnedRoom, error) {
+	var owned []OwnedRoom
+	result := r.db.Model(&models.RoomMod{}).
+		Select("room_id, SUM(CASE WHEN user_id IN ? THEN 0 ELSE 1 END) AS other_owners", userIDs).
+		Where("role = ?", models.RoomModRoleOwner).
+		Group("room_id").
+		Having("SUM(CASE WHEN user_id IN ? THEN 1 ELSE 0 END) > 0", userIDs).
+		Order("room_id").
+		Scan(&owned)
+	return owned, result.Error
+}
+
+func (r *gormMods) CountWithRole(roleID string) (int64, error) {
+	var count int64
+	result := r.db.Model(&models.RoomMod{}).Where("role_id = ?", roleID).Count(&count)
+	return count, result.Error
+}
+
+type gormRoles struct {
+	db *gorm.DB
+}
+
+func (r *gormRoles) Create(role *models.RoomRole) error {
+	return createError(r.db.Create(role))
+}
+
+func (r *gormRoles) Find(roomID string, id string) (models.RoomRole, error) {
+	var role models.RoomRole
+	err := findError(r.db.Where("room_id = ?", roomID).First(&role, "id = ?", id))
+	return role, err
+}
+
+func (r *gormRoles) List(roomID string) ([]models.RoomRole, error) {
+	var roles []models.RoomRole
+	result := r.db.Where("room_id = ?", roomID).Order("name asc").Find(&roles)
+	return roles, result.Error
+}
+
+func (r *gormRoles) Save(role *models.RoomRole) error {
+	return createError(r.db.Save(role))
+}
+
+func (r *gormRoles) Delete(role *models.RoomRole) error {
+	// a soft deleted record would keep the unique constraint from letting the name be used again
+	return changeError(r.db.Unscoped().Delete(role))
+}
+
+type gormMembers struct {
+	db *gorm.DB
+}
+
+func (r *gormMembers) Create(m *models.RoomUser) error {
+	return createError(r.db.Create(m))
+}
+
+func (r *gormMembers) Find(roomID string, userID string) (models.RoomUser, error) {
+	var m models.RoomUser
+	err := findError(r.db.Where("room_id = ?", roomID).First(&m, "user_id = ?", userID))
+	return m, err
+}
+
+func (r *gormMembers) Save(m *models.RoomUser) error {
+	return r.db.Save(m).Error
+}
+
+func (r *gormMembers) Delete(m *models.RoomUser) error {
+	// a soft deleted record would keep the unique constraint from letting them back in
+	return changeError(r.db.Unscoped().Delete(m))
+}
+
+func (r *gormMembers) CountRooms(userID string, roomIDs []string) (int64, error) {
+	var count int64
+	result := r.db.Model(&models.RoomUser{}).
+		Where("room_id IN ?", roomIDs).
+		Where("user_id = ?", userID).
+		Distinct("room_id").
+		Count(&count)
+	return count, result.Error
+}
+
+func (r *gormMembers) ListRooms(userID string) ([]MemberRoom, error) {
+	var rooms []MemberRoom
+	result := r.db.Model(&models.RoomUser{}).
+		Select("rooms.id, rooms.name, rooms.password_protected, rooms.private, room_users.muted, room_mods.role").
+		Joins("JOIN rooms ON rooms.id = room_users.room_id AND rooms.deleted_at IS NULL").
+		Joins("LEFT JOIN room_mods ON room_mods.room_id = room_users.room_id AND room_mods.user_id = room_users.user_id AND room_mods.deleted_at IS NULL").
+		Where("room_users.user_id = ?", userID).
+		Order("rooms.name asc").
+		Scan(&rooms)
+	return rooms, result.Error
+}
+
+type gormBans struct {
+	db *gorm.DB
+}
+
+func (r *gormBans) Create(b *models.RoomBan) error {
+	return createError(r.db.Create(b))
+}
+
+func (r *gormBans) Find(roomID string, userID string) (models.RoomBan, error) {
+	var b models.RoomBan
+	err := findError(r.db.Where("room_id = ?", roomID).First(&b, "user_id = ?", userID))
+	return b, err
+}
+
+func (r *gormBans) Delete(b *models.RoomBan) error {
+	// a soft deleted record would keep the unique constraint from letting them be banned again
+	return changeError(r.db.Unscoped().Delete(b))
+}
+
+type gormMessages struct {
+	db *gorm.DB
+}
+
+func (r *gormMessages) Create(m *models.RoomMessage) error {
+	return r.db.Create(m).Error
+}
+
+func (r *gormMessages) Find(roomIDs []string, id string) (models.RoomMessage, error) {
+	var m models.RoomMessage
+	err := findError(r.db.Where("room_id IN ?", roomIDs).First(&m, "id = ?", id))
+	return m, err
+}
+
+func (r *gormMessages) FindCursor(roomIDs []string, id string) (models.RoomMessage, error) {
+	var m models.RoomMessage
+	err := findError(r.db.Unscoped().Where("room_id IN ?", roomIDs).First(&m, "id = ?", id))
+	return m, err
+}
+
+func (r *gormMessages) Save(m *models.RoomMessage) error {
+	return r.db.Save(m).Error
+}
+
+func (r *gormMessages) Delete(m *models.RoomMessage) error {
+	return changeError(r.db.Delete(m))
+}
+
+func (r *gormMessages) ListBefore(roomID string, cursor *models.RoomMessage, limit int) ([]models.RoomMessage, error) {
+	query := r.db.Where("room_id = ?", roomID)
+	if cursor != nil {
+		query = query.Where("seq < ?", cursor.Seq)
+	}
+
+	var messages []models.RoomMessage
+	result := query.Order("seq desc").Limit(limit).Find(&messages)
+	return messages, result.Error
+}
+
+func (r *gormMessages) ListAfter(roomIDs []string, cursor models.RoomMessage, limit int) ([]models.RoomMessage, error) {
+	var messages []models.RoomMessage
+	result := r.db.Where("room_id IN ?", roomIDs).
+		Where("seq > ?", cursor.Seq).
+		Order("seq asc").
+		Limit(limit).
+		Find(&messages)
+	return messages, result.Error
+}
+
+type gormAudit struct {
+	db *gorm.DB
+}
+
+func (r *gormAudit) Create(e *models.RoomAuditEntry) error {
+	return r.db.Create(e).Error
+}
+
+func (r *gormAudit) List(roomID string, limit int) ([]models.RoomAuditEntry, error) {
+	var entries []models.RoomAuditEntry
+	result := r.db.Where("room_id = ?", roomID).Order("created_at desc, id desc").Limit(limit).Find(&entries)
+	return entries, result.Error
+}
+
+type gormRefreshTokens struct {
+	db *gorm.DB
+}
+
+func (r *gormRefreshTokens) Create(t *models.RefreshToken) error {
+	return createError(r.db.Create(t))
+}
+
+func (r *gormRefreshTokens) FindByToken(tokenHash string) (models.RefreshToken, error) {
+	var t models.RefreshToken
+	err := findError(r.db.First(&t, "token = ?", tokenHash))
+	return t, err
+}
+
+func (r *gormRefreshTokens) Claim(t *models.RefreshToken, at time.Time) (bool, error) {
+	result := r.db.Model(t).Where("used_at IS NULL").Update("used_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+type gormRecoveryCodes struct {
+	db *gorm.DB
+}
+
+func (r *gormRecoveryCodes) Create(rc *models.RecoveryCode) error {
+	return createError(r.db.Create(rc))
+}
+
+func (r *gormRecoveryCodes) ListUnused(userID string, lookup string) ([]models.RecoveryCode, error) {
+	var codes []models.RecoveryCode
+	result := r.db.Where("user_id = ?", userID).
+		Where("lookup = ?", lookup).
+		Where("used_at IS NULL").
+		Find(&codes)
+	return codes, result.Error
+}
+
+func (r *gormRecoveryCodes) Claim(rc *models.RecoveryCode, at time.Time) (bool, error) {
+	result := r.db.Model(rc).Where("used_at IS NULL").Update("used_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormRecoveryCodes) DeleteAll(userID string) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+type gormPasswordResets struct {
+	db *gorm.DB
+}
+
+func (r *gormPasswordResets) Create(reset *models.PasswordReset) error {
+	return createError(r.db.Create(reset))
+}
+
+func (r *gormPasswordResets) FindByToken(tokenHash string) (models.PasswordReset, error) {
+	var reset models.PasswordReset
+	err := findError(r.db.First(&reset, "token = ?", tokenHash))
+	return reset, err
+}
+
+func (r *gormPasswordResets) Claim(reset *models.PasswordReset, at time.Time) (bool, error) {
+	result := r.db.Model(reset).Where("used_at IS NULL").Update("used_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormPasswordResets) DeleteUnused(userID string) error {
+	return r.db.Where("user_id = ?", userID).Where("used_at IS NULL").Delete(&models.PasswordReset{}).Error
+}
+
+type gormIdentities struct {
+	db *gorm.DB
+}
+
+func (r *gormIdentities) Create(i *models.UserIdentity) error {
+	return createError(r.db.Create(i))
+}
+
+func (r *gormIdentities) Find(issuer string, subject string) (models.UserIdentity, error) {
+	var i models.UserIdentity
+	err := findError(r.db.Where("issuer = ?", issuer).First(&i, "subject = ?", subject))
+	return i, err
+}
+
+type gormThrottles struct {
+	db *gorm.DB
+}
+
+func (r *gormThrottles) Create(t *models.LoginThrottle) error {
+	return createError(r.db.Create(t))
+}
+
+func (r *gormThrottles) Find(key string) (models.LoginThrottle, error) {
+	var t models.LoginThrottle
+	err := findError(r.db.First(&t, "throttle_key = ?", key))
+	return t, err
+}
+
+func (r *gormThrottles) List(keys []string) ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	result := r.db.Where("throttle_key IN ?", keys).Find(&throttles)
+	return throttles, result.Error
+}
+
+func (r *gormThrottles) AddFailure(t *models.LoginThrottle, at time.Time, restart bool, lockedUntil *time.Time) error {
+	// counting in the update keeps failures that happen at the same time from being lost
+	failures := gorm.Expr("failures + 1")
+	if restart {
+		failures = gorm.Expr("1")
+	}
+
+	updates := map[string]any{
+		"failures":       failures,
+		"last_failed_at": at,
+	}
+	if lockedUntil != nil {
+		updates["locked_until"] = *lockedUntil
+	}
+
+	return r.db.Model(&models.LoginThrottle{}).Where("id = ?", t.ID).Updates(updates).Error
+}
+
+func (r *gormThrottles) Delete(key string) error {
+	return r.db.Unscoped().Where("throttle_key = ?", key).Delete(&models.LoginThrottle{}).Error
+}
+
+type gormAPIKeys struct {
+	db *gorm.DB
+}
+
+func (r *gormAPIKeys) Create(k *models.APIKey) error {
+	return createError(r.db.Create(k))
+}
+
+func (r *gormAPIKeys) FindByToken(tokenHash string) (models.APIKey, error) {
+	var k models.APIKey
+	err := findError(r.db.First(&k, "token = ?", tokenHash))
+	return k, err
+}
+
+func (r *gormAPIKeys) Find(createdByID string, id string) (models.APIKey, error) {
+	var k models.APIKey
+	err := findError(r.db.Where("created_by_id = ?", createdByID).First(&k, "id = ?", id))
+	return k, err
+}
+
+func (r *gormAPIKeys) ListCreatedBy(createdByID string) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	result := r.db.Where("created_by_id = ?", createdByID).Order("created_at asc").Find(&keys)
+	return keys, result.Error
+}
+
+func (r *gormAPIKeys) Touch(k *models.APIKey, at time.Time) error {
+	return r.db.Model(k).Update("last_used_at", at).Error
+}
+
+func (r *gormAPIKeys) Delete(k *models.APIKey) error {
+	return changeError(r.db.Delete(k))
+}
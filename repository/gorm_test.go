@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database"
+	"github.com/jessehorne/superchat-core/database/models"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testSQLite migrates a fresh SQLite database and returns repositories backed by it
+func testSQLite(t *testing.T) *Repositories {
+	t.Setenv("DB_DRIVER", database.DriverSQLite)
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "superchat.db"))
+
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	m, err := database.NewMigrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal("couldn't run migrations:", err)
+	}
+
+	gdb, err := database.InitGDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewGormRepositories(gdb)
+}
+
+func Test_Gorm_SQLite(t *testing.T) {
+	repos := testSQLite(t)
+
+	user := models.User{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		Email: "someone@example.com",
+		Name:  "someone",
+	}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := repos.Users.FindByEmail("someone@example.com")
+	if err != nil || found.ID != user.ID {
+		t.Errorf("Users should be found by email, got %v", err)
+	}
+
+	if _, err := repos.Users.FindByID("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Missing users should give ErrNotFound, got %v", err)
+	}
+
+	claimed, err := repos.Users.ClaimTOTPStep(user.ID, 10)
+	if err != nil || !claimed {
+		t.Errorf("A newer TOTP step should be claimed, got %v", err)
+	}
+	if claimed, _ := repos.Users.ClaimTOTPStep(user.ID, 10); claimed {
+		t.Error("A TOTP step should only be claimed once.")
+	}
+
+	now := time.Now()
+	sesh := models.Session{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		UserID:           user.ID,
+		Token:            "hash",
+		ExpiresAt:        now.Add(time.Hour),
+		RefreshExpiresAt: now.Add(24 * time.Hour),
+	}
+	if err := repos.Sessions.Create(&sesh); err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := repos.Sessions.ListActive(user.ID, now)
+	if err != nil || len(active) != 1 {
+		t.Errorf("The session should be active, got %d (%v)", len(active), err)
+	}
+}
+
+func Test_Gorm_SQLite_RoomSearch(t *testing.T) {
+	repos := testSQLite(t)
+
+	var roomIDs []string
+	for _, name := range []string{"Go Gophers", "100% Rust", "1000 Rustaceans", "snake_case"} {
+		room := models.Room{
+			GivenFields: models.GivenFields{
+				ID: uuid.New().String(),
+			},
+			Name: name,
+		}
+		if err := repos.Rooms.Create(&room); err != nil {
+			t.Fatal(err)
+		}
+		roomIDs = append(roomIDs, room.ID)
+	}
+
+	tests := map[string]int{
+		"go":     1,
+		"GO":     1,
+		"100%":   1,
+		"%":      1,
+		"_":      1,
+		"e_c":    1,
+		"rust":   2,
+		"nobody": 0,
+	}
+	for search, want := range tests {
+		rooms, err := repos.Rooms.ListPublic(RoomListOptions{
+			Search: search,
+			Limit:  10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rooms) != want {
+			t.Errorf("Searching for %q should find %d rooms, found %d", search, want, len(rooms))
+		}
+	}
+
+	rooms, err := repos.Rooms.ListPublic(RoomListOptions{
+		Search:  "rust",
+		RoomIDs: roomIDs[:2],
+		Limit:   10,
+	})
+	if err != nil || len(rooms) != 1 || rooms[0].ID != roomIDs[1] {
+		t.Errorf("Listing some rooms should only find those rooms, got %+v (%v)", rooms, err)
+	}
+}
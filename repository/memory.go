@@ -0,0 +1,1396 @@
+package repository
+
+import (
+	"cmp"
+	"github.com/jessehorne/superchat-core/database/models"
+	"gorm.io/gorm"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps every table in maps keyed by ID. All of the memory repositories share one so queries that join
+// tables in SQL can do the same here.
+type memoryStore struct {
+	mu             sync.Mutex
+	users          map[string]models.User
+	sessions       map[string]models.Session
+	rooms          map[string]models.Room
+	mods           map[string]models.RoomMod
+	roles          map[string]models.RoomRole
+	members        map[string]models.RoomUser
+	bans           map[string]models.RoomBan
+	messages       map[string]models.RoomMessage
+	audit          map[string]models.RoomAuditEntry
+	refreshTokens  map[string]models.RefreshToken
+	recoveryCodes  map[string]models.RecoveryCode
+	passwordResets map[string]models.PasswordReset
+	identities     map[string]models.UserIdentity
+	throttles      map[string]models.LoginThrottle
+	apiKeys        map[string]models.APIKey
+	// messageSeq is the last message's Seq, like a database sequence it isn't rolled back
+	messageSeq int64
+	// txMu makes transactions run one at a time, standing in for the row locks taken by Rooms.Lock
+	txMu sync.Mutex
+}
+
+// NewMemoryRepositories returns repositories that keep everything in memory, for tests
+func NewMemoryRepositories() *Repositories {
+	s := &memoryStore{
+		users:          make(map[string]models.User),
+		sessions:       make(map[string]models.Session),
+		rooms:          make(map[string]models.Room),
+		mods:           make(map[string]models.RoomMod),
+		roles:          make(map[string]models.RoomRole),
+		members:        make(map[string]models.RoomUser),
+		bans:           make(map[string]models.RoomBan),
+		messages:       make(map[string]models.RoomMessage),
+		audit:          make(map[string]models.RoomAuditEntry),
+		refreshTokens:  make(map[string]models.RefreshToken),
+		recoveryCodes:  make(map[string]models.RecoveryCode),
+		passwordResets: make(map[string]models.PasswordReset),
+		identities:     make(map[string]models.UserIdentity),
+		throttles:      make(map[string]models.LoginThrottle),
+		apiKeys:        make(map[string]models.APIKey),
+	}
+
+	repos := &Repositories{
+		Users:          &memoryUsers{s},
+		Sessions:       &memorySessions{s},
+		Rooms:          &memoryRooms{s},
+		Mods:           &memoryMods{s},
+		Roles:          &memoryRoles{s},
+		Members:        &memoryMembers{s},
+		Bans:           &memoryBans{s},
+		Messages:       &memoryMessages{s},
+		Audit:          &memoryAudit{s},
+		RefreshTokens:  &memoryRefreshTokens{s},
+		RecoveryCodes:  &memoryRecoveryCodes{s},
+		PasswordResets: &memoryPasswordResets{s},
+		Identities:     &memoryIdentities{s},
+		Throttles:      &memoryThrottles{s},
+		APIKeys:        &memoryAPIKeys{s},
+	}
+
+	// fn gets repos itself so tests can swap out one of the repositories to make it fail partway through
+	repos.transaction = func(fn func(tx *Repositories) error) error {
+		return s.transaction(func() error {
+			return fn(repos)
+		})
+	}
+
+	return repos
+}
+
+// transaction runs fn and puts every table back how it was if fn fails. Transactions run one at a time but unlike a
+// real transaction it doesn't hide anything from code running outside of one.
+func (s *memoryStore) transaction(fn func() error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	before := &memoryStore{
+		users:          maps.Clone(s.users),
+		sessions:       maps.Clone(s.sessions),
+		rooms:          maps.Clone(s.rooms),
+		mods:           maps.Clone(s.mods),
+		roles:          maps.Clone(s.roles),
+		members:        maps.Clone(s.members),
+		bans:           maps.Clone(s.bans),
+		messages:       maps.Clone(s.messages),
+		audit:          maps.Clone(s.audit),
+		refreshTokens:  maps.Clone(s.refreshTokens),
+		recoveryCodes:  maps.Clone(s.recoveryCodes),
+		passwordResets: maps.Clone(s.passwordResets),
+		identities:     maps.Clone(s.identities),
+		throttles:      maps.Clone(s.throttles),
+		apiKeys:        maps.Clone(s.apiKeys),
+	}
+	s.mu.Unlock()
+
+	rollback := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.users = before.users
+		s.sessions = before.sessions
+		s.rooms = before.rooms
+		s.mods = before.mods
+		s.roles = before.roles
+		s.members = before.members
+		s.bans = before.bans
+		s.messages = before.messages
+		s.audit = before.audit
+		s.refreshTokens = before.refreshTokens
+		s.recoveryCodes = before.recoveryCodes
+		s.passwordResets = before.passwordResets
+		s.identities = before.identities
+		s.throttles = before.throttles
+		s.apiKeys = before.apiKeys
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
+
+// stamp sets the timestamps gorm would set when saving g
+func stamp(g *models.GivenFields) {
+	now := time.Now()
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = now
+	}
+	g.UpdatedAt = now
+}
+
+// deleteWhere deletes everything in table that matches
+func deleteWhere[T any](table map[string]T, matches func(T) bool) {
+	for id, v := range table {
+		if matches(v) {
+			delete(table, id)
+		}
+	}
+}
+
+func has(set map[string]struct{}, key string) bool {
+	_, ok := set[key]
+	return ok
+}
+
+type memoryUsers struct {
+	*memoryStore
+}
+
+func (r *memoryUsers) Create(u *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.users[u.ID]; exists {
+		return ErrDuplicate
+	}
+	for _, existing := range r.users {
+		if existing.Email == u.Email {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&u.GivenFields)
+	r.users[u.ID] = *u
+	return nil
+}
+
+func (r *memoryUsers) FindByID(id string) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, exists := r.users[id]
+	if !exists {
+		return u, ErrNotFound
+	}
+	return u, nil
+}
+
+func (r *memoryUsers) FindByEmail(email string) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r *memoryUsers) Save(u *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp(&u.GivenFields)
+	r.users[u.ID] = *u
+	return nil
+}
+
+func (r *memoryUsers) Delete(u *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.users[u.ID]; !exists {
+		return ErrNotFound
+	}
+
+	userIDs := map[string]struct{}{u.ID: {}}
+	for id, bot := range r.users {
+		if bot.Bot && bot.OwnerID == u.ID {
+			userIDs[id] = struct{}{}
+		}
+	}
+
+	sessionIDs := make(map[string]struct{})
+	for id, sesh := range r.sessions {
+		if has(userIDs, sesh.UserID) {
+			sessionIDs[id] = struct{}{}
+		}
+	}
+
+	deleteWhere(r.refreshTokens, func(t models.RefreshToken) bool { return has(sessionIDs, t.SessionID) })
+	deleteWhere(r.sessions, func(s models.Session) bool { return has(userIDs, s.UserID) })
+	deleteWhere(r.mods, func(m models.RoomMod) bool { return has(userIDs, m.UserID) })
+	deleteWhere(r.members, func(m models.RoomUser) bool { return has(userIDs, m.UserID) })
+	deleteWhere(r.bans, func(b models.RoomBan) bool { return has(userIDs, b.UserID) })
+	deleteWhere(r.messages, func(m models.RoomMessage) bool { return has(userIDs, m.UserID) })
+	deleteWhere(r.recoveryCodes, func(rc models.RecoveryCode) bool { return has(userIDs, rc.UserID) })
+	deleteWhere(r.passwordResets, func(reset models.PasswordReset) bool { return has(userIDs, reset.UserID) })
+	deleteWhere(r.identities, func(i models.UserIdentity) bool { return has(userIDs, i.UserID) })
+	deleteWhere(r.apiKeys, func(k models.APIKey) bool { return has(userIDs, k.UserID) || has(userIDs, k.CreatedByID) })
+	deleteWhere(r.users, func(u models.User) bool { return has(userIDs, u.ID) })
+	return nil
+}
+
+func (r *memoryUsers) UpdatePassword(id string, password string, salt string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, exists := r.users[id]
+	if !exists {
+		return nil
+	}
+	u.Password = password
+	u.PasswordSalt = salt
+	r.users[id] = u
+	return nil
+}
+
+func (r *memoryUsers) MarkEmailVerified(id string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, exists := r.users[id]
+	if !exists || u.EmailVerifiedAt != nil {
+		return false, nil
+	}
+	u.EmailVerifiedAt = &at
+	r.users[id] = u
+	return true, nil
+}
+
+func (r *memoryUsers) ClaimTOTPStep(id string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, exists := r.users[id]
+	if !exists || u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	r.users[id] = u
+	return true, nil
+}
+
+func (r *memoryUsers) FindBot(ownerID string, id string) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, exists := r.users[id]
+	if !exists || !u.Bot || u.OwnerID != ownerID {
+		return models.User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (r *memoryUsers) ListBots(ownerID string) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var bots []models.User
+	for _, u := range r.users {
+		if u.Bot && u.OwnerID == ownerID {
+			bots = append(bots, u)
+		}
+	}
+	slices.SortFunc(bots, func(a, b models.User) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return bots, nil
+}
+
+type memorySessions struct {
+	*memoryStore
+}
+
+func (r *memorySessions) Create(s *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.sessions[s.ID]; exists {
+		return ErrDuplicate
+	}
+
+	stamp(&s.GivenFields)
+	r.sessions[s.ID] = *s
+	return nil
+}
+
+func (r *memorySessions) FindByID(id string) (models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, exists := r.sessions[id]
+	if !exists {
+		return s, ErrNotFound
+	}
+	return s, nil
+}
+
+func (r *memorySessions) FindByToken(userID string, tokenHash string) (models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.UserID == userID && s.Token == tokenHash {
+			return s, nil
+		}
+	}
+	return models.Session{}, ErrNotFound
+}
+
+func (r *memorySessions) ListActive(userID string, now time.Time) ([]models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sessions []models.Session
+	for _, s := range r.sessions {
+		if s.UserID == userID && s.RefreshExpiresAt.After(now) {
+			sessions = append(sessions, s)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b models.Session) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (r *memorySessions) Save(s *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp(&s.GivenFields)
+	r.sessions[s.ID] = *s
+	return nil
+}
+
+func (r *memorySessions) Touch(s *models.Session, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.sessions[s.ID]
+	if !exists {
+		return nil
+	}
+	stored.LastUsedAt = &at
+	r.sessions[s.ID] = stored
+	return nil
+}
+
+func (r *memorySessions) Delete(s *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.sessions[s.ID]; !exists {
+		return ErrNotFound
+	}
+	delete(r.sessions, s.ID)
+	return nil
+}
+
+func (r *memorySessions) DeleteAll(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, s := range r.sessions {
+		if s.UserID == userID {
+			delete(r.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (r *memorySessions) DeleteOthers(userID string, keepID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for id, s := range r.sessions {
+		if s.UserID == userID && id != keepID {
+			delete(r.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *memorySessions) DeleteExpired(userID string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, s := range r.sessions {
+		if s.UserID == userID && s.RefreshExpiresAt.Before(now) {
+			delete(r.sessions, id)
+		}
+	}
+	return nil
+}
+
+type memoryRooms struct {
+	*memoryStore
+}
+
+func (r *memoryRooms) Create(room *models.Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.rooms[room.ID]; exists {
+		return ErrDuplicate
+	}
+
+	stamp(&room.GivenFields)
+	r.rooms[room.ID] = *room
+	return nil
+}
+
+func (r *memoryRooms) FindByID(id string) (models.Room, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	room, exists := r.rooms[id]
+	if !exists {
+		return room, ErrNotFound
+	}
+	return room, nil
+}
+
+func (r *memoryRooms) Lock(id string) error {
+	// transactions already run one at a time
+	_, err := r.FindByID(id)
+	return err
+}
+
+func (r *memoryRooms) Save(room *models.Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp(&room.GivenFields)
+	r.rooms[room.ID] = *room
+	return nil
+}
+
+func (r *memoryRooms) Delete(room *models.Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.rooms[room.ID]; !exists {
+		return ErrNotFound
+	}
+
+	deleteWhere(r.mods, func(m models.RoomMod) bool { return m.RoomID == room.ID })
+	deleteWhere(r.roles, func(role models.RoomRole) bool { return role.RoomID == room.ID })
+	deleteWhere(r.members, func(m models.RoomUser) bool { return m.RoomID == room.ID })
+	deleteWhere(r.bans, func(b models.RoomBan) bool { return b.RoomID == room.ID })
+	deleteWhere(r.audit, func(e models.RoomAuditEntry) bool { return e.RoomID == room.ID })
+	deleteWhere(r.messages, func(m models.RoomMessage) bool { return m.RoomID == room.ID })
+	delete(r.rooms, room.ID)
+	return nil
+}
+
+func (r *memoryRooms) UpdatePassword(id string, password string, salt string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	room, exists := r.rooms[id]
+	if !exists {
+		return nil
+	}
+	room.Password = password
+	room.PasswordSalt = salt
+	r.rooms[id] = room
+	return nil
+}
+
+func (r *memoryRooms) ListPublic(opts RoomListOptions) ([]RoomListing, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]int64)
+	for _, m := range r.members {
+		counts[m.RoomID]++
+	}
+
+	search := strings.ToLower(opts.Search)
+
+	var rooms []RoomListing
+	for _, room := range r.rooms {
+		if room.Private || (opts.HideProtected && room.PasswordProtected) {
+			continue
+		}
+		if opts.RoomIDs != nil && !slices.Contains(opts.RoomIDs, room.ID) {
+			continue
+		}
+		// LIKE is case insensitive with MySQL's default collation
+		if search != "" && !strings.Contains(strings.ToLower(room.Name), search) {
+			continue
+		}
+
+		rooms = append(rooms, RoomListing{
+			ID:                room.ID,
+			Name:              room.Name,
+			PasswordProtected: room.PasswordProtected,
+			MemberCount:       counts[room.ID],
+		})
+	}
+
+	slices.SortFunc(rooms, func(a, b RoomListing) int {
+		if c := cmp.Compare(b.MemberCount, a.MemberCount); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return page(rooms, opts.Offset, opts.Limit), nil
+}
+
+// page returns the limit items of s starting at offset
+func page[T any](s []T, offset int, limit int) []T {
+	if offset >= len(s) {
+		return nil
+	}
+	s = s[offset:]
+	if limit < len(s) {
+		s = s[:limit]
+	}
+	return s
+}
+
+type memoryMods struct {
+	*memoryStore
+}
+
+func (r *memoryMods) Create(m *models.RoomMod) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.mods[m.ID]; exists {
+		return ErrDuplicate
+	}
+	for _, existing := range r.mods {
+		if existing.RoomID == m.RoomID && existing.UserID == m.UserID {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&m.GivenFields)
+	r.mods[m.ID] = *m
+	return nil
+}
+
+func (r *memoryMods) Find(roomID string, userID string) (models.RoomMod, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.mods {
+		if m.RoomID == roomID && m.UserID == userID {
+			return m, nil
+		}
+	}
+	return models.RoomMod{}, ErrNotFound
+}
+
+func (r *memoryMods) Save(m *models.RoomMod) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp(&m.GivenFields)
+	r.mods[m.ID] = *m
+	return nil
+}
+
+func (r *memoryMods) Delete(m *models.RoomMod) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.mods[m.ID]; !exists {
+		return ErrNotFound
+	}
+	delete(r.mods, m.ID)
+	return nil
+}
+
+func (r *memoryMods) CountRole(roomID string, role int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for _, m := range r.mods {
+		if m.RoomID == roomID && m.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryMods) ListOwned(userIDs []string) ([]OwnedRoom, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	owners := make(map[string][2]int64)
+	for _, m := range r.mods {
+		if m.Role != models.RoomModRoleOwner {
+			continue
+		}
+		counts := owners[m.RoomID]
+		if slices.Contains(userIDs, m.UserID) {
+			counts[0]++
+		} else {
+			counts[1]++
+		}
+		owners[m.RoomID] = counts
+	}
+
+	var owned []OwnedRoom
+	for roomID, counts := range owners {
+		if counts[0] > 0 {
+			owned = append(owned, OwnedRoom{RoomID: roomID, OtherOwners: counts[1]})
+		}
+	}
+	slices.SortFunc(owned, func(a, b OwnedRoom) int { return cmp.Compare(a.RoomID, b.RoomID) })
+	return owned, nil
+}
+
+func (r *memoryMods) CountWithRole(roleID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for _, m := range r.mods {
+		if m.RoleID != nil && *m.RoleID == roleID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type memoryRoles struct {
+	*memoryStore
+}
+
+// nameTaken reports whether another of role's room's roles already has its name
+func (r *memoryRoles) nameTaken(role *models.RoomRole) bool {
+	for _, existing := range r.roles {
+		if existing.ID != role.ID && existing.RoomID == role.RoomID && existing.Name == role.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryRoles) Create(role *models.RoomRole) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.roles[role.ID]; exists || r.nameTaken(role) {
+		return ErrDuplicate
+	}
+
+	stamp(&role.GivenFields)
+	r.roles[role.ID] = *role
+	return nil
+}
+
+func (r *memoryRoles) Find(roomID string, id string) (models.RoomRole, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	role, exists := r.roles[id]
+	if !exists || role.RoomID != roomID {
+		return models.RoomRole{}, ErrNotFound
+	}
+	return role, nil
+}
+
+func (r *memoryRoles) List(roomID string) ([]models.RoomRole, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var roles []models.RoomRole
+	for _, role := range r.roles {
+		if role.RoomID == roomID {
+			roles = append(roles, role)
+		}
+	}
+	slices.SortFunc(roles, func(a, b models.RoomRole) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return roles, nil
+}
+
+func (r *memoryRoles) Save(role *models.RoomRole) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(role) {
+		return ErrDuplicate
+	}
+
+	stamp(&role.GivenFields)
+	r.roles[role.ID] = *role
+	return nil
+}
+
+func (r *memoryRoles) Delete(role *models.RoomRole) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.roles[role.ID]; !exists {
+		return ErrNotFound
+	}
+	delete(r.roles, role.ID)
+	return nil
+}
+
+type memoryMembers struct {
+	*memoryStore
+}
+
+func (r *memoryMembers) Create(m *models.RoomUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.members[m.ID]; exists {
+		return ErrDuplicate
+	}
+	for _, existing := range r.members {
+		if existing.RoomID == m.RoomID && existing.UserID == m.UserID {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&m.GivenFields)
+	r.members[m.ID] = *m
+	return nil
+}
+
+func (r *memoryMembers) Find(roomID string, userID string) (models.RoomUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.members {
+		if m.RoomID == roomID && m.UserID == userID {
+			return m, nil
+		}
+	}
+	return models.RoomUser{}, ErrNotFound
+}
+
+func (r *memoryMembers) Delete(m *models.RoomUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.members[m.ID]; !exists {
+		return ErrNotFound
+	}
+	delete(r.members, m.ID)
+	return nil
+}
+
+func (r *memoryMembers) Save(m *models.RoomUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp(&m.GivenFields)
+	r.members[m.ID] = *m
+	return nil
+}
+
+func (r *memoryMembers) CountRooms(userID string, roomIDs []string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	in := make(map[string]struct{})
+	for _, m := range r.members {
+		if m.UserID == userID && slices.Contains(roomIDs, m.RoomID) {
+			in[m.RoomID] = struct{}{}
+		}
+	}
+	return int64(len(in)), nil
+}
+
+func (r *memoryMembers) ListRooms(userID string) ([]MemberRoom, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rooms []MemberRoom
+	for _, m := range r.members {
+		if m.UserID != userID {
+			continue
+		}
+
+		room, exists := r.rooms[m.RoomID]
+		if !exists {
+			continue
+		}
+
+		var role *int
+		for _, mod := range r.mods {
+			if mod.RoomID == room.ID && mod.UserID == userID {
+				role = &mod.Role
+				break
+			}
+		}
+
+		rooms = append(rooms, MemberRoom{
+			ID:                room.ID,
+			Name:              room.Name,
+			PasswordProtected: room.PasswordProtected,
+			Private:           room.Private,
+			Muted:             m.Muted,
+			Role:              role,
+		})
+	}
+
+	slices.SortFunc(rooms, func(a, b MemberRoom) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return rooms, nil
+}
+
+type memoryBans struct {
+	*memoryStore
+}
+
+func (r *memoryBans) Create(b *models.RoomBan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.bans[b.ID]; exists {
+		return ErrDuplicate
+	}
+	for _, existing := range r.bans {
+		if existing.RoomID == b.RoomID && existing.UserID == b.UserID {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&b.GivenFields)
+	r.bans[b.ID] = *b
+	return nil
+}
+
+func (r *memoryBans) Find(roomID string, userID string) (models.RoomBan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, b := range r.bans {
+		if b.RoomID == roomID && b.UserID == userID {
+			return b, nil
+		}
+	}
+	return models.RoomBan{}, ErrNotFound
+}
+
+func (r *memoryBans) Delete(b *models.RoomBan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.bans[b.ID]; !exists {
+		return ErrNotFound
+	}
+	delete(r.bans, b.ID)
+	return nil
+}
+
+type memoryMessages struct {
+	*memoryStore
+}
+
+func (r *memoryMessages) Create(m *models.RoomMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.messages[m.ID]; exists {
+		return ErrDuplicate
+	}
+
+	stamp(&m.GivenFields)
+	r.messageSeq++
+	m.Seq = r.messageSeq
+	r.messages[m.ID] = *m
+	return nil
+}
+
+func (r *memoryMessages) Find(roomIDs []string, id string) (models.RoomMessage, error) {
+	m, err := r.FindCursor(roomIDs, id)
+	if err == nil && m.DeletedAt.Valid {
+		return models.RoomMessage{}, ErrNotFound
+	}
+	return m, err
+}
+
+func (r *memoryMessages) FindCursor(roomIDs []string, id string) (models.RoomMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, exists := r.messages[id]
+	if !exists || !slices.Contains(roomIDs, m.RoomID) {
+		return models.RoomMessage{}, ErrNotFound
+	}
+	return m, nil
+}
+
+func (r *memoryMessages) Save(m *models.RoomMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp(&m.GivenFields)
+	r.messages[m.ID] = *m
+	return nil
+}
+
+func (r *memoryMessages) Delete(m *models.RoomMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.messages[m.ID]
+	if !exists || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+
+	// messages are soft deleted like they are by gorm
+	stored.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	r.messages[m.ID] = stored
+	return nil
+}
+
+func (r *memoryMessages) ListBefore(roomID string, cursor *models.RoomMessage, limit int) ([]models.RoomMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var messages []models.RoomMessage
+	for _, m := range r.messages {
+		if m.RoomID == roomID && !m.DeletedAt.Valid && (cursor == nil || m.Seq < cursor.Seq) {
+			messages = append(messages, m)
+		}
+	}
+
+	slices.SortFunc(messages, func(a, b models.RoomMessage) int {
+		return cmp.Compare(b.Seq, a.Seq)
+	})
+	return page(messages, 0, limit), nil
+}
+
+func (r *memoryMessages) ListAfter(roomIDs []string, cursor models.RoomMessage, limit int) ([]models.RoomMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var messages []models.RoomMessage
+	for _, m := range r.messages {
+		if slices.Contains(roomIDs, m.RoomID) && !m.DeletedAt.Valid && m.Seq > cursor.Seq {
+			messages = append(messages, m)
+		}
+	}
+
+	slices.SortFunc(messages, func(a, b models.RoomMessage) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	return page(messages, 0, limit), nil
+}
+
+type memoryAudit struct {
+	*memoryStore
+}
+
+func (r *memoryAudit) Create(e *models.RoomAuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.audit[e.ID]; exists {
+		return ErrDuplicate
+	}
+
+	stamp(&e.GivenFields)
+	r.audit[e.ID] = *e
+	return nil
+}
+
+func (r *memoryAudit) List(roomID string, limit int) ([]models.RoomAuditEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []models.RoomAuditEntry
+	for _, e := range r.audit {
+		if e.RoomID == roomID {
+			entries = append(entries, e)
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b models.RoomAuditEntry) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	return page(entries, 0, limit), nil
+}
+
+type memoryRefreshTokens struct {
+	*memoryStore
+}
+
+func (r *memoryRefreshTokens) Create(t *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.refreshTokens {
+		if existing.ID == t.ID || existing.Token == t.Token {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&t.GivenFields)
+	r.refreshTokens[t.ID] = *t
+	return nil
+}
+
+func (r *memoryRefreshTokens) FindByToken(tokenHash string) (models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.refreshTokens {
+		if t.Token == tokenHash {
+			return t, nil
+		}
+	}
+	return models.RefreshToken{}, ErrNotFound
+}
+
+func (r *memoryRefreshTokens) Claim(t *models.RefreshToken, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.refreshTokens[t.ID]
+	if !exists || existing.UsedAt != nil {
+		return false, nil
+	}
+
+	existing.UsedAt = &at
+	r.refreshTokens[t.ID] = existing
+	t.UsedAt = &at
+	return true, nil
+}
+
+type memoryRecoveryCodes struct {
+	*memoryStore
+}
+
+func (r *memoryRecoveryCodes) Create(rc *models.RecoveryCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.recoveryCodes[rc.ID]; exists {
+		return ErrDuplicate
+	}
+
+	stamp(&rc.GivenFields)
+	r.recoveryCodes[rc.ID] = *rc
+	return nil
+}
+
+func (r *memoryRecoveryCodes) ListUnused(userID string, lookup string) ([]models.RecoveryCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var codes []models.RecoveryCode
+	for _, rc := range r.recoveryCodes {
+		if rc.UserID == userID && rc.Lookup == lookup && rc.UsedAt == nil {
+			codes = append(codes, rc)
+		}
+	}
+	return codes, nil
+}
+
+func (r *memoryRecoveryCodes) Claim(rc *models.RecoveryCode, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.recoveryCodes[rc.ID]
+	if !exists || existing.UsedAt != nil {
+		return false, nil
+	}
+
+	existing.UsedAt = &at
+	r.recoveryCodes[rc.ID] = existing
+	rc.UsedAt = &at
+	return true, nil
+}
+
+func (r *memoryRecoveryCodes) DeleteAll(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleteWhere(r.recoveryCodes, func(rc models.RecoveryCode) bool { return rc.UserID == userID })
+	return nil
+}
+
+type memoryPasswordResets struct {
+	*memoryStore
+}
+
+func (r *memoryPasswordResets) Create(reset *models.PasswordReset) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.passwordResets {
+		if existing.ID == reset.ID || existing.Token == reset.Token {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&reset.GivenFields)
+	r.passwordResets[reset.ID] = *reset
+	return nil
+}
+
+func (r *memoryPasswordResets) FindByToken(tokenHash string) (models.PasswordReset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reset := range r.passwordResets {
+		if reset.Token == tokenHash {
+			return reset, nil
+		}
+	}
+	return models.PasswordReset{}, ErrNotFound
+}
+
+func (r *memoryPasswordResets) Claim(reset *models.PasswordReset, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.passwordResets[reset.ID]
+	if !exists || existing.UsedAt != nil {
+		return false, nil
+	}
+
+	existing.UsedAt = &at
+	r.passwordResets[reset.ID] = existing
+	reset.UsedAt = &at
+	return true, nil
+}
+
+func (r *memoryPasswordResets) DeleteUnused(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleteWhere(r.passwordResets, func(reset models.PasswordReset) bool {
+		return reset.UserID == userID && reset.UsedAt == nil
+	})
+	return nil
+}
+
+type memoryIdentities struct {
+	*memoryStore
+}
+
+func (r *memoryIdentities) Create(i *models.UserIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.identities {
+		if existing.ID == i.ID || (existing.Issuer == i.Issuer && existing.Subject == i.Subject) {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&i.GivenFields)
+	r.identities[i.ID] = *i
+	return nil
+}
+
+func (r *memoryIdentities) Find(issuer string, subject string) (models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, i := range r.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return models.UserIdentity{}, ErrNotFound
+}
+
+type memoryThrottles struct {
+	*memoryStore
+}
+
+func (r *memoryThrottles) Create(t *models.LoginThrottle) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.throttles {
+		if existing.ID == t.ID || existing.ThrottleKey == t.ThrottleKey {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&t.GivenFields)
+	r.throttles[t.ID] = *t
+	return nil
+}
+
+func (r *memoryThrottles) Find(key string) (models.LoginThrottle, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.throttles {
+		if t.ThrottleKey == key {
+			return t, nil
+		}
+	}
+	return models.LoginThrottle{}, ErrNotFound
+}
+
+func (r *memoryThrottles) List(keys []string) ([]models.LoginThrottle, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var throttles []models.LoginThrottle
+	for _, t := range r.throttles {
+		if slices.Contains(keys, t.ThrottleKey) {
+			throttles = append(throttles, t)
+		}
+	}
+	return throttles, nil
+}
+
+func (r *memoryThrottles) AddFailure(t *models.LoginThrottle, at time.Time, restart bool, lockedUntil *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.throttles[t.ID]
+	if !exists {
+		return nil
+	}
+
+	if restart {
+		stored.Failures = 1
+	} else {
+		stored.Failures++
+	}
+	stored.LastFailedAt = &at
+	if lockedUntil != nil {
+		stored.LockedUntil = lockedUntil
+	}
+	stamp(&stored.GivenFields)
+	r.throttles[t.ID] = stored
+	return nil
+}
+
+func (r *memoryThrottles) Delete(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleteWhere(r.throttles, func(t models.LoginThrottle) bool { return t.ThrottleKey == key })
+	return nil
+}
+
+type memoryAPIKeys struct {
+	*memoryStore
+}
+
+func (r *memoryAPIKeys) Create(k *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.apiKeys {
+		if existing.ID == k.ID || existing.Token == k.Token {
+			return ErrDuplicate
+		}
+	}
+
+	stamp(&k.GivenFields)
+	r.apiKeys[k.ID] = *k
+	return nil
+}
+
+func (r *memoryAPIKeys) FindByToken(tokenHash string) (models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.apiKeys {
+		if k.Token == tokenHash {
+			return k, nil
+		}
+	}
+	return models.APIKey{}, ErrNotFound
+}
+
+func (r *memoryAPIKeys) Find(createdByID string, id string) (models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, exists := r.apiKeys[id]
+	if !exists || k.CreatedByID != createdByID {
+		return models.APIKey{}, ErrNotFound
+	}
+	return k, nil
+}
+
+func (r *memoryAPIKeys) ListCreatedBy(createdByID string) ([]models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []models.APIKey
+	for _, k := range r.apiKeys {
+		if k.CreatedByID == createdByID {
+			keys = append(keys, k)
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b models.APIKey) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return keys, nil
+}
+
+func (r *memoryAPIKeys) Touch(k *models.APIKey, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.apiKeys[k.ID]
+	if !exists {
+		return nil
+	}
+	stored.LastUsedAt = &at
+	r.apiKeys[k.ID] = stored
+	return nil
+}
+
+func (r *memoryAPIKeys) Delete(k *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.apiKeys[k.ID]; !exists {
+		return ErrNotFound
+	}
+	delete(r.apiKeys, k.ID)
+	return nil
+}
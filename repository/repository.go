@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"errors"
+	"github.com/jessehorne/superchat-core/database/models"
+	"time"
+)
+
+// ErrNotFound is returned when a lookup doesn't match anything
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned when a record would break a unique constraint, like two users with the same email
+var ErrDuplicate = errors.New("duplicate")
+
+type UserRepository interface {
+	Create(u *models.User) error
+	FindByID(id string) (models.User, error)
+	FindByEmail(email string) (models.User, error)
+	Save(u *models.User) error
+	// Delete deletes u along with the bots it owns and everything that belongs to either, all at once
+	Delete(u *models.User) error
+	UpdatePassword(id string, password string, salt string) error
+	// MarkEmailVerified sets EmailVerifiedAt unless it's already set, it returns false if it was
+	MarkEmailVerified(id string, at time.Time) (bool, error)
+	// ClaimTOTPStep records step as the last TOTP step used, it returns false if step isn't newer than the last one
+	ClaimTOTPStep(id string, step int64) (bool, error)
+	FindBot(ownerID string, id string) (models.User, error)
+	ListBots(ownerID string) ([]models.User, error)
+}
+
+type SessionRepository interface {
+	Create(s *models.Session) error
+	FindByID(id string) (models.Session, error)
+	FindByToken(userID string, tokenHash string) (models.Session, error)
+	// ListActive lists userID's sessions that can still be refreshed at now, newest first
+	ListActive(userID string, now time.Time) ([]models.Session, error)
+	Save(s *models.Session) error
+	Touch(s *models.Session, at time.Time) error
+	Delete(s *models.Session) error
+	DeleteAll(userID string) error
+	// DeleteOthers deletes every one of userID's sessions except keepID and returns how many there were
+	DeleteOthers(userID string, keepID string) (int64, error)
+	// DeleteExpired deletes userID's sessions that can no longer be refreshed at now
+	DeleteExpired(userID string, now time.Time) error
+}
+
+// RoomListing is a public room as shown by RoomRepository.ListPublic
+type RoomListing struct {
+	ID                string
+	Name              string
+	PasswordProtected bool
+	MemberCount       int64
+}
+
+type RoomListOptions struct {
+	// Search matches room names containing it
+	Search        string
+	HideProtected bool
+	// RoomIDs limits the listing to those rooms when it isn't nil
+	RoomIDs []string
+	Limit   int
+	Offset  int
+}
+
+type RoomRepository interface {
+	Create(r *models.Room) error
+	FindByID(id string) (models.Room, error)
+	// Lock locks room id until the end of the transaction it's called in, so transactions that change who owns the
+	// room run one at a time and see each other's changes. It should be the first thing the transaction does.
+	Lock(id string) error
+	Save(r *models.Room) error
+	// Delete deletes r along with its mods, roles, members, bans, messages and audit entries, all at once
+	Delete(r *models.Room) error
+	UpdatePassword(id string, password string, salt string) error
+	// ListPublic lists rooms that aren't private with the most members first
+	ListPublic(opts RoomListOptions) ([]RoomListing, error)
+}
+
+// There's only ever one mod and one member record for a room and user, Create returns ErrDuplicate for a second one.
+// Removing someone deletes the record for good so they can come back later.
+type ModRepository interface {
+	Create(m *models.RoomMod) error
+	Find(roomID string, userID string) (models.RoomMod, error)
+	Save(m *models.RoomMod) error
+	Delete(m *models.RoomMod) error
+	CountRole(roomID string, role int) (int64, error)
+	// CountWithRole returns how many mods have the custom role roleID
+	CountWithRole(roleID string) (int64, error)
+	// ListOwned lists the rooms owned by anyone in userIDs, sorted by room ID
+	ListOwned(userIDs []string) ([]OwnedRoom, error)
+}
+
+// OwnedRoom is a room listed by ModRepository.ListOwned
+type OwnedRoom struct {
+	RoomID string
+	// OtherOwners is how many of the room's owners weren't asked about
+	OtherOwners int64
+}
+
+// Role names are unique within a room, Create and Save return ErrDuplicate for one that's taken
+type RoleRepository interface {
+	Create(r *models.RoomRole) error
+	Find(roomID string, id string) (models.RoomRole, error)
+	// List lists roomID's roles sorted by name
+	List(roomID string) ([]models.RoomRole, error)
+	Save(r *models.RoomRole) error
+	Delete(r *models.RoomRole) error
+}
+
+// There's only ever one ban for a room and user, Create returns ErrDuplicate for a second one
+type BanRepository interface {
+	Create(b *models.RoomBan) error
+	Find(roomID string, userID string) (models.RoomBan, error)
+	Delete(b *models.RoomBan) error
+}
+
+// MemberRoom is a room a user is in as shown by MemberRepository.ListRooms. Role is nil if they aren't a mod.
+type MemberRoom struct {
+	ID                string
+	Name              string
+	PasswordProtected bool
+	Private           bool
+	Muted             bool
+	Role              *int
+}
+
+type MemberRepository interface {
+	Create(m *models.RoomUser) error
+	Find(roomID string, userID string) (models.RoomUser, error)
+	Save(m *models.RoomUser) error
+	Delete(m *models.RoomUser) error
+	// CountRooms returns how many of roomIDs userID is in
+	CountRooms(userID string, roomIDs []string) (int64, error)
+	// ListRooms lists every room userID is in sorted by name
+	ListRooms(userID string) ([]MemberRoom, error)
+}
+
+// Messages are ordered by seq, created_at can't be used since it only has whole seconds on MySQL
+type MessageRepository interface {
+	Create(m *models.RoomMessage) error
+	// Find returns message id if it's in one of roomIDs
+	Find(roomIDs []string, id string) (models.RoomMessage, error)
+	// FindCursor is Find but it also finds deleted messages, since a message someone is paging from can be deleted
+	FindCursor(roomIDs []string, id string) (models.RoomMessage, error)
+	Save(m *models.RoomMessage) error
+	Delete(m *models.RoomMessage) error
+	// ListBefore returns up to limit of roomID's messages from before cursor, newest first. A nil cursor starts from
+	// the newest message.
+	ListBefore(roomID string, cursor *models.RoomMessage, limit int) ([]models.RoomMessage, error)
+	// ListAfter returns up to limit messages in roomIDs from after cursor, oldest first
+	ListAfter(roomIDs []string, cursor models.RoomMessage, limit int) ([]models.RoomMessage, error)
+}
+
+type AuditRepository interface {
+	Create(e *models.RoomAuditEntry) error
+	// List returns up to limit of roomID's entries, newest first
+	List(roomID string, limit int) ([]models.RoomAuditEntry, error)
+}
+
+// Claim methods mark a one-time token used. They return false if it already was, so only one request can claim it.
+
+type RefreshTokenRepository interface {
+	Create(r *models.RefreshToken) error
+	FindByToken(tokenHash string) (models.RefreshToken, error)
+	Claim(r *models.RefreshToken, at time.Time) (bool, error)
+}
+
+type RecoveryCodeRepository interface {
+	Create(rc *models.RecoveryCode) error
+	// ListUnused lists userID's recovery codes with lookup that haven't been used
+	ListUnused(userID string, lookup string) ([]models.RecoveryCode, error)
+	Claim(rc *models.RecoveryCode, at time.Time) (bool, error)
+	DeleteAll(userID string) error
+}
+
+type PasswordResetRepository interface {
+	Create(r *models.PasswordReset) error
+	FindByToken(tokenHash string) (models.PasswordReset, error)
+	Claim(r *models.PasswordReset, at time.Time) (bool, error)
+	// DeleteUnused deletes userID's resets that haven't been used
+	DeleteUnused(userID string) error
+}
+
+type IdentityRepository interface {
+	Create(i *models.UserIdentity) error
+	Find(issuer string, subject string) (models.UserIdentity, error)
+}
+
+// Throttles count failed logins against a key, like an account or an IP. There's only ever one for a key, Create
+// returns ErrDuplicate for a second one.
+type ThrottleRepository interface {
+	Create(t *models.LoginThrottle) error
+	Find(key string) (models.LoginThrottle, error)
+	// List lists the throttles of whichever of keys have one
+	List(keys []string) ([]models.LoginThrottle, error)
+	// AddFailure counts another failure against t at, or starts counting again from one if restart is true. t is also
+	// locked out until lockedUntil unless it's nil.
+	AddFailure(t *models.LoginThrottle, at time.Time, restart bool, lockedUntil *time.Time) error
+	Delete(key string) error
+}
+
+type APIKeyRepository interface {
+	Create(k *models.APIKey) error
+	FindByToken(tokenHash string) (models.APIKey, error)
+	// Find returns key id if it was made by createdByID
+	Find(createdByID string, id string) (models.APIKey, error)
+	// ListCreatedBy lists the keys createdByID made, oldest first
+	ListCreatedBy(createdByID string) ([]models.APIKey, error)
+	Touch(k *models.APIKey, at time.Time) error
+	Delete(k *models.APIKey) error
+}
+
+// Repositories holds everything the routes use to get at storage
+type Repositories struct {
+	Users          UserRepository
+	Sessions       SessionRepository
+	Rooms          RoomRepository
+	Mods           ModRepository
+	Roles          RoleRepository
+	Members        MemberRepository
+	Bans           BanRepository
+	Messages       MessageRepository
+	Audit          AuditRepository
+	RefreshTokens  RefreshTokenRepository
+	RecoveryCodes  RecoveryCodeRepository
+	PasswordResets PasswordResetRepository
+	Identities     IdentityRepository
+	Throttles      ThrottleRepository
+	APIKeys        APIKeyRepository
+
+	transaction func(fn func(tx *Repositories) error) error
+}
+
+// Transaction runs fn with repositories that make all of their changes at once. If fn returns an error, or panics,
+// none of them are kept. Anything with side effects outside of storage, like publishing events, belongs after it.
+func (r *Repositories) Transaction(fn func(tx *Repositories) error) error {
+	return r.transaction(fn)
+}
+
+// GRepos is what the routes use, main sets it with InitRepositories and tests can use NewMemoryRepositories
+var GRepos *Repositories
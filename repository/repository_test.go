@@ -0,0 +1,473 @@
+package repository
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forEachImplementation runs test against the in-memory repositories and ones backed by SQLite
+func forEachImplementation(t *testing.T, test func(t *testing.T, repos *Repositories)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryRepositories())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, testSQLite(t))
+	})
+}
+
+func given() models.GivenFields {
+	return models.GivenFields{
+		ID: uuid.New().String(),
+	}
+}
+
+// testRoom makes a room with owner as its owner and member as a member who has posted in it
+func testRoom(t *testing.T, repos *Repositories, owner models.User, member models.User) models.Room {
+	room := models.Room{GivenFields: given(), Name: "room"}
+	if err := repos.Rooms.Create(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	mod := models.RoomMod{GivenFields: given(), RoomID: room.ID, UserID: owner.ID, Role: models.RoomModRoleOwner}
+	if err := repos.Mods.Create(&mod); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []models.User{owner, member} {
+		roomUser := models.RoomUser{GivenFields: given(), RoomID: room.ID, UserID: user.ID}
+		if err := repos.Members.Create(&roomUser); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	message := models.RoomMessage{GivenFields: given(), RoomID: room.ID, UserID: member.ID, Message: "hi"}
+	if err := repos.Messages.Create(&message); err != nil {
+		t.Fatal(err)
+	}
+
+	return room
+}
+
+func testUsers(t *testing.T, repos *Repositories, names ...string) []models.User {
+	var users []models.User
+	for _, name := range names {
+		u := models.User{GivenFields: given(), Email: name + "@example.com", Name: name}
+		if err := repos.Users.Create(&u); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+func Test_Membership_Unique(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "member")
+		room := testRoom(t, repos, users[0], users[1])
+
+		again := models.RoomUser{GivenFields: given(), RoomID: room.ID, UserID: users[1].ID}
+		if err := repos.Members.Create(&again); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("A second membership should give ErrDuplicate, got %v", err)
+		}
+
+		mod := models.RoomMod{GivenFields: given(), RoomID: room.ID, UserID: users[0].ID, Role: models.RoomModRoleMod}
+		if err := repos.Mods.Create(&mod); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("A second mod record should give ErrDuplicate, got %v", err)
+		}
+
+		// leaving and coming back is fine
+		member, err := repos.Members.Find(room.ID, users[1].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Members.Delete(&member); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Members.Create(&again); err != nil {
+			t.Errorf("Rejoining a room should work, got %v", err)
+		}
+	})
+}
+
+func Test_Room_DeleteCascades(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "member")
+		room := testRoom(t, repos, users[0], users[1])
+
+		if err := repos.Rooms.Delete(&room); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repos.Mods.Find(room.ID, users[0].ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleting a room should delete its mods, got %v", err)
+		}
+		if _, err := repos.Members.Find(room.ID, users[1].ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleting a room should delete its members, got %v", err)
+		}
+		if messages, _ := repos.Messages.ListBefore(room.ID, nil, 10); len(messages) != 0 {
+			t.Errorf("Deleting a room should delete its messages, %d are left", len(messages))
+		}
+		if rooms, _ := repos.Members.ListRooms(users[1].ID); len(rooms) != 0 {
+			t.Error("A deleted room shouldn't be listed for its members.")
+		}
+	})
+}
+
+func Test_Mods_ListOwned(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "bot", "other")
+		owner, bot, other := users[0], users[1], users[2]
+
+		solo := testRoom(t, repos, owner, other)
+		shared := testRoom(t, repos, owner, other)
+		withBot := testRoom(t, repos, bot, owner)
+		elsewhere := testRoom(t, repos, other, owner)
+		for _, mod := range []models.RoomMod{
+			{GivenFields: given(), RoomID: shared.ID, UserID: other.ID, Role: models.RoomModRoleOwner},
+			{GivenFields: given(), RoomID: withBot.ID, UserID: owner.ID, Role: models.RoomModRoleOwner},
+			{GivenFields: given(), RoomID: elsewhere.ID, UserID: owner.ID, Role: models.RoomModRoleMod},
+		} {
+			if err := repos.Mods.Create(&mod); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		owned, err := repos.Mods.ListOwned([]string{owner.ID, bot.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []OwnedRoom{{RoomID: solo.ID}, {RoomID: shared.ID, OtherOwners: 1}, {RoomID: withBot.ID}}
+		slices.SortFunc(want, func(a, b OwnedRoom) int { return strings.Compare(a.RoomID, b.RoomID) })
+		if !slices.Equal(owned, want) {
+			t.Errorf("Expected %+v, got %+v", want, owned)
+		}
+
+		if owned, err := repos.Mods.ListOwned([]string{"nobody"}); err != nil || len(owned) != 0 {
+			t.Errorf("Someone who doesn't own anything shouldn't have rooms listed, got %+v: %v", owned, err)
+		}
+	})
+}
+
+func Test_User_DeleteCascades(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "member")
+		room := testRoom(t, repos, users[0], users[1])
+
+		bot := models.User{GivenFields: given(), Email: "bot@bots.invalid", Bot: true, OwnerID: users[1].ID}
+		if err := repos.Users.Create(&bot); err != nil {
+			t.Fatal(err)
+		}
+		botMember := models.RoomUser{GivenFields: given(), RoomID: room.ID, UserID: bot.ID}
+		if err := repos.Members.Create(&botMember); err != nil {
+			t.Fatal(err)
+		}
+
+		now := time.Now()
+		sesh := models.Session{
+			GivenFields:      given(),
+			UserID:           users[1].ID,
+			Token:            "hash",
+			ExpiresAt:        now.Add(time.Hour),
+			RefreshExpiresAt: now.Add(time.Hour),
+		}
+		if err := repos.Sessions.Create(&sesh); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repos.Users.Delete(&users[1]); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repos.Users.FindByID(bot.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleting a user should delete their bots, got %v", err)
+		}
+		if _, err := repos.Sessions.FindByID(sesh.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleting a user should delete their sessions, got %v", err)
+		}
+		if _, err := repos.Members.Find(room.ID, users[1].ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleting a user should delete their memberships, got %v", err)
+		}
+		if _, err := repos.Members.Find(room.ID, bot.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleting a user should delete their bots' memberships, got %v", err)
+		}
+		if messages, _ := repos.Messages.ListBefore(room.ID, nil, 10); len(messages) != 0 {
+			t.Errorf("Deleting a user should delete their messages, %d are left", len(messages))
+		}
+
+		// everyone else is left alone
+		if _, err := repos.Members.Find(room.ID, users[0].ID); err != nil {
+			t.Errorf("Deleting a user shouldn't touch other members, got %v", err)
+		}
+	})
+}
+
+func Test_Transaction(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "member")
+		failure := errors.New("failure")
+
+		var room models.Room
+		err := repos.Transaction(func(tx *Repositories) error {
+			room = testRoom(t, tx, users[0], users[1])
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Errorf("Transaction should return the error that stopped it, got %v", err)
+		}
+		if _, err := repos.Rooms.FindByID(room.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("A failed transaction shouldn't leave its room behind, got %v", err)
+		}
+		if _, err := repos.Mods.Find(room.ID, users[0].ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("A failed transaction shouldn't leave its mods behind, got %v", err)
+		}
+		if rooms, _ := repos.Members.ListRooms(users[1].ID); len(rooms) != 0 {
+			t.Error("A failed transaction shouldn't leave its members behind.")
+		}
+
+		err = repos.Transaction(func(tx *Repositories) error {
+			room = testRoom(t, tx, users[0], users[1])
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.Mods.Find(room.ID, users[0].ID); err != nil {
+			t.Errorf("A transaction that worked should keep its changes, got %v", err)
+		}
+	})
+}
+
+func Test_Roles(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "member")
+		room := testRoom(t, repos, users[0], users[1])
+
+		helper := models.RoomRole{GivenFields: given(), RoomID: room.ID, Name: "helper", Permissions: "kick"}
+		if err := repos.Roles.Create(&helper); err != nil {
+			t.Fatal(err)
+		}
+
+		again := models.RoomRole{GivenFields: given(), RoomID: room.ID, Name: "helper"}
+		if err := repos.Roles.Create(&again); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("A second role with the same name should give ErrDuplicate, got %v", err)
+		}
+
+		other := models.RoomRole{GivenFields: given(), RoomID: room.ID, Name: "other"}
+		if err := repos.Roles.Create(&other); err != nil {
+			t.Fatal(err)
+		}
+		other.Name = "helper"
+		if err := repos.Roles.Save(&other); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Renaming a role to one that's taken should give ErrDuplicate, got %v", err)
+		}
+
+		mod := models.RoomMod{
+			GivenFields: given(),
+			RoomID:      room.ID,
+			UserID:      users[1].ID,
+			Role:        models.RoomModRoleCustom,
+			RoleID:      &helper.ID,
+		}
+		if err := repos.Mods.Create(&mod); err != nil {
+			t.Fatal(err)
+		}
+		if count, _ := repos.Mods.CountWithRole(helper.ID); count != 1 {
+			t.Errorf("One mod should have the role, got %d", count)
+		}
+
+		found, err := repos.Mods.Find(room.ID, users[1].ID)
+		if err != nil || found.RoleID == nil || *found.RoleID != helper.ID {
+			t.Errorf("A mod's custom role should be kept, got %v", found.RoleID)
+		}
+
+		ban := models.RoomBan{GivenFields: given(), RoomID: room.ID, UserID: users[1].ID}
+		if err := repos.Bans.Create(&ban); err != nil {
+			t.Fatal(err)
+		}
+		banAgain := models.RoomBan{GivenFields: given(), RoomID: room.ID, UserID: users[1].ID}
+		if err := repos.Bans.Create(&banAgain); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("A second ban should give ErrDuplicate, got %v", err)
+		}
+
+		if err := repos.Rooms.Delete(&room); err != nil {
+			t.Fatal(err)
+		}
+		if roles, _ := repos.Roles.List(room.ID); len(roles) != 0 {
+			t.Errorf("Deleting a room should delete its roles, %d are left", len(roles))
+		}
+		if _, err := repos.Bans.Find(room.ID, users[1].ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleting a room should delete its bans, got %v", err)
+		}
+	})
+}
+
+func Test_Audit(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "member")
+		room := testRoom(t, repos, users[0], users[1])
+
+		now := time.Now()
+		for i, target := range []string{"first", "second"} {
+			e := models.RoomAuditEntry{
+				GivenFields: given(),
+				RoomID:      room.ID,
+				ActorID:     users[0].ID,
+				Action:      models.AuditOwnershipTransferred,
+				TargetID:    target,
+			}
+			e.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+			if err := repos.Audit.Create(&e); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		entries, err := repos.Audit.List(room.ID, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].TargetID != "second" {
+			t.Errorf("The newest entry should be listed first, got %+v", entries)
+		}
+
+		if err := repos.Rooms.Delete(&room); err != nil {
+			t.Fatal(err)
+		}
+		if entries, _ := repos.Audit.List(room.ID, 10); len(entries) != 0 {
+			t.Errorf("Deleting a room should delete its audit log, %d entries are left", len(entries))
+		}
+	})
+}
+
+func Test_Messages_Order(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "member")
+		room := testRoom(t, repos, users[0], users[1])
+		first, _ := repos.Messages.ListBefore(room.ID, nil, 1)
+
+		// MySQL would store these in the same second, and their IDs go the other way
+		second := time.Now().Truncate(time.Second)
+		var posted []models.RoomMessage
+		for _, id := range []string{"c", "b", "a"} {
+			m := models.RoomMessage{GivenFields: models.GivenFields{ID: id, CreatedAt: second}, RoomID: room.ID,
+				UserID: users[0].ID, Message: id}
+			if err := repos.Messages.Create(&m); err != nil {
+				t.Fatal(err)
+			}
+			posted = append(posted, m)
+		}
+
+		ids := func(messages []models.RoomMessage) string {
+			var out []string
+			for _, m := range messages {
+				out = append(out, m.ID)
+			}
+			return strings.Join(out, "")
+		}
+
+		if messages, err := repos.Messages.ListAfter([]string{room.ID}, first[0], 10); ids(messages) != "cba" {
+			t.Errorf("Messages should be listed in the order they were posted, got %q (%v)", ids(messages), err)
+		}
+
+		cursor, err := repos.Messages.Find([]string{room.ID}, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messages, err := repos.Messages.ListBefore(room.ID, &cursor, 2); ids(messages) != "bc" {
+			t.Errorf("Messages before a cursor should be listed newest first, got %q (%v)", ids(messages), err)
+		}
+
+		if err := repos.Messages.Delete(&posted[1]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.Messages.Find([]string{room.ID}, "b"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("A deleted message shouldn't be found, got %v", err)
+		}
+
+		cursor, err = repos.Messages.FindCursor([]string{room.ID}, "b")
+		if err != nil {
+			t.Fatalf("A deleted message should still work as a cursor, got %v", err)
+		}
+		if messages, _ := repos.Messages.ListAfter([]string{room.ID}, cursor, 10); ids(messages) != "a" {
+			t.Errorf("Listing from a deleted cursor should pick up after it, got %q", ids(messages))
+		}
+		if messages, _ := repos.Messages.ListAfter([]string{room.ID}, first[0], 10); ids(messages) != "ca" {
+			t.Errorf("Deleted messages shouldn't be listed, got %q", ids(messages))
+		}
+	})
+}
+
+func Test_Throttles(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		throttle := models.LoginThrottle{GivenFields: given(), ThrottleKey: "ip:127.0.0.1"}
+		if err := repos.Throttles.Create(&throttle); err != nil {
+			t.Fatal(err)
+		}
+		again := models.LoginThrottle{GivenFields: given(), ThrottleKey: "ip:127.0.0.1"}
+		if err := repos.Throttles.Create(&again); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("A second throttle for a key should give ErrDuplicate, got %v", err)
+		}
+
+		now := time.Now()
+		repos.Throttles.AddFailure(&throttle, now, false, nil)
+		repos.Throttles.AddFailure(&throttle, now, false, nil)
+		if found, _ := repos.Throttles.Find(throttle.ThrottleKey); found.Failures != 2 || found.LockedUntil != nil {
+			t.Errorf("Two failures should be counted without a lockout, got %d", found.Failures)
+		}
+
+		until := now.Add(time.Minute)
+		repos.Throttles.AddFailure(&throttle, now, true, &until)
+		throttles, err := repos.Throttles.List([]string{throttle.ThrottleKey, "account:nobody"})
+		if err != nil || len(throttles) != 1 {
+			t.Fatalf("Only the key with a throttle should be listed, got %v: %v", throttles, err)
+		}
+		if throttles[0].Failures != 1 || throttles[0].LockedUntil == nil {
+			t.Errorf("Restarting should count from one and keep the lockout, got %+v", throttles[0])
+		}
+
+		if err := repos.Throttles.Delete(throttle.ThrottleKey); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.Throttles.Find(throttle.ThrottleKey); !errors.Is(err, ErrNotFound) {
+			t.Errorf("A deleted throttle shouldn't be found, got %v", err)
+		}
+	})
+}
+
+func Test_APIKeys(t *testing.T) {
+	forEachImplementation(t, func(t *testing.T, repos *Repositories) {
+		users := testUsers(t, repos, "owner", "other")
+
+		key := models.APIKey{GivenFields: given(), UserID: users[0].ID, CreatedByID: users[0].ID, Token: "hash"}
+		if err := repos.APIKeys.Create(&key); err != nil {
+			t.Fatal(err)
+		}
+
+		if found, err := repos.APIKeys.FindByToken("hash"); err != nil || found.ID != key.ID {
+			t.Errorf("A key should be found by its token, got %v", err)
+		}
+		if _, err := repos.APIKeys.Find(users[1].ID, key.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("A key shouldn't be found for someone who didn't make it, got %v", err)
+		}
+
+		now := time.Now()
+		if err := repos.APIKeys.Touch(&key, now); err != nil {
+			t.Fatal(err)
+		}
+		if keys, _ := repos.APIKeys.ListCreatedBy(users[0].ID); len(keys) != 1 || keys[0].LastUsedAt == nil {
+			t.Errorf("The touched key should be listed for who made it, got %v", keys)
+		}
+
+		if err := repos.Users.Delete(&users[0]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.APIKeys.FindByToken("hash"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleting a user should delete their api keys, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,363 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/middleware"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type BotCreateRequest struct {
+	Name string `json:"name" binding:"required,min=1,max=255"`
+}
+
+// BotCreate makes a bot account owned by the authenticated user. Bots can't log in, they only act through API keys
+// their owner makes for them.
+func BotCreate(c *gin.Context) {
+	var req BotCreateRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	if user.Bot {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "bots can't make bots",
+		})
+		return
+	}
+
+	// bots still need a unique email, nobody knows their password
+	id := uuid.New().String()
+	bot := models.User{
+		GivenFields: models.GivenFields{
+			ID: id,
+		},
+		Email:    fmt.Sprintf("bot-%s@bots.invalid", id),
+		Name:     req.Name,
+		Password: util.HashPassword(uuid.New().String()),
+		Bot:      true,
+		OwnerID:  user.ID,
+	}
+
+	if err := repository.GRepos.Users.Create(&bot); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "couldn't create bot",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"botID": bot.ID,
+	})
+}
+
+// BotList lists the bots the authenticated user owns
+func BotList(c *gin.Context) {
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	bots, err := repository.GRepos.Users.ListBots(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error getting bots",
+		})
+		return
+	}
+
+	out := make([]gin.H, 0, len(bots))
+	for _, b := range bots {
+		out = append(out, gin.H{
+			"botID":     b.ID,
+			"name":      b.Name,
+			"createdAt": b.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bots": out,
+	})
+}
+
+type BotDeleteRequest struct {
+	BotID string `json:"botID" binding:"required"`
+}
+
+// BotDelete removes one of the authenticated user's bots along with all of its API keys
+func BotDelete(c *gin.Context) {
+	var req BotDeleteRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	bot, err := repository.GRepos.Users.FindBot(user.ID, req.BotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "bot not found",
+		})
+		return
+	}
+
+	if err := repository.GRepos.Users.Delete(&bot); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "db issue while deleting bot",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type APIKeyCreateRequest struct {
+	Name    string   `json:"name" binding:"required,min=1,max=255"`
+	Scopes  []string `json:"scopes" binding:"required,min=1"`
+	RoomIDs []string `json:"roomIDs"`
+	// BotID makes the key act as one of the user's bots instead of the user
+	BotID         string `json:"botID"`
+	ExpiresInDays int    `json:"expiresInDays" binding:"min=0"`
+}
+
+// APIKeyCreate makes a new API key. The key is only ever shown in this response.
+func APIKeyCreate(c *gin.Context) {
+	var req APIKeyCreateRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":  "invalid scope " + scope,
+				"scopes": models.Scopes,
+			})
+			return
+		}
+	}
+
+	for _, roomID := range req.RoomIDs {
+		if roomID == "" || strings.ContainsAny(roomID, " \t\n") {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid roomID",
+			})
+			return
+		}
+	}
+
+	keyUserID := user.ID
+	if req.BotID != "" {
+		bot, err := repository.GRepos.Users.FindBot(user.ID, req.BotID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "bot not found",
+			})
+			return
+		}
+		keyUserID = bot.ID
+	}
+
+	token, hash := util.CreateToken()
+
+	key := models.APIKey{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		UserID:      keyUserID,
+		CreatedByID: user.ID,
+		Name:        req.Name,
+		Token:       hash,
+		Scopes:      strings.Join(req.Scopes, " "),
+		RoomIDs:     strings.Join(req.RoomIDs, " "),
+	}
+
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := repository.GRepos.APIKeys.Create(&key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "couldn't create api key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"keyID": key.ID,
+		"key":   middleware.APIKeyPrefix + token,
+	})
+}
+
+// APIKeyList lists the API keys the authenticated user has made, including the ones for their bots
+func APIKeyList(c *gin.Context) {
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	keys, err := repository.GRepos.APIKeys.ListCreatedBy(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error getting api keys",
+		})
+		return
+	}
+
+	out := make([]gin.H, 0, len(keys))
+	for _, k := range keys {
+		var expiresAt, lastUsedAt *string
+		if k.ExpiresAt != nil {
+			formatted := k.ExpiresAt.Format(time.RFC3339)
+			expiresAt = &formatted
+		}
+		if k.LastUsedAt != nil {
+			formatted := k.LastUsedAt.Format(time.RFC3339)
+			lastUsedAt = &formatted
+		}
+
+		out = append(out, gin.H{
+			"keyID":      k.ID,
+			"name":       k.Name,
+			"userID":     k.UserID,
+			"scopes":     strings.Fields(k.Scopes),
+			"roomIDs":    strings.Fields(k.RoomIDs),
+			"createdAt":  k.CreatedAt.Format(time.RFC3339),
+			"expiresAt":  expiresAt,
+			"lastUsedAt": lastUsedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys": out,
+	})
+}
+
+type APIKeyRevokeRequest struct {
+	KeyID string `json:"keyID" binding:"required"`
+}
+
+// APIKeyRevoke deletes one of the API keys the authenticated user made
+func APIKeyRevoke(c *gin.Context) {
+	var req APIKeyRevokeRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	key, err := repository.GRepos.APIKeys.Find(user.ID, req.KeyID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "api key not found",
+		})
+		return
+	}
+
+	if err := repository.GRepos.APIKeys.Delete(&key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error revoking api key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// apiKeyAllowsRoom is false when the request was made with an API key that's restricted to other rooms
+func apiKeyAllowsRoom(c *gin.Context, roomID string) bool {
+	k, exists := c.Get("apiKey")
+	if !exists {
+		return true
+	}
+
+	return k.(models.APIKey).AllowsRoom(roomID)
+}
+
+// apiKeyRooms is the rooms the request's API key is limited to, or nil when it can use every room
+func apiKeyRooms(c *gin.Context) []string {
+	k, exists := c.Get("apiKey")
+	if !exists || k.(models.APIKey).RoomIDs == "" {
+		return nil
+	}
+
+	return strings.Fields(k.(models.APIKey).RoomIDs)
+}
+
+// apiKeyRestricted is true when the request was made with an API key that's limited to certain rooms
+func apiKeyRestricted(c *gin.Context) bool {
+	k, exists := c.Get("apiKey")
+	if !exists {
+		return false
+	}
+
+	return k.(models.APIKey).RoomIDs != ""
+}
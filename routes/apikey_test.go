@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/middleware"
+	"github.com/jessehorne/superchat-core/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testAPIKeyRequest runs handler behind the scope and auth middleware for a request authenticated with key
+func testAPIKeyRequest(handler gin.HandlerFunc, scope string, key string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/", middleware.Scope(scope), middleware.AuthMiddleware, handler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func Test_APIKey_All(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+
+	w := testRequest(APIKeyCreate, owner, gin.H{"name": "reader", "scopes": []string{models.ScopeRoomsRead}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Making an api key should work, got %d: %s", w.Code, w.Body)
+	}
+
+	var created struct {
+		KeyID string `json:"keyID"`
+		Key   string `json:"key"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	if w := testAPIKeyRequest(UserGetRooms, models.ScopeRoomsRead, created.Key); w.Code != http.StatusOK {
+		t.Errorf("An api key should work on routes with its scope, got %d: %s", w.Code, w.Body)
+	}
+	if w := testAPIKeyRequest(UserGetRooms, models.ScopeMessagesRead, created.Key); w.Code != http.StatusForbidden {
+		t.Errorf("An api key shouldn't work on routes without its scope, got %d", w.Code)
+	}
+	if w := testAPIKeyRequest(UserGetRooms, models.ScopeRoomsRead, middleware.APIKeyPrefix+"nope"); w.Code != http.StatusUnauthorized {
+		t.Errorf("An unknown api key should be refused, got %d", w.Code)
+	}
+
+	if key, _ := repository.GRepos.APIKeys.Find(owner.ID, created.KeyID); key.LastUsedAt == nil {
+		t.Error("Using an api key should record when it was last used.")
+	}
+
+	w = testRequest(APIKeyList, owner, nil)
+	var listed struct {
+		Keys []struct {
+			KeyID string `json:"keyID"`
+		} `json:"keys"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Keys) != 1 || listed.Keys[0].KeyID != created.KeyID {
+		t.Errorf("The new key should be listed, got %s", w.Body)
+	}
+
+	if w := testRequest(APIKeyRevoke, owner, gin.H{"keyID": created.KeyID}); w.Code != http.StatusOK {
+		t.Fatalf("Revoking an api key should work, got %d: %s", w.Code, w.Body)
+	}
+	if w := testAPIKeyRequest(UserGetRooms, models.ScopeRoomsRead, created.Key); w.Code != http.StatusUnauthorized {
+		t.Errorf("A revoked api key should be refused, got %d", w.Code)
+	}
+
+}
+
+func Test_APIKey_RestrictedListings(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	allowed := testModRoom(t, owner)
+	testModRoom(t, owner)
+
+	w := testRequest(APIKeyCreate, owner, gin.H{
+		"name":    "one room",
+		"scopes":  []string{models.ScopeRoomsRead},
+		"roomIDs": []string{allowed},
+	})
+	var created struct {
+		Key string `json:"key"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	var listed struct {
+		Rooms []struct {
+			RoomID string `json:"roomID"`
+		} `json:"rooms"`
+	}
+	for name, handler := range map[string]gin.HandlerFunc{"UserGetRooms": UserGetRooms, "RoomList": RoomList} {
+		w := testAPIKeyRequest(handler, models.ScopeRoomsRead, created.Key)
+		listed.Rooms = nil
+		json.Unmarshal(w.Body.Bytes(), &listed)
+		if w.Code != http.StatusOK || len(listed.Rooms) != 1 || listed.Rooms[0].RoomID != allowed {
+			t.Errorf("%s should only list the key's rooms, got %d: %s", name, w.Code, w.Body)
+		}
+	}
+}
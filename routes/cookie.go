@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/middleware"
+	"net/http"
+	"time"
+)
+
+const (
+	// refreshCookie holds the refresh token of a browser session, it's only ever sent to the refresh route
+	refreshCookie     = "superchat_refresh"
+	refreshCookiePath = "/api/user/token/refresh"
+	// csrfCookie lets the web client read the session's CSRF token back after a page reload
+	csrfCookie = "superchat_csrf"
+)
+
+// setSessionCookies sets the cookies of a browser session
+func setSessionCookies(c *gin.Context, sesh models.Session, token string, refreshToken string, csrfToken string) {
+	setCookie(c, middleware.SessionCookie, token, "/", sesh.RefreshExpiresAt, true)
+	setCookie(c, refreshCookie, refreshToken, refreshCookiePath, sesh.RefreshExpiresAt, true)
+	setCookie(c, csrfCookie, csrfToken, "/", sesh.RefreshExpiresAt, false)
+}
+
+// clearSessionCookies removes the cookies of a browser session
+func clearSessionCookies(c *gin.Context) {
+	setCookie(c, middleware.SessionCookie, "", "/", time.Unix(0, 0), true)
+	setCookie(c, refreshCookie, "", refreshCookiePath, time.Unix(0, 0), true)
+	setCookie(c, csrfCookie, "", "/", time.Unix(0, 0), false)
+}
+
+func setCookie(c *gin.Context, name string, value string, path string, expires time.Time, httpOnly bool) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Expires:  expires,
+		HttpOnly: httpOnly,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
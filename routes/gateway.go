@@ -0,0 +1,209 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/repository"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	gatewayWriteWait  = 10 * time.Second
+	gatewayPongWait   = 60 * time.Second
+	gatewayPingPeriod = (gatewayPongWait * 9) / 10
+	gatewayMaxMessage = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     gatewayOriginAllowed,
+}
+
+// gatewayOriginAllowed stops other sites from opening the gateway with a visitor's session cookie. Browsers always
+// send Origin on WebSocket handshakes so one that doesn't come from APP_URL or this host is refused. Other clients
+// don't send it at all.
+func gatewayOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	if appURL, err := url.Parse(os.Getenv("APP_URL")); err == nil && appURL.Host != "" &&
+		strings.EqualFold(u.Scheme, appURL.Scheme) && strings.EqualFold(u.Host, appURL.Host) {
+		return true
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// GatewayCommand is what clients send over the socket to choose which rooms they get events for
+type GatewayCommand struct {
+	Action string `json:"action"`
+	RoomID string `json:"roomID"`
+}
+
+// GatewayReply acknowledges a GatewayCommand
+type GatewayReply struct {
+	Type   string `json:"type"`
+	Action string `json:"action,omitempty"`
+	RoomID string `json:"roomID,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Gateway upgrades the request to a WebSocket that pushes room events. After connecting, a client sends
+// {"action": "subscribe", "roomID": "..."} for each room it wants events from. Only rooms the user is a member of can
+// be subscribed to.
+func Gateway(c *gin.Context) {
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already written an error response
+		log.Println("gateway upgrade:", err)
+		return
+	}
+
+	sub := events.GHub.NewSubscription()
+	replies := make(chan GatewayReply, 8)
+	done := make(chan struct{})
+
+	// api keys can be limited to certain rooms
+	allowsRoom := func(roomID string) bool {
+		return apiKeyAllowsRoom(c, roomID)
+	}
+
+	// the connection can outlast the session it was opened with
+	authenticated := func() bool {
+		return stillAuthenticated(c)
+	}
+
+	go gatewayRead(conn, user, allowsRoom, sub, replies, done)
+	gatewayWrite(conn, user, authenticated, sub, replies, done)
+}
+
+func gatewayRead(conn *websocket.Conn, user models.User, allowsRoom func(string) bool, sub *events.Subscription,
+	replies chan<- GatewayReply, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(gatewayMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(gatewayPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(gatewayPongWait))
+	})
+
+	for {
+		var cmd GatewayCommand
+		if err := conn.ReadJSON(&cmd); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("gateway read:", err)
+			}
+			return
+		}
+
+		reply := GatewayReply{
+			Type:   "ack",
+			Action: cmd.Action,
+			RoomID: cmd.RoomID,
+		}
+
+		switch cmd.Action {
+		case "subscribe":
+			if !allowsRoom(cmd.RoomID) {
+				reply.Type = "error"
+				reply.Error = "api key can't access this room"
+				break
+			}
+
+			// make sure user is in the room
+			_, err := repository.GRepos.Members.Find(cmd.RoomID, user.ID)
+			if err != nil {
+				reply.Type = "error"
+				reply.Error = "you're not in this room"
+				break
+			}
+			sub.Subscribe(cmd.RoomID)
+		case "unsubscribe":
+			sub.Unsubscribe(cmd.RoomID)
+		default:
+			reply.Type = "error"
+			reply.Error = "unknown action"
+		}
+
+		select {
+		case replies <- reply:
+		default:
+			// the writer is backed up, the client will find out soon enough
+		}
+	}
+}
+
+func gatewayWrite(conn *websocket.Conn, user models.User, authenticated func() bool, sub *events.Subscription,
+	replies <-chan GatewayReply, done <-chan struct{}) {
+	ticker := time.NewTicker(gatewayPingPeriod)
+	recheck := time.NewTicker(sessionRecheckPeriod)
+	defer func() {
+		ticker.Stop()
+		recheck.Stop()
+		sub.Close()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(gatewayWriteWait))
+			if !ok {
+				// the hub dropped us for being too slow
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			if deliver, _ := checkMembership(sub, user.ID, e); !deliver {
+				continue
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case r := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(gatewayWriteWait))
+			if err := conn.WriteJSON(r); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(gatewayWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-recheck.C:
+			if !authenticated() {
+				conn.SetWriteDeadline(time.Now().Add(gatewayWriteWait))
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session ended"))
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jessehorne/superchat-core/middleware"
+	"github.com/jessehorne/superchat-core/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAuthServer serves handler on / behind AuthMiddleware
+func testAuthServer(t *testing.T, handler gin.HandlerFunc) *httptest.Server {
+	return testServe(t, middleware.AuthMiddleware, handler)
+}
+
+// testSessionRecheck makes open connections check their session every few milliseconds for the rest of the test
+func testSessionRecheck(t *testing.T) {
+	period := sessionRecheckPeriod
+	sessionRecheckPeriod = 20 * time.Millisecond
+	t.Cleanup(func() { sessionRecheckPeriod = period })
+}
+
+func Test_Gateway_Origin(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	t.Setenv("APP_URL", "https://chat.example.com")
+
+	user := testUser(t, "user")
+	_, tokens := testSession(t, user, false)
+	s := testAuthServer(t, Gateway)
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	for origin, allowed := range map[string]bool{
+		"":                          true,
+		"https://chat.example.com":  true,
+		s.URL:                       true,
+		"https://evil.example.com":  false,
+		"http://chat.example.com":   false,
+		"https://chat.example.com.": false,
+	} {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+tokens.Token)
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+
+		conn, res, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if allowed && err != nil {
+			t.Errorf("Connecting from %q should work, got %v", origin, err)
+		}
+		if !allowed && (err == nil || res == nil || res.StatusCode != http.StatusForbidden) {
+			t.Errorf("Connecting from %q should be refused, got %v", origin, err)
+		}
+	}
+}
+
+func Test_Gateway_SessionEnds(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	testSessionRecheck(t)
+
+	user := testUser(t, "user")
+	roomID := testModRoom(t, user)
+	sesh, tokens := testSession(t, user, false)
+	_, otherTokens := testSession(t, user, false)
+	s := testAuthServer(t, Gateway)
+
+	dial := func(token string) *websocket.Conn {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+token)
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.WriteJSON(GatewayCommand{Action: "subscribe", RoomID: roomID}); err != nil {
+			t.Fatal(err)
+		}
+		var reply GatewayReply
+		if err := conn.ReadJSON(&reply); err != nil || reply.Type != "ack" {
+			t.Fatalf("Subscribing to a room you're in should work, got %+v: %v", reply, err)
+		}
+		return conn
+	}
+	conn := dial(tokens.Token)
+	other := dial(otherTokens.Token)
+
+	// logging out deletes the session
+	if err := repository.GRepos.Sessions.Delete(&sesh); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("The gateway should close once its session is gone, got %v", err)
+	}
+
+	expectGatewayQuiet(t, other)
+}
@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+)
+
+type RoomJoinRequest struct {
+	RoomID   string `json:"roomID" binding:"required"`
+	Password string `json:"password"`
+}
+
+func RoomJoin(c *gin.Context) {
+	var req RoomJoinRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, "")
+	if !ok {
+		return
+	}
+	room := access.Room
+	user := access.User
+
+	// make sure user isn't already in the room
+	_, err = repository.GRepos.Members.Find(req.RoomID, user.ID)
+	if err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "already in room",
+		})
+		return
+	}
+
+	if _, err := repository.GRepos.Bans.Find(req.RoomID, user.ID); err == nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "you're banned from this room",
+		})
+		return
+	}
+
+	if room.PasswordProtected {
+		if req.Password == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "password required",
+			})
+			return
+		}
+
+		if !util.ComparePassword(req.Password, room.PasswordSalt, room.Password) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "invalid password",
+			})
+			return
+		}
+
+		// the password is known to be right so this is our chance to upgrade an outdated hash
+		if util.NeedsRehash(room.Password) {
+			room.Password = util.HashPassword(req.Password)
+			room.PasswordSalt = ""
+			repository.GRepos.Rooms.UpdatePassword(room.ID, room.Password, room.PasswordSalt)
+		}
+	}
+
+	newRoomUser := models.RoomUser{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		RoomID: room.ID,
+		UserID: user.ID,
+		Muted:  false,
+	}
+	if err := repository.GRepos.Members.Create(&newRoomUser); errors.Is(err, repository.ErrDuplicate) {
+		// they joined from somewhere else in the meantime
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "already in room",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error creating room user record",
+		})
+		return
+	}
+
+	events.Publish(events.NewEvent(events.MemberJoined, room.ID, gin.H{
+		"userID": user.ID,
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type RoomLeaveRequest struct {
+	RoomID string `json:"roomID" binding:"required"`
+}
+
+func RoomLeave(c *gin.Context) {
+	var req RoomLeaveRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, "")
+	if !ok {
+		return
+	}
+	user := access.User
+
+	// mods lose their role when they leave but a room always needs an owner, which is checked with the room locked so
+	// two owners can't both leave at once
+	var wasMod bool
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		err := tx.Rooms.Lock(req.RoomID)
+		if err != nil {
+			return err
+		}
+
+		var wasMember bool
+		wasMember, wasMod, err = removeMember(tx, req.RoomID, user.ID)
+		if err == nil && !wasMember {
+			return errNotInRoom
+		}
+		return err
+	})
+	if errors.Is(err, errNotInRoom) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "not in room",
+		})
+		return
+	}
+	if errors.Is(err, errLastOwner) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "you're the last owner, transfer ownership before leaving",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error leaving room",
+		})
+		return
+	}
+
+	if wasMod {
+		events.Publish(events.NewEvent(events.ModChanged, req.RoomID, gin.H{
+			"userID": user.ID,
+			"action": "removed",
+		}))
+	}
+
+	events.Publish(events.NewEvent(events.MemberLeft, req.RoomID, gin.H{
+		"userID": user.ID,
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,210 @@
+package routes
+
+import (
+	"bufio"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/repository"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServe serves handlers on /. Once the test is done it waits for requests that are still going, like gateway
+// connections and event streams, so they can't touch anything the next test sets up.
+func testServe(t *testing.T, handlers ...gin.HandlerFunc) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+
+	var running sync.WaitGroup
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		running.Add(1)
+		defer running.Done()
+		c.Next()
+	})
+	r.GET("/", handlers...)
+
+	s := httptest.NewServer(r)
+	t.Cleanup(func() {
+		s.Close()
+		running.Wait()
+	})
+	return s
+}
+
+// testServer serves handler on / with every request made by user
+func testServer(t *testing.T, handler gin.HandlerFunc, user models.User) *httptest.Server {
+	return testServe(t, func(c *gin.Context) { c.Set("user", user) }, handler)
+}
+
+// testGateway connects to the gateway as user and subscribes to roomID
+func testGateway(t *testing.T, user models.User, roomID string) *websocket.Conn {
+	s := testServer(t, Gateway, user)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.WriteJSON(GatewayCommand{Action: "subscribe", RoomID: roomID}); err != nil {
+		t.Fatal(err)
+	}
+	var reply GatewayReply
+	if err := conn.ReadJSON(&reply); err != nil || reply.Type != "ack" {
+		t.Fatalf("Subscribing to a room you're in should work, got %+v: %v", reply, err)
+	}
+
+	return conn
+}
+
+// testStream opens an event stream for roomID as user and returns its lines as they arrive
+func testStream(t *testing.T, user models.User, roomID string) <-chan string {
+	s := testServer(t, RoomEvents, user)
+
+	res, err := http.Get(s.URL + "?roomID=" + roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Streaming a room you're in should work, got %d", res.StatusCode)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	return lines
+}
+
+// expectGatewayEvent reads the next event off conn and fails unless it's eventType
+func expectGatewayEvent(t *testing.T, conn *websocket.Conn, eventType string) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var e events.Event
+	if err := conn.ReadJSON(&e); err != nil || e.Type != eventType {
+		t.Fatalf("Expected a %s event, got %+v: %v", eventType, e, err)
+	}
+}
+
+// expectGatewayQuiet fails if anything arrives on conn for a little while
+func expectGatewayQuiet(t *testing.T, conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	var e events.Event
+	if err := conn.ReadJSON(&e); err == nil {
+		t.Errorf("Nothing should be delivered from a room the user isn't in, got %+v", e)
+	}
+}
+
+// expectStreamEnd reads lines until the stream ends and fails if it doesn't, or if it never mentions eventType
+func expectStreamEnd(t *testing.T, lines <-chan string, eventType string) {
+	seen := false
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if !seen {
+					t.Errorf("The stream should deliver a %s event before it ends.", eventType)
+				}
+				return
+			}
+			if line == "event:"+eventType {
+				seen = true
+			}
+		case <-timeout:
+			t.Fatal("The stream should end once the user is in none of its rooms.")
+		}
+	}
+}
+
+func Test_Member_JoinLeave(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	second := testUser(t, "second")
+	roomID := testModRoom(t, owner, second)
+
+	if w := testRequest(RoomJoin, second, gin.H{"roomID": "nope"}); w.Code != http.StatusNotFound {
+		t.Errorf("Joining a room that doesn't exist should be refused, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomAddMod, owner, gin.H{"roomID": roomID, "userID": second.ID, "role": models.RoomModRoleOwner}); w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to add another owner, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := testRequest(RoomLeave, owner, gin.H{"roomID": roomID}); w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to leave once there's another one, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := repository.GRepos.Mods.Find(roomID, owner.ID); err == nil {
+		t.Error("Leaving a room should take away the user's mod role.")
+	}
+
+	if w := testRequest(RoomLeave, owner, gin.H{"roomID": roomID}); w.Code != http.StatusBadRequest {
+		t.Errorf("Leaving a room you're not in should be refused, got %d", w.Code)
+	}
+}
+
+func Test_Member_LastOwnersLeave(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owners := []models.User{testUser(t, "first"), testUser(t, "second")}
+	roomID := testModRoom(t, owners[0], owners[1])
+	if w := testRequest(RoomAddMod, owners[0], gin.H{"roomID": roomID, "userID": owners[1].ID, "role": models.RoomModRoleOwner}); w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to add another owner, got %d: %s", w.Code, w.Body)
+	}
+	repository.GRepos.Mods = slowMods{repository.GRepos.Mods}
+
+	// both owners leave at once, one of them has to stay behind
+	var wg sync.WaitGroup
+	codes := make([]int, len(owners))
+	for i, owner := range owners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = testRequest(RoomLeave, owner, gin.H{"roomID": roomID}).Code
+		}()
+	}
+	wg.Wait()
+
+	if !slices.Contains(codes, http.StatusOK) || !slices.Contains(codes, http.StatusBadRequest) {
+		t.Errorf("Only one of the owners should be able to leave, got %v", codes)
+	}
+	if count, err := repository.GRepos.Mods.CountRole(roomID, models.RoomModRoleOwner); err != nil || count != 1 {
+		t.Errorf("The room should be left with one owner, got %d (%v)", count, err)
+	}
+}
+
+func Test_Member_LeaveEndsSubscriptions(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	guest := testUser(t, "guest")
+	roomID := testModRoom(t, owner, guest)
+
+	conn := testGateway(t, guest, roomID)
+	lines := testStream(t, guest, roomID)
+
+	if w := testRequest(RoomLeave, guest, gin.H{"roomID": roomID}); w.Code != http.StatusOK {
+		t.Fatalf("Leaving a room should work, got %d: %s", w.Code, w.Body)
+	}
+	expectGatewayEvent(t, conn, events.MemberLeft)
+	expectStreamEnd(t, lines, events.MemberLeft)
+
+	if w := testRequest(RoomCreateMessage, owner, gin.H{"roomID": roomID, "message": "hi"}); w.Code != http.StatusOK {
+		t.Fatalf("Posting a message should work, got %d: %s", w.Code, w.Body)
+	}
+	expectGatewayQuiet(t, conn)
+}
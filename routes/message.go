@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMessageLimit = 50
+	maxMessageLimit     = 100
+)
+
+type RoomCreateMessageRequest struct {
+	RoomID  string `json:"roomID" binding:"required"`
+	Message string `json:"message" binding:"required,min=1,max=4000"`
+}
+
+func RoomCreateMessage(c *gin.Context) {
+	var req RoomCreateMessageRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, "")
+	if !ok {
+		return
+	}
+	user := access.User
+
+	// make sure user is in the room and allowed to talk
+	roomUser, err := repository.GRepos.Members.Find(req.RoomID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "you're not in this room",
+		})
+		return
+	}
+
+	if roomUser.Muted {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "you're muted",
+		})
+		return
+	}
+
+	newMessage := models.RoomMessage{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		RoomID:  req.RoomID,
+		UserID:  user.ID,
+		Message: req.Message,
+	}
+
+	if err := repository.GRepos.Messages.Create(&newMessage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error saving message",
+		})
+		return
+	}
+
+	// message events share their ID with the message so clients can resume from them
+	events.Publish(events.Event{
+		ID:     newMessage.ID,
+		Type:   events.MessageCreated,
+		RoomID: newMessage.RoomID,
+		Data:   messageResponse(newMessage),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": messageResponse(newMessage),
+	})
+}
+
+// RoomGetMessages returns a page of message history for a room. Pages are keyed off of a message ID instead of an
+// offset: pass ?before=<id> to scroll back from a message or ?after=<id> to catch up from one. With neither, the most
+// recent messages are returned. Messages are always returned oldest first.
+func RoomGetMessages(c *gin.Context) {
+	roomID := c.Query("roomID")
+	before := c.Query("before")
+	after := c.Query("after")
+
+	if roomID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing roomID",
+		})
+		return
+	}
+
+	if before != "" && after != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "can't use before and after together",
+		})
+		return
+	}
+
+	limit := defaultMessageLimit
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid limit",
+			})
+			return
+		}
+		limit = min(parsed, maxMessageLimit)
+	}
+
+	access, ok := authorizeRoom(c, roomID, "")
+	if !ok {
+		return
+	}
+	user := access.User
+
+	// make sure user is in the room
+	_, err := repository.GRepos.Members.Find(roomID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "you're not in this room",
+		})
+		return
+	}
+
+	var cursor *models.RoomMessage
+	if cursorID := before + after; cursorID != "" {
+		// a cursor that's been deleted since it was fetched still marks a place in the history
+		found, err := repository.GRepos.Messages.FindCursor([]string{roomID}, cursorID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "cursor message not found",
+			})
+			return
+		}
+		cursor = &found
+	}
+
+	// fetch one extra row so we know if there is another page
+	var messages []models.RoomMessage
+	if after != "" {
+		messages, err = repository.GRepos.Messages.ListAfter([]string{roomID}, *cursor, limit+1)
+	} else {
+		messages, err = repository.GRepos.Messages.ListBefore(roomID, cursor, limit+1)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error getting messages",
+		})
+		return
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	// always hand messages back oldest first
+	if after == "" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	out := make([]gin.H, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, messageResponse(m))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages": out,
+		"hasMore":  hasMore,
+	})
+}
+
+func messageResponse(m models.RoomMessage) gin.H {
+	var pinnedAt *string
+	if m.PinnedAt != nil {
+		formatted := m.PinnedAt.Format(time.RFC3339)
+		pinnedAt = &formatted
+	}
+
+	return gin.H{
+		"id":        m.ID,
+		"roomID":    m.RoomID,
+		"userID":    m.UserID,
+		"message":   m.Message,
+		"createdAt": m.CreatedAt.Format(time.RFC3339),
+		"pinnedAt":  pinnedAt,
+	}
+}
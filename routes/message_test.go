@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// testHistory fetches a page of roomID's history as user with query added to the request
+func testHistory(t *testing.T, user models.User, roomID string, query string) (int, []string, bool) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?roomID="+roomID+"&"+query, nil)
+	c.Set("user", user)
+
+	RoomGetMessages(c)
+
+	var res struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+		HasMore bool `json:"hasMore"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	var ids []string
+	for _, m := range res.Messages {
+		ids = append(ids, m.ID)
+	}
+	return w.Code, ids, res.HasMore
+}
+
+func Test_Message_History(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	roomID := testModRoom(t, owner)
+	otherRoomID := testModRoom(t, owner)
+
+	// all in the same second with IDs going the opposite way to the order they're posted in
+	testMessages(t, roomID, owner, "e", "d", "c", "b", "a")
+	testMessages(t, otherRoomID, owner, "elsewhere")
+
+	tests := []struct {
+		query   string
+		ids     []string
+		hasMore bool
+	}{
+		{"", []string{"e", "d", "c", "b", "a"}, false},
+		{"limit=2", []string{"b", "a"}, true},
+		{"before=b&limit=2", []string{"d", "c"}, true},
+		{"before=d", []string{"e"}, false},
+		{"after=e&limit=3", []string{"d", "c", "b"}, true},
+		{"after=b", []string{"a"}, false},
+		{"after=a", nil, false},
+	}
+	for _, test := range tests {
+		code, ids, hasMore := testHistory(t, owner, roomID, test.query)
+		if code != http.StatusOK || !slices.Equal(ids, test.ids) || hasMore != test.hasMore {
+			t.Errorf("?%s should return %v (hasMore %t), got %d: %v (hasMore %t)", test.query, test.ids,
+				test.hasMore, code, ids, hasMore)
+		}
+	}
+
+	for _, query := range []string{"before=nope", "after=elsewhere", "before=a&after=b", "limit=0", "limit=x"} {
+		if code, _, _ := testHistory(t, owner, roomID, query); code != http.StatusBadRequest {
+			t.Errorf("?%s should be refused, got %d", query, code)
+		}
+	}
+
+	stranger := testUser(t, "stranger")
+	if code, _, _ := testHistory(t, stranger, roomID, ""); code != http.StatusUnauthorized {
+		t.Errorf("Someone who isn't in the room shouldn't see its history, got %d", code)
+	}
+
+	// a cursor deleted after the client fetched it still marks its place
+	c, _ := repository.GRepos.Messages.Find([]string{roomID}, "c")
+	if err := repository.GRepos.Messages.Delete(&c); err != nil {
+		t.Fatal(err)
+	}
+	code, ids, _ := testHistory(t, owner, roomID, "before=c")
+	if code != http.StatusOK || !slices.Equal(ids, []string{"e", "d"}) {
+		t.Errorf("Paging from a deleted message should still work, got %d: %v", code, ids)
+	}
+	if _, ids, _ := testHistory(t, owner, roomID, ""); !slices.Equal(ids, []string{"e", "d", "b", "a"}) {
+		t.Errorf("Deleted messages shouldn't be in the history, got %v", ids)
+	}
+}
+
+func Test_Message_HistoryLimit(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	roomID := testModRoom(t, owner)
+
+	var ids []string
+	for i := range maxMessageLimit + 1 {
+		ids = append(ids, fmt.Sprintf("message-%03d", i))
+	}
+	testMessages(t, roomID, owner, ids...)
+
+	if _, page, hasMore := testHistory(t, owner, roomID, ""); len(page) != defaultMessageLimit || !hasMore {
+		t.Errorf("History should default to %d messages, got %d", defaultMessageLimit, len(page))
+	}
+
+	_, page, hasMore := testHistory(t, owner, roomID, "limit=1000")
+	if len(page) != maxMessageLimit || !hasMore || page[len(page)-1] != ids[len(ids)-1] {
+		t.Errorf("A big limit should be cut down to the newest %d messages, got %d", maxMessageLimit, len(page))
+	}
+}
@@ -0,0 +1,388 @@
+package routes
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"time"
+)
+
+type RoomKickRequest struct {
+	RoomID string `json:"roomID" binding:"required"`
+	UserID string `json:"userID" binding:"required"`
+}
+
+// RoomKick removes someone from a room. Unlike a ban they can join again right away.
+func RoomKick(c *gin.Context) {
+	var req RoomKickRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionKick)
+	if !ok || !canModerate(c, access, req.UserID) {
+		return
+	}
+
+	// make sure target is in the room
+	_, err = repository.GRepos.Members.Find(req.RoomID, req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "not in room",
+		})
+		return
+	}
+
+	var wasMod bool
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		err := tx.Rooms.Lock(req.RoomID)
+		if err != nil {
+			return err
+		}
+
+		_, wasMod, err = removeMember(tx, req.RoomID, req.UserID)
+		return err
+	})
+	if !ownerChangeOK(c, err, "error kicking user") {
+		return
+	}
+
+	publishRemoved(req.RoomID, req.UserID, access.User.ID, true, wasMod)
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type RoomBanRequest struct {
+	RoomID string `json:"roomID" binding:"required"`
+	UserID string `json:"userID" binding:"required"`
+}
+
+// RoomBan removes someone from a room, if they're in it, and keeps them from joining again until they're unbanned
+func RoomBan(c *gin.Context) {
+	var req RoomBanRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionBan)
+	if !ok || !canModerate(c, access, req.UserID) {
+		return
+	}
+
+	// get target user
+	_, err = repository.GRepos.Users.FindByID(req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no target user",
+		})
+		return
+	}
+
+	ban := models.RoomBan{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		RoomID: req.RoomID,
+		UserID: req.UserID,
+	}
+
+	var wasMember, wasMod bool
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		err := tx.Rooms.Lock(req.RoomID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bans.Create(&ban); err != nil {
+			return err
+		}
+
+		wasMember, wasMod, err = removeMember(tx, req.RoomID, req.UserID)
+		return err
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "already banned",
+		})
+		return
+	}
+	if !ownerChangeOK(c, err, "error banning user") {
+		return
+	}
+
+	publishRemoved(req.RoomID, req.UserID, access.User.ID, wasMember, wasMod)
+
+	events.Publish(events.NewEvent(events.BanChanged, req.RoomID, gin.H{
+		"userID": req.UserID,
+		"by":     access.User.ID,
+		"action": "added",
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type RoomUnbanRequest struct {
+	RoomID string `json:"roomID" binding:"required"`
+	UserID string `json:"userID" binding:"required"`
+}
+
+// RoomUnban lifts a ban so the user can join the room again
+func RoomUnban(c *gin.Context) {
+	var req RoomUnbanRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionBan)
+	if !ok {
+		return
+	}
+
+	ban, err := repository.GRepos.Bans.Find(req.RoomID, req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "not banned",
+		})
+		return
+	}
+
+	if err := repository.GRepos.Bans.Delete(&ban); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error unbanning user",
+		})
+		return
+	}
+
+	events.Publish(events.NewEvent(events.BanChanged, req.RoomID, gin.H{
+		"userID": req.UserID,
+		"by":     access.User.ID,
+		"action": "removed",
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type RoomMuteRequest struct {
+	RoomID string `json:"roomID" binding:"required"`
+	UserID string `json:"userID" binding:"required"`
+	Muted  *bool  `json:"muted" binding:"required"`
+}
+
+// RoomMute stops a member from sending messages to a room, or lets them again
+func RoomMute(c *gin.Context) {
+	var req RoomMuteRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionMute)
+	if !ok || !canModerate(c, access, req.UserID) {
+		return
+	}
+
+	// make sure target is in the room
+	roomUser, err := repository.GRepos.Members.Find(req.RoomID, req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "not in room",
+		})
+		return
+	}
+
+	roomUser.Muted = *req.Muted
+	if err := repository.GRepos.Members.Save(&roomUser); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error updating room user record",
+		})
+		return
+	}
+
+	events.Publish(events.NewEvent(events.MemberMuted, req.RoomID, gin.H{
+		"userID": req.UserID,
+		"muted":  roomUser.Muted,
+		"by":     access.User.ID,
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type RoomDeleteMessageRequest struct {
+	RoomID    string `json:"roomID" binding:"required"`
+	MessageID string `json:"messageID" binding:"required"`
+}
+
+// RoomDeleteMessage deletes someone's message from a room
+func RoomDeleteMessage(c *gin.Context) {
+	var req RoomDeleteMessageRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionDeleteMessages)
+	if !ok {
+		return
+	}
+
+	message, err := repository.GRepos.Messages.Find([]string{req.RoomID}, req.MessageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "message not found",
+		})
+		return
+	}
+
+	// anyone can have their own messages deleted, other mods' only if they don't outrank the user
+	if message.UserID != access.User.ID && !canModerate(c, access, message.UserID) {
+		return
+	}
+
+	if err := repository.GRepos.Messages.Delete(&message); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error deleting message",
+		})
+		return
+	}
+
+	events.Publish(events.NewEvent(events.MessageDeleted, req.RoomID, gin.H{
+		"id": message.ID,
+		"by": access.User.ID,
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type RoomPinMessageRequest struct {
+	RoomID    string `json:"roomID" binding:"required"`
+	MessageID string `json:"messageID" binding:"required"`
+	Pinned    *bool  `json:"pinned" binding:"required"`
+}
+
+// RoomPinMessage pins a message in a room or unpins it
+func RoomPinMessage(c *gin.Context) {
+	var req RoomPinMessageRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	if _, ok := authorizeRoom(c, req.RoomID, models.PermissionPin); !ok {
+		return
+	}
+
+	message, err := repository.GRepos.Messages.Find([]string{req.RoomID}, req.MessageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "message not found",
+		})
+		return
+	}
+
+	if !*req.Pinned {
+		message.PinnedAt = nil
+	} else if message.PinnedAt == nil {
+		now := time.Now()
+		message.PinnedAt = &now
+	}
+
+	if err := repository.GRepos.Messages.Save(&message); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error saving message",
+		})
+		return
+	}
+
+	events.Publish(events.NewEvent(events.MessageUpdated, req.RoomID, messageResponse(message)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": messageResponse(message),
+	})
+}
+
+// canModerate makes sure the user can act against userID in the room. Nobody can moderate themselves or a mod with
+// permissions they don't have.
+func canModerate(c *gin.Context, access roomAccess, userID string) bool {
+	if userID == access.User.ID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "you can't moderate yourself",
+		})
+		return false
+	}
+
+	mod, err := repository.GRepos.Mods.Find(access.Room.ID, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error checking permissions",
+		})
+		return false
+	}
+
+	return canManageMod(c, access, mod)
+}
+
+// removeMember takes userID out of roomID along with any mod role they had in it. It reports whether they were a
+// member and a mod. tx has to have locked the room, since an owner can only be removed if there's another one.
+func removeMember(tx *repository.Repositories, roomID string, userID string) (bool, bool, error) {
+	wasMod := false
+	roomMod, err := tx.Mods.Find(roomID, userID)
+	if err == nil {
+		if roomMod.Role == models.RoomModRoleOwner {
+			if err := keepsAnOwner(tx, roomID); err != nil {
+				return false, false, err
+			}
+		}
+
+		if err := tx.Mods.Delete(&roomMod); err != nil {
+			return false, false, err
+		}
+		wasMod = true
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return false, false, err
+	}
+
+	roomUser, err := tx.Members.Find(roomID, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, wasMod, nil
+	}
+	if err != nil {
+		return false, wasMod, err
+	}
+
+	return true, wasMod, tx.Members.Delete(&roomUser)
+}
+
+// publishRemoved tells a room that userID was made to leave it by byID. The member_left event is also what cuts off
+// userID's own live subscriptions to the room, see checkMembership.
+func publishRemoved(roomID string, userID string, byID string, wasMember bool, wasMod bool) {
+	if wasMod {
+		events.Publish(events.NewEvent(events.ModChanged, roomID, gin.H{
+			"userID": userID,
+			"action": "removed",
+		}))
+	}
+
+	if wasMember {
+		events.Publish(events.NewEvent(events.MemberLeft, roomID, gin.H{
+			"userID": userID,
+			"by":     byID,
+		}))
+	}
+}
@@ -0,0 +1,273 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/repository"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testModRoom makes a room owned by owner that everyone in members has joined
+func testModRoom(t *testing.T, owner models.User, members ...models.User) string {
+	w := testRequest(RoomCreate, owner, gin.H{"name": "lobby"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Creating a room should work, got %d: %s", w.Code, w.Body)
+	}
+
+	var created struct {
+		RoomID string `json:"roomID"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	for _, member := range members {
+		if w := testRequest(RoomJoin, member, gin.H{"roomID": created.RoomID}); w.Code != http.StatusOK {
+			t.Fatalf("Joining a public room should work, got %d: %s", w.Code, w.Body)
+		}
+	}
+
+	return created.RoomID
+}
+
+func Test_Mod_Permissions(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	mod := testUser(t, "mod")
+	guest := testUser(t, "guest")
+	roomID := testModRoom(t, owner, mod, guest)
+
+	if w := testRequest(RoomAddMod, owner, gin.H{"roomID": roomID, "userID": mod.ID, "role": models.RoomModRoleMod}); w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to add a mod, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := testRequest(RoomKick, guest, gin.H{"roomID": roomID, "userID": mod.ID}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Someone who isn't a mod shouldn't be able to kick, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomUpdate, mod, gin.H{"roomID": roomID, "name": "mine"}); w.Code != http.StatusForbidden {
+		t.Errorf("A mod shouldn't be able to manage the room, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomKick, mod, gin.H{"roomID": roomID, "userID": owner.ID}); w.Code != http.StatusForbidden {
+		t.Errorf("A mod shouldn't be able to kick an owner, got %d", w.Code)
+	}
+
+	var posted struct {
+		Message struct {
+			ID string `json:"id"`
+		} `json:"message"`
+	}
+	w := testRequest(RoomCreateMessage, guest, gin.H{"roomID": roomID, "message": "hi"})
+	json.Unmarshal(w.Body.Bytes(), &posted)
+
+	if w := testRequest(RoomPinMessage, mod, gin.H{"roomID": roomID, "messageID": posted.Message.ID, "pinned": true}); w.Code != http.StatusOK {
+		t.Errorf("A mod should be able to pin, got %d: %s", w.Code, w.Body)
+	}
+	if m, _ := repository.GRepos.Messages.Find([]string{roomID}, posted.Message.ID); m.PinnedAt == nil {
+		t.Error("A pinned message should have PinnedAt set.")
+	}
+
+	if w := testRequest(RoomDeleteMessage, mod, gin.H{"roomID": roomID, "messageID": posted.Message.ID}); w.Code != http.StatusOK {
+		t.Errorf("A mod should be able to delete messages, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := testRequest(RoomMute, mod, gin.H{"roomID": roomID, "userID": guest.ID, "muted": true}); w.Code != http.StatusOK {
+		t.Fatalf("A mod should be able to mute, got %d: %s", w.Code, w.Body)
+	}
+	if w := testRequest(RoomCreateMessage, guest, gin.H{"roomID": roomID, "message": "hi"}); w.Code != http.StatusForbidden {
+		t.Errorf("A muted member shouldn't be able to talk, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomKick, mod, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusOK {
+		t.Fatalf("A mod should be able to kick, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := repository.GRepos.Members.Find(roomID, guest.ID); err == nil {
+		t.Error("A kicked user should no longer be a member.")
+	}
+	if w := testRequest(RoomJoin, guest, gin.H{"roomID": roomID}); w.Code != http.StatusOK {
+		t.Errorf("A kicked user should be able to come back, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomBan, mod, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusForbidden {
+		t.Errorf("A mod shouldn't be able to ban, got %d", w.Code)
+	}
+}
+
+func Test_Role_Custom(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	bouncer := testUser(t, "bouncer")
+	guest := testUser(t, "guest")
+	roomID := testModRoom(t, owner, bouncer, guest)
+
+	w := testRequest(RoomCreateRole, owner, gin.H{
+		"roomID":      roomID,
+		"name":        "bouncer",
+		"permissions": []string{models.PermissionBan, models.PermissionManageMods},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to define a role, got %d: %s", w.Code, w.Body)
+	}
+
+	var created struct {
+		RoleID string `json:"roleID"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	if w := testRequest(RoomCreateRole, owner, gin.H{"roomID": roomID, "name": "bouncer"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Role names should be unique in a room, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomCreateRole, owner, gin.H{"roomID": roomID, "name": "x", "permissions": []string{"fly"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("A role with an unknown permission should be refused, got %d", w.Code)
+	}
+
+	w = testRequest(RoomAddMod, owner, gin.H{
+		"roomID": roomID,
+		"userID": bouncer.ID,
+		"role":   models.RoomModRoleCustom,
+		"roleID": created.RoleID,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to give out a custom role, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := testRequest(RoomKick, bouncer, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusForbidden {
+		t.Errorf("A custom role should only give its own permissions, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomBan, bouncer, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusOK {
+		t.Fatalf("A custom role with ban should be able to ban, got %d: %s", w.Code, w.Body)
+	}
+	if w := testRequest(RoomJoin, guest, gin.H{"roomID": roomID}); w.Code != http.StatusForbidden {
+		t.Errorf("A banned user shouldn't be able to join, got %d", w.Code)
+	}
+	if w := testRequest(RoomUnban, bouncer, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusOK {
+		t.Fatalf("A custom role with ban should be able to unban, got %d: %s", w.Code, w.Body)
+	}
+	if w := testRequest(RoomJoin, guest, gin.H{"roomID": roomID}); w.Code != http.StatusOK {
+		t.Errorf("An unbanned user should be able to join, got %d", w.Code)
+	}
+
+	// nobody can hand out more than they have
+	if w := testRequest(RoomAddMod, bouncer, gin.H{"roomID": roomID, "userID": guest.ID, "role": models.RoomModRoleOwner}); w.Code != http.StatusForbidden {
+		t.Errorf("Only owners should be able to add owners, got %d", w.Code)
+	}
+	if w := testRequest(RoomAddMod, bouncer, gin.H{"roomID": roomID, "userID": guest.ID, "role": models.RoomModRoleMod}); w.Code != http.StatusForbidden {
+		t.Errorf("A mod shouldn't be able to give permissions they don't have, got %d", w.Code)
+	}
+	if w := testRequest(RoomCreateRole, bouncer, gin.H{"roomID": roomID, "name": "admin", "permissions": []string{models.PermissionManageRoom}}); w.Code != http.StatusForbidden {
+		t.Errorf("A mod shouldn't be able to define a role with permissions they don't have, got %d", w.Code)
+	}
+	if w := testRequest(RoomDeleteMod, bouncer, gin.H{"roomID": roomID, "userID": owner.ID}); w.Code != http.StatusForbidden {
+		t.Errorf("A mod shouldn't be able to remove an owner, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomDeleteRole, owner, gin.H{"roomID": roomID, "roleID": created.RoleID}); w.Code != http.StatusBadRequest {
+		t.Errorf("A role mods still have shouldn't be deleted, got %d", w.Code)
+	}
+
+	w = testRequest(RoomUpdateRole, owner, gin.H{
+		"roomID":      roomID,
+		"roleID":      created.RoleID,
+		"permissions": []string{models.PermissionKick},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to change a role, got %d: %s", w.Code, w.Body)
+	}
+	if w := testRequest(RoomKick, bouncer, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusOK {
+		t.Errorf("Changing a role should change what its mods can do, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func Test_Mod_LastOwner(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	roomID := testModRoom(t, owner)
+
+	if w := testRequest(RoomUpdateMod, owner, gin.H{"roomID": roomID, "userID": owner.ID, "role": models.RoomModRoleMod}); w.Code != http.StatusBadRequest {
+		t.Errorf("The last owner shouldn't be able to demote themselves, got %d", w.Code)
+	}
+	if w := testRequest(RoomDeleteMod, owner, gin.H{"roomID": roomID, "userID": owner.ID}); w.Code != http.StatusBadRequest {
+		t.Errorf("The last owner shouldn't be able to remove themselves, got %d", w.Code)
+	}
+}
+
+func Test_Mod_LastOwnerConcurrently(t *testing.T) {
+	for name, body := range map[string]func(roomID string, userID string) (gin.HandlerFunc, gin.H){
+		"demote": func(roomID string, userID string) (gin.HandlerFunc, gin.H) {
+			return RoomUpdateMod, gin.H{"roomID": roomID, "userID": userID, "role": models.RoomModRoleMod}
+		},
+		"remove": func(roomID string, userID string) (gin.HandlerFunc, gin.H) {
+			return RoomDeleteMod, gin.H{"roomID": roomID, "userID": userID}
+		},
+		"kick": func(roomID string, userID string) (gin.HandlerFunc, gin.H) {
+			return RoomKick, gin.H{"roomID": roomID, "userID": userID}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			repository.GRepos = repository.NewMemoryRepositories()
+
+			first := testUser(t, "first")
+			second := testUser(t, "second")
+			roomID := testModRoom(t, first, second)
+			if w := testRequest(RoomAddMod, first, gin.H{"roomID": roomID, "userID": second.ID, "role": models.RoomModRoleOwner}); w.Code != http.StatusOK {
+				t.Fatalf("An owner should be able to add another owner, got %d: %s", w.Code, w.Body)
+			}
+			repository.GRepos.Mods = slowMods{repository.GRepos.Mods}
+
+			// both owners try to get rid of each other at once, one of them has to stay
+			var wg sync.WaitGroup
+			codes := make([]int, 2)
+			for i, pair := range [][2]models.User{{first, second}, {second, first}} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					handler, req := body(roomID, pair[1].ID)
+					codes[i] = testRequest(handler, pair[0], req).Code
+				}()
+			}
+			wg.Wait()
+
+			if !slices.Contains(codes, http.StatusOK) {
+				t.Errorf("One of the owners should be able to go, got %v", codes)
+			}
+			if owners, err := repository.GRepos.Mods.CountRole(roomID, models.RoomModRoleOwner); err != nil || owners != 1 {
+				t.Errorf("The room should be left with one owner, got %d (%v) with %v", owners, err, codes)
+			}
+		})
+	}
+}
+
+func Test_Mod_RemovalEndsSubscriptions(t *testing.T) {
+	for name, handler := range map[string]gin.HandlerFunc{"kick": RoomKick, "ban": RoomBan} {
+		t.Run(name, func(t *testing.T) {
+			repository.GRepos = repository.NewMemoryRepositories()
+
+			owner := testUser(t, "owner")
+			guest := testUser(t, "guest")
+			roomID := testModRoom(t, owner, guest)
+
+			conn := testGateway(t, guest, roomID)
+			lines := testStream(t, guest, roomID)
+
+			if w := testRequest(handler, owner, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusOK {
+				t.Fatalf("An owner should be able to remove a member, got %d: %s", w.Code, w.Body)
+			}
+			expectGatewayEvent(t, conn, events.MemberLeft)
+			expectStreamEnd(t, lines, events.MemberLeft)
+
+			if w := testRequest(RoomCreateMessage, owner, gin.H{"roomID": roomID, "message": "hi"}); w.Code != http.StatusOK {
+				t.Fatalf("Posting a message should work, got %d: %s", w.Code, w.Body)
+			}
+			expectGatewayQuiet(t, conn)
+		})
+	}
+}
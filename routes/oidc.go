@@ -0,0 +1,348 @@
+package routes
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/oidc"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	oidcCookie         = "superchat_oidc"
+	oidcCookiePath     = "/api/auth/oidc"
+	oidcLoginLifetime  = 10 * time.Minute
+	oidcLoginPurpose   = "oidc-login"
+	oidcDeviceFallback = "oidc"
+	// users with two-factor get a signed loginToken that's good for oidcSecondFactorLifetime instead of a session
+	oidcSecondFactorLifetime = 5 * time.Minute
+	oidcSecondFactorPurpose  = "oidc-2fa"
+)
+
+var (
+	errNoOIDCUser          = errors.New("linked account no longer exists")
+	errUnverifiedOIDCEmail = errors.New("identity provider hasn't verified your email")
+	errUnverifiedAccount   = errors.New("an account with your email exists but hasn't verified it, verify the email " +
+		"or reset the account's password before logging in this way")
+)
+
+// OIDCLogin starts an OpenID Connect login by redirecting to the identity provider. The state, nonce and PKCE verifier
+// are kept in a short lived signed cookie so nothing has to be stored server side.
+func OIDCLogin(c *gin.Context) {
+	if oidc.GProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "oidc login isn't configured",
+		})
+		return
+	}
+
+	key, err := appKey()
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "oidc login isn't configured",
+		})
+		return
+	}
+
+	state := oidc.RandomString(16)
+	nonce := oidc.RandomString(16)
+	verifier, challenge := oidc.NewPKCE()
+
+	authURL, err := oidc.GProvider.AuthCodeURL(c.Request.Context(), state, nonce, challenge)
+	if err != nil {
+		log.Println("oidc discovery:", err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "couldn't reach identity provider",
+		})
+		return
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(oidcLoginLifetime).Unix(), 10)
+	// the device goes last since it's the only part that could contain a |
+	device := truncate(c.Query("device"), 255)
+	payload := strings.Join([]string{oidcLoginPurpose, state, nonce, verifier, expiresAt, device}, "|")
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, util.SignToken(payload, key), int(oidcLoginLifetime.Seconds()), oidcCookiePath, "",
+		c.Request.TLS != nil || strings.HasPrefix(c.GetHeader("X-Forwarded-Proto"), "https"), true)
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback finishes an OpenID Connect login. The user is found by their linked identity, or linked to an existing
+// account if both sides have verified the email, or created, and then gets a normal session.
+func OIDCCallback(c *gin.Context) {
+	if oidc.GProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "oidc login isn't configured",
+		})
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "identity provider said: " + errCode,
+		})
+		return
+	}
+
+	key, err := appKey()
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "oidc login isn't configured",
+		})
+		return
+	}
+
+	cookie, err := c.Cookie(oidcCookie)
+	// the cookie is single use either way
+	c.SetCookie(oidcCookie, "", -1, oidcCookiePath, "", c.Request.TLS != nil, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "login expired, try again",
+		})
+		return
+	}
+
+	payload, ok := util.VerifySignedToken(cookie, key)
+	parts := strings.SplitN(payload, "|", 6)
+	if !ok || len(parts) != 6 || parts[0] != oidcLoginPurpose {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "login expired, try again",
+		})
+		return
+	}
+
+	state, nonce, verifier, device := parts[1], parts[2], parts[3], parts[5]
+	expiresAt, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "login expired, try again",
+		})
+		return
+	}
+
+	if c.Query("state") == "" || c.Query("state") != state {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid state",
+		})
+		return
+	}
+
+	rawIDToken, err := oidc.GProvider.Exchange(c.Request.Context(), c.Query("code"), verifier)
+	if err != nil {
+		log.Println("oidc exchange:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "couldn't complete login with identity provider",
+		})
+		return
+	}
+
+	claims, err := oidc.GProvider.VerifyIDToken(c.Request.Context(), rawIDToken, nonce)
+	if err != nil {
+		log.Println("oidc id token:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid id token",
+		})
+		return
+	}
+
+	user, err := oidcUser(claims)
+	if errors.Is(err, errNoOIDCUser) || errors.Is(err, errUnverifiedOIDCEmail) || errors.Is(err, errUnverifiedAccount) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Println("oidc user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error linking account",
+		})
+		return
+	}
+
+	if device == "" {
+		device = oidcDeviceFallback
+	}
+
+	// the provider only stands in for the password, the second factor still has to be given to OIDCSecondFactor
+	if user.TOTPEnabled {
+		expiresAt := strconv.FormatInt(time.Now().Add(oidcSecondFactorLifetime).Unix(), 10)
+		// the device goes last since it's the only part that could contain a |
+		payload := strings.Join([]string{oidcSecondFactorPurpose, user.ID, expiresAt, device}, "|")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"msg":          "two-factor code required",
+			"totpRequired": true,
+			"loginToken":   util.SignToken(payload, key),
+		})
+		return
+	}
+
+	sesh, tokens, err := createSession(c, user, device, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error creating session",
+		})
+		return
+	}
+
+	sessionResponse(c, sesh, tokens)
+}
+
+type OIDCSecondFactorRequest struct {
+	LoginToken string `json:"loginToken" binding:"required"`
+	// TOTPCode or RecoveryCode is required
+	TOTPCode     string `json:"totpCode"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// OIDCSecondFactor finishes an OpenID Connect login for a user with two-factor enabled, using the loginToken
+// OIDCCallback gave them along with a TOTP or recovery code
+func OIDCSecondFactor(c *gin.Context) {
+	var req OIDCSecondFactorRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	key, err := appKey()
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "oidc login isn't configured",
+		})
+		return
+	}
+
+	payload, ok := util.VerifySignedToken(req.LoginToken, key)
+	parts := strings.SplitN(payload, "|", 4)
+	if !ok || len(parts) != 4 || parts[0] != oidcSecondFactorPurpose {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "login expired, try again",
+		})
+		return
+	}
+
+	userID, device := parts[1], parts[3]
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "login expired, try again",
+		})
+		return
+	}
+
+	user, err := repository.GRepos.Users.FindByID(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "login expired, try again",
+		})
+		return
+	}
+
+	// codes can be guessed here just like at UserGetToken so they're throttled the same way
+	accountKey := accountThrottleKey(user.Email)
+	ipKey := ipThrottleKey(c.ClientIP())
+	if wait := loginLockedFor(accountKey, ipKey); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"msg": "too many failed attempts, try again later",
+		})
+		return
+	}
+
+	if user.TOTPEnabled && !checkSecondFactor(c, user, req.TOTPCode, req.RecoveryCode, accountKey, ipKey) {
+		return
+	}
+
+	clearLoginFailures(accountKey)
+
+	sesh, tokens, err := createSession(c, user, device, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error creating session",
+		})
+		return
+	}
+
+	sessionResponse(c, sesh, tokens)
+}
+
+// oidcUser finds or creates the user for a verified ID token
+func oidcUser(claims *oidc.Claims) (models.User, error) {
+	var user models.User
+
+	// already linked
+	identity, err := repository.GRepos.Identities.Find(claims.Issuer, claims.Subject)
+	if err == nil {
+		user, err := repository.GRepos.Users.FindByID(identity.UserID)
+		if err != nil {
+			return user, errNoOIDCUser
+		}
+		return user, nil
+	}
+
+	// only trust the email to link or create an account if the provider has verified it
+	if claims.Email == "" || !claims.EmailVerified {
+		return user, errUnverifiedOIDCEmail
+	}
+
+	now := time.Now()
+	user, err = repository.GRepos.Users.FindByEmail(claims.Email)
+	isNew := err != nil
+	if !isNew && user.EmailVerifiedAt == nil {
+		// anyone can sign up with an address they don't own, linking to that account would hand it to whoever made it
+		return user, errUnverifiedAccount
+	}
+	if isNew {
+		name := claims.Name
+		if name == "" {
+			name, _, _ = strings.Cut(claims.Email, "@")
+		}
+
+		// nobody knows this password so the account can only log in through the provider until it's reset
+		user = models.User{
+			GivenFields: models.GivenFields{
+				ID: uuid.New().String(),
+			},
+			Email:           claims.Email,
+			Name:            truncate(name, 255),
+			Password:        util.HashPassword(oidc.RandomString(32)),
+			EmailVerifiedAt: &now,
+		}
+	}
+
+	identity = models.UserIdentity{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		UserID:  user.ID,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+	}
+
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		if isNew {
+			if err := tx.Users.Create(&user); err != nil {
+				return err
+			}
+		}
+
+		return tx.Identities.Create(&identity)
+	})
+
+	return user, err
+}
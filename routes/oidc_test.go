@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/oidc"
+	"github.com/jessehorne/superchat-core/oidc/oidctest"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testOIDC points OIDC login at a new MockProvider for the rest of the test
+func testOIDC(t *testing.T) *oidctest.MockProvider {
+	t.Setenv("APP_KEY", "test key")
+
+	m := oidctest.NewMockProvider(t)
+	oidc.GProvider = &oidc.Provider{
+		Issuer:      m.Server.URL,
+		ClientID:    oidctest.ClientID,
+		RedirectURL: "http://localhost/api/auth/oidc/callback",
+	}
+	t.Cleanup(func() { oidc.GProvider = nil })
+
+	return m
+}
+
+// testOIDCLogin goes through a whole OIDC login with m vouching for email and returns the callback's response
+func testOIDCLogin(t *testing.T, m *oidctest.MockProvider, email string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil)
+	OIDCLogin(c)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Starting an OIDC login should redirect to the provider, got %d: %s", w.Code, w.Body)
+	}
+
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := authURL.Query()
+
+	// pretend the user logged in and the provider redirected back with a code
+	m.Code = "the-code"
+	m.Challenge = q.Get("code_challenge")
+	m.Claims = m.ValidClaims(q.Get("nonce"))
+	m.Claims["email"] = email
+
+	callback := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(callback)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+url.Values{
+		"state": {q.Get("state")},
+		"code":  {m.Code},
+	}.Encode(), nil)
+	for _, cookie := range w.Result().Cookies() {
+		c.Request.AddCookie(cookie)
+	}
+	OIDCCallback(c)
+
+	return callback
+}
+
+func oidcUserID(w *httptest.ResponseRecorder) string {
+	var res struct {
+		UserID string `json:"userID"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return res.UserID
+}
+
+func Test_OIDC_Login(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	m := testOIDC(t)
+
+	w := testOIDCLogin(t, m, "someone@example.com")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Logging in with OIDC should work, got %d: %s", w.Code, w.Body)
+	}
+
+	user, err := repository.GRepos.Users.FindByEmail("someone@example.com")
+	if err != nil || user.ID != oidcUserID(w) || user.EmailVerifiedAt == nil {
+		t.Fatalf("Logging in with OIDC should make a verified account, got %+v: %v", user, err)
+	}
+
+	if w := testOIDCLogin(t, m, "someone@example.com"); w.Code != http.StatusOK || oidcUserID(w) != user.ID {
+		t.Errorf("Logging in again should use the linked account, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func Test_OIDC_LinksVerifiedAccountsOnly(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	m := testOIDC(t)
+
+	// someone signs up with an address that isn't theirs and hangs on to a session
+	squatter := testUser(t, "squatter")
+	squatter.Email = "victim@example.com"
+	if err := repository.GRepos.Users.Save(&squatter); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := testOIDCLogin(t, m, "victim@example.com"); w.Code != http.StatusForbidden {
+		t.Fatalf("An unverified account shouldn't be linked to an OIDC login, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := repository.GRepos.Identities.Find(m.Server.URL, "user-123"); err == nil {
+		t.Error("Refusing to link an account shouldn't leave an identity behind.")
+	}
+	if user, _ := repository.GRepos.Users.FindByID(squatter.ID); user.EmailVerifiedAt != nil {
+		t.Error("Refusing to link an account shouldn't verify its email.")
+	}
+
+	owner := testUser(t, "owner")
+	now := time.Now()
+	owner.EmailVerifiedAt = &now
+	if err := repository.GRepos.Users.Save(&owner); err != nil {
+		t.Fatal(err)
+	}
+
+	w := testOIDCLogin(t, m, owner.Email)
+	if w.Code != http.StatusOK || oidcUserID(w) != owner.ID {
+		t.Errorf("A verified account should be linked to an OIDC login for its email, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := repository.GRepos.Identities.Find(m.Server.URL, "user-123"); err != nil {
+		t.Error("Linking an account should remember the identity.")
+	}
+}
+
+func Test_OIDC_SecondFactor(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	m := testOIDC(t)
+
+	user := testUser(t, "someone")
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	user.TOTPSecret = util.GenerateTOTPSecret()
+	user.TOTPEnabled = true
+	if err := repository.GRepos.Users.Save(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	w := testOIDCLogin(t, m, user.Email)
+	var pending struct {
+		TOTPRequired bool   `json:"totpRequired"`
+		LoginToken   string `json:"loginToken"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &pending)
+	if w.Code != http.StatusUnauthorized || !pending.TOTPRequired || pending.LoginToken == "" {
+		t.Fatalf("Logging in with OIDC should ask users with two-factor for a code, got %d: %s", w.Code, w.Body)
+	}
+	if sessions, _ := repository.GRepos.Sessions.ListActive(user.ID, time.Now()); len(sessions) != 0 {
+		t.Fatalf("Logging in with OIDC shouldn't make a session before the second factor, got %d.", len(sessions))
+	}
+
+	w = testRequest(OIDCSecondFactor, models.User{}, gin.H{"loginToken": pending.LoginToken, "totpCode": "000000"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("A wrong code shouldn't finish an OIDC login, got %d: %s", w.Code, w.Body)
+	}
+
+	w = testRequest(OIDCSecondFactor, models.User{}, gin.H{"loginToken": "forged", "totpCode": "000000"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("A bad loginToken shouldn't finish an OIDC login, got %d: %s", w.Code, w.Body)
+	}
+
+	code, err := util.TOTPCode(user.TOTPSecret, util.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = testRequest(OIDCSecondFactor, models.User{}, gin.H{"loginToken": pending.LoginToken, "totpCode": code})
+	if w.Code != http.StatusOK || oidcUserID(w) != user.ID {
+		t.Errorf("The right code should finish an OIDC login, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// permissionOwner is only held by a room's owners. It can't be given to a role so it guards what only they can do.
+const permissionOwner = "owner"
+
+// roomAccess is who is making a request to a room and what they can do there
+type roomAccess struct {
+	Room models.Room
+	User models.User
+	// Mod is nil if the user isn't a mod or no permission was asked for
+	Mod         *models.RoomMod
+	Permissions []string
+}
+
+func (a roomAccess) isOwner() bool {
+	return a.Mod != nil && a.Mod.Role == models.RoomModRoleOwner
+}
+
+func (a roomAccess) can(permission string) bool {
+	return a.isOwner() || (permission != permissionOwner && slices.Contains(a.Permissions, permission))
+}
+
+// covers reports whether the user has every one of permissions. Nobody can hand out or act against permissions they
+// don't have themselves.
+func (a roomAccess) covers(permissions []string) bool {
+	for _, p := range permissions {
+		if !a.can(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// outranks reports whether the user can manage or moderate target, who is a mod in the same room. Only owners can
+// touch other owners.
+func (a roomAccess) outranks(target models.RoomMod) (bool, error) {
+	if target.Role == models.RoomModRoleOwner {
+		return a.isOwner(), nil
+	}
+
+	permissions, err := modPermissions(target)
+	if err != nil {
+		return false, err
+	}
+	return a.covers(permissions), nil
+}
+
+// modPermissions returns what mod can do in their room
+func modPermissions(mod models.RoomMod) ([]string, error) {
+	switch mod.Role {
+	case models.RoomModRoleOwner:
+		return models.Permissions, nil
+	case models.RoomModRoleMod:
+		return models.ModPermissions, nil
+	case models.RoomModRoleCustom:
+		if mod.RoleID == nil {
+			return nil, nil
+		}
+
+		role, err := repository.GRepos.Roles.Find(mod.RoomID, *mod.RoleID)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Fields(role.Permissions), nil
+	}
+
+	return nil, nil
+}
+
+// authorizeRoom is how every room handler finds out who is asking and whether they're allowed to. It makes sure
+// roomID exists and that the API key in use, if any, can reach it. If permission isn't empty the user also has to be a
+// mod with that permission. If anything is missing it responds with an error and returns false.
+func authorizeRoom(c *gin.Context, roomID string, permission string) (roomAccess, bool) {
+	var access roomAccess
+
+	if roomID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing roomID",
+		})
+		return access, false
+	}
+
+	// get room or return error if it doesn't exist
+	room, err := repository.GRepos.Rooms.FindByID(roomID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "room not found",
+		})
+		return access, false
+	}
+
+	// api keys can be limited to certain rooms
+	if !apiKeyAllowsRoom(c, roomID) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "api key can't access this room",
+		})
+		return access, false
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return access, false
+	}
+
+	access.Room = room
+	access.User = u.(models.User)
+
+	if permission == "" {
+		return access, true
+	}
+
+	mod, err := repository.GRepos.Mods.Find(roomID, access.User.ID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "are you even a mod bro",
+		})
+		return access, false
+	}
+
+	access.Mod = &mod
+	access.Permissions, err = modPermissions(mod)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error checking permissions",
+		})
+		return access, false
+	}
+
+	if permission == permissionOwner && !access.isOwner() {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "you're not the owner",
+		})
+		return access, false
+	}
+
+	if !access.can(permission) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "you don't have the " + permission + " permission",
+		})
+		return access, false
+	}
+
+	return access, true
+}
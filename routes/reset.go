@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/mailer"
+	"github.com/jessehorne/superchat-core/password"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const passwordResetLifetime = time.Hour
+
+var errPasswordResetClaimed = errors.New("password reset already used")
+
+type UserRequestPasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email,max=255"`
+}
+
+// UserRequestPasswordReset emails a one-time reset token to the user. It responds the same way, and just as quickly,
+// whether or not the email belongs to anyone so it can't be used to find accounts. Every request counts against the
+// email and the IP it came from so it can't be used to flood someone's inbox either.
+func UserRequestPasswordReset(c *gin.Context) {
+	var req UserRequestPasswordResetRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	emailKey := resetEmailThrottleKey(req.Email)
+	ipKey := resetIPThrottleKey(c.ClientIP())
+	if wait := loginLockedFor(emailKey, ipKey); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"msg": "too many requests, try again later",
+		})
+		return
+	}
+	recordLoginFailure(emailKey, resetEmailThreshold)
+	recordLoginFailure(ipKey, resetIPThreshold)
+
+	user, err := repository.GRepos.Users.FindByEmail(req.Email)
+	if err == nil {
+		// the reset is stored and mailed after responding, waiting on it would give away that the account exists
+		sendInBackground("password reset", func() error {
+			return sendPasswordReset(user)
+		})
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// pendingMail tracks mail being sent by sendInBackground so tests can wait for it
+var pendingMail sync.WaitGroup
+
+// sendInBackground runs send without holding up the response, what is only used to log failures
+func sendInBackground(what string, send func() error) {
+	pendingMail.Add(1)
+	go func() {
+		defer pendingMail.Done()
+
+		if err := send(); err != nil {
+			log.Println("couldn't send "+what+":", err)
+		}
+	}()
+}
+
+// sendPasswordReset replaces any outstanding reset tokens for user with a new one and emails it to them
+func sendPasswordReset(user models.User) error {
+	// generate token to send to user and store hashed token in database
+	token, hash := util.CreateToken()
+
+	reset := models.PasswordReset{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		UserID:    user.ID,
+		Token:     hash,
+		ExpiresAt: time.Now().Add(passwordResetLifetime),
+	}
+
+	err := repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		if err := tx.PasswordResets.DeleteUnused(user.ID); err != nil {
+			return err
+		}
+
+		return tx.PasswordResets.Create(&reset)
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", os.Getenv("APP_URL"), url.QueryEscape(token))
+
+	return mailer.GMailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your superchat password",
+		Body: fmt.Sprintf("Hey %s,\n\nSomeone asked to reset the password for your superchat account. If it was you, "+
+			"use the link below to pick a new one.\n\n%s\n\nThe link expires in 1 hour. If you didn't ask for this you "+
+			"can ignore this email and your password won't change.\n", user.Name, link),
+	})
+}
+
+type UserConfirmPasswordResetRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// UserConfirmPasswordReset sets a new password using a token from UserRequestPasswordReset. Every existing session
+// for the user is revoked since whoever had them might not be the user.
+func UserConfirmPasswordReset(c *gin.Context) {
+	var req UserConfirmPasswordResetRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	if err := password.GPolicy.Check(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	reset, err := repository.GRepos.PasswordResets.FindByToken(util.HashToken(req.Token))
+	if err != nil || !util.ValidateToken(req.Token, reset.Token) || reset.UsedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid token",
+		})
+		return
+	}
+
+	if time.Now().After(reset.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "expired token",
+		})
+		return
+	}
+
+	user, err := repository.GRepos.Users.FindByID(reset.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid token",
+		})
+		return
+	}
+
+	user.Password = util.HashPassword(req.Password)
+	user.PasswordSalt = ""
+
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		// claim the token, only one request can ever do this
+		claimed, err := tx.PasswordResets.Claim(&reset, time.Now())
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return errPasswordResetClaimed
+		}
+
+		if err := tx.Users.Save(&user); err != nil {
+			return err
+		}
+
+		// log out everywhere
+		return tx.Sessions.DeleteAll(user.ID)
+	})
+	if errors.Is(err, errPasswordResetClaimed) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid token",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error resetting password",
+		})
+		return
+	}
+
+	// give the real owner a clean slate on login attempts
+	clearLoginFailures(accountThrottleKey(user.Email))
+
+	c.JSON(http.StatusOK, nil)
+}
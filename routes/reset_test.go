@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/mailer"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var resetTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// testMailer replaces the mailer with an empty MemoryMailer for the rest of the test
+func testMailer(t *testing.T) *mailer.MemoryMailer {
+	m := mailer.NewMemoryMailer()
+
+	before := mailer.GMailer
+	mailer.GMailer = m
+	t.Cleanup(func() { mailer.GMailer = before })
+
+	return m
+}
+
+// testResetToken asks for a password reset for user and returns the token that was mailed to them
+func testResetToken(t *testing.T, m *mailer.MemoryMailer, user models.User) string {
+	if w := testRequest(UserRequestPasswordReset, user, gin.H{"email": user.Email}); w.Code != http.StatusOK {
+		t.Fatalf("Asking for a password reset should work, got %d: %s", w.Code, w.Body)
+	}
+	pendingMail.Wait()
+
+	messages := m.Messages()
+	if len(messages) == 0 || messages[len(messages)-1].To != user.Email {
+		t.Fatalf("A password reset should be mailed to the user, got %+v", messages)
+	}
+
+	match := resetTokenPattern.FindStringSubmatch(messages[len(messages)-1].Body)
+	if match == nil {
+		t.Fatal("The password reset email should have a link with the token.")
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func Test_Reset_All(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	m := testMailer(t)
+
+	user := testUser(t, "user")
+	sesh := models.Session{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := repository.GRepos.Sessions.Create(&sesh); err != nil {
+		t.Fatal(err)
+	}
+
+	token := testResetToken(t, m, user)
+
+	newPassword := "a much better password than before"
+	if w := testRequest(UserConfirmPasswordReset, user, gin.H{"token": token, "password": newPassword}); w.Code != http.StatusOK {
+		t.Fatalf("Resetting a password with the mailed token should work, got %d: %s", w.Code, w.Body)
+	}
+
+	updated, _ := repository.GRepos.Users.FindByID(user.ID)
+	if !util.ComparePassword(newPassword, updated.PasswordSalt, updated.Password) {
+		t.Error("Resetting a password should change it.")
+	}
+	if _, err := repository.GRepos.Sessions.FindByID(sesh.ID); err == nil {
+		t.Error("Resetting a password should log the user out everywhere.")
+	}
+
+	if w := testRequest(UserConfirmPasswordReset, user, gin.H{"token": token, "password": "yet another new password"}); w.Code != http.StatusBadRequest {
+		t.Errorf("A reset token should only work once, got %d", w.Code)
+	}
+}
+
+func Test_Reset_Expired(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	user := testUser(t, "user")
+
+	token, hash := util.CreateToken()
+	reset := models.PasswordReset{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		UserID:    user.ID,
+		Token:     hash,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	if err := repository.GRepos.PasswordResets.Create(&reset); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := testRequest(UserConfirmPasswordReset, user, gin.H{"token": token, "password": "a much better password than before"}); w.Code != http.StatusBadRequest {
+		t.Errorf("An expired reset token should be refused, got %d", w.Code)
+	}
+}
+
+func Test_Reset_UnknownAndThrottled(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	m := testMailer(t)
+
+	nobody := models.User{Email: "nobody@example.com"}
+	for range resetEmailThreshold {
+		if w := testRequest(UserRequestPasswordReset, nobody, gin.H{"email": nobody.Email}); w.Code != http.StatusOK {
+			t.Fatalf("Asking for a reset for an unknown email should look like it worked, got %d", w.Code)
+		}
+	}
+	pendingMail.Wait()
+	if messages := m.Messages(); len(messages) != 0 {
+		t.Errorf("Nothing should be mailed to an unknown email, got %+v", messages)
+	}
+
+	w := testRequest(UserRequestPasswordReset, nobody, gin.H{"email": nobody.Email})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Too many resets for one email should have to wait, got %d", w.Code)
+	}
+}
@@ -0,0 +1,279 @@
+package routes
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type RoomCreateRoleRequest struct {
+	RoomID      string   `json:"roomID" binding:"required"`
+	Name        string   `json:"name" binding:"required,min=1,max=255"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoomCreateRole defines a custom role for a room that its mods can then be given
+func RoomCreateRole(c *gin.Context) {
+	var req RoomCreateRoleRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	permissions, ok := validPermissions(c, req.Permissions)
+	if !ok {
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionManageMods)
+	if !ok {
+		return
+	}
+
+	if !access.covers(permissions) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "you can't give out permissions you don't have",
+		})
+		return
+	}
+
+	role := models.RoomRole{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		RoomID:      req.RoomID,
+		Name:        req.Name,
+		Permissions: strings.Join(permissions, " "),
+	}
+
+	if err := repository.GRepos.Roles.Create(&role); errors.Is(err, repository.ErrDuplicate) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "role already exists",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error saving role",
+		})
+		return
+	}
+
+	events.Publish(events.NewEvent(events.RoleChanged, req.RoomID, gin.H{
+		"role":   roleResponse(role),
+		"action": "added",
+	}))
+
+	c.JSON(http.StatusOK, gin.H{
+		"roleID": role.ID,
+	})
+}
+
+// RoomListRoles lists the custom roles of a room the user is in
+func RoomListRoles(c *gin.Context) {
+	access, ok := authorizeRoom(c, c.Query("roomID"), "")
+	if !ok {
+		return
+	}
+
+	// make sure user is in the room
+	_, err := repository.GRepos.Members.Find(access.Room.ID, access.User.ID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "you're not in this room",
+		})
+		return
+	}
+
+	roles, err := repository.GRepos.Roles.List(access.Room.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error getting roles",
+		})
+		return
+	}
+
+	out := make([]gin.H, 0, len(roles))
+	for _, r := range roles {
+		out = append(out, roleResponse(r))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles":          out,
+		"modPermissions": models.ModPermissions,
+	})
+}
+
+type RoomUpdateRoleRequest struct {
+	RoomID      string    `json:"roomID" binding:"required"`
+	RoleID      string    `json:"roleID" binding:"required"`
+	Name        string    `json:"name" binding:"max=255"`
+	Permissions *[]string `json:"permissions"`
+}
+
+// RoomUpdateRole renames a custom role or changes its permissions, which changes them for every mod who has it
+func RoomUpdateRole(c *gin.Context) {
+	var req RoomUpdateRoleRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	var permissions []string
+	if req.Permissions != nil {
+		var ok bool
+		permissions, ok = validPermissions(c, *req.Permissions)
+		if !ok {
+			return
+		}
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionManageMods)
+	if !ok {
+		return
+	}
+
+	role, ok := manageableRole(c, access, req.RoleID)
+	if !ok {
+		return
+	}
+
+	if req.Name != "" {
+		role.Name = req.Name
+	}
+
+	if req.Permissions != nil {
+		if !access.covers(permissions) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "you can't give out permissions you don't have",
+			})
+			return
+		}
+		role.Permissions = strings.Join(permissions, " ")
+	}
+
+	if err := repository.GRepos.Roles.Save(&role); errors.Is(err, repository.ErrDuplicate) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "role already exists",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error updating role",
+		})
+		return
+	}
+
+	events.Publish(events.NewEvent(events.RoleChanged, req.RoomID, gin.H{
+		"role":   roleResponse(role),
+		"action": "updated",
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type RoomDeleteRoleRequest struct {
+	RoomID string `json:"roomID" binding:"required"`
+	RoleID string `json:"roleID" binding:"required"`
+}
+
+// RoomDeleteRole deletes a custom role once no mods have it
+func RoomDeleteRole(c *gin.Context) {
+	var req RoomDeleteRoleRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionManageMods)
+	if !ok {
+		return
+	}
+
+	role, ok := manageableRole(c, access, req.RoleID)
+	if !ok {
+		return
+	}
+
+	inUse, err := repository.GRepos.Mods.CountWithRole(role.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error deleting role",
+		})
+		return
+	}
+	if inUse > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "role is still given to mods",
+		})
+		return
+	}
+
+	if err := repository.GRepos.Roles.Delete(&role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error deleting role",
+		})
+		return
+	}
+
+	events.Publish(events.NewEvent(events.RoleChanged, req.RoomID, gin.H{
+		"role":   roleResponse(role),
+		"action": "removed",
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// validPermissions checks every one of permissions exists and returns them sorted without duplicates
+func validPermissions(c *gin.Context, permissions []string) ([]string, bool) {
+	for _, p := range permissions {
+		if !slices.Contains(models.Permissions, p) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":       "invalid permission " + p,
+				"permissions": models.Permissions,
+			})
+			return nil, false
+		}
+	}
+
+	permissions = slices.Clone(permissions)
+	slices.Sort(permissions)
+	return slices.Compact(permissions), true
+}
+
+// manageableRole finds roleID in the room and makes sure the user has every permission it gives
+func manageableRole(c *gin.Context, access roomAccess, roleID string) (models.RoomRole, bool) {
+	role, err := repository.GRepos.Roles.Find(access.Room.ID, roleID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "role not found",
+		})
+		return role, false
+	}
+
+	if !access.covers(strings.Fields(role.Permissions)) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "that role has permissions you don't",
+		})
+		return role, false
+	}
+
+	return role, true
+}
+
+func roleResponse(r models.RoomRole) gin.H {
+	return gin.H{
+		"roleID":      r.ID,
+		"name":        r.Name,
+		"permissions": strings.Fields(r.Permissions),
+	}
+}
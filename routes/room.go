@@ -1,18 +1,33 @@
 package routes
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/jessehorne/superchat-core/database"
 	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/password"
+	"github.com/jessehorne/superchat-core/repository"
 	"github.com/jessehorne/superchat-core/util"
 	"log"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRoomListLimit = 50
+	maxRoomListLimit     = 100
+	defaultAuditLimit    = 50
+	maxAuditLimit        = 100
 )
 
 type RoomCreateRequest struct {
 	Name     string `json:"name" binding:"required,min=1"`
 	Password string `json:"password"`
+	Private  bool   `json:"private"`
 }
 
 func RoomCreate(c *gin.Context) {
@@ -23,6 +38,15 @@ func RoomCreate(c *gin.Context) {
 		return
 	}
 
+	if req.Password != "" {
+		if err := password.GPolicy.Check(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
 	// get user from request
 	u, exists := c.Get("user")
 	if !exists {
@@ -34,25 +58,23 @@ func RoomCreate(c *gin.Context) {
 
 	user := u.(models.User)
 
+	// a key limited to certain rooms can't make new ones
+	if apiKeyRestricted(c) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "api key can't access this room",
+		})
+		return
+	}
+
 	// create room
 	var newRoom models.Room
 	newRoom.ID = uuid.New().String()
 	newRoom.Name = req.Name
+	newRoom.Private = req.Private
 
 	if req.Password != "" {
-		salt, hash := util.ProcessPassword(req.Password)
 		newRoom.PasswordProtected = true
-		newRoom.Password = hash
-		newRoom.PasswordSalt = salt
-	}
-
-	roomResult := database.GDB.Create(&newRoom)
-	if roomResult.RowsAffected != 1 {
-		log.Println(roomResult.Error.Error())
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "db error",
-		})
-		return
+		newRoom.Password = util.HashPassword(req.Password)
 	}
 
 	// add user as mod (owner)
@@ -64,13 +86,6 @@ func RoomCreate(c *gin.Context) {
 		RoomID: newRoom.ID,
 		Role:   models.RoomModRoleOwner,
 	}
-	newModResult := database.GDB.Create(&newMod)
-	if newModResult.RowsAffected == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "error creating owner record",
-		})
-		return
-	}
 
 	// add user to room
 	newRoomUser := models.RoomUser{
@@ -81,14 +96,29 @@ func RoomCreate(c *gin.Context) {
 		UserID: user.ID,
 		Muted:  false,
 	}
-	newRoomUserResult := database.GDB.Create(&newRoomUser)
-	if newRoomUserResult.RowsAffected == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "error creating room user record",
+
+	// a room without its owner could never be managed so it's all or nothing
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		if err := tx.Rooms.Create(&newRoom); err != nil {
+			return err
+		}
+		if err := tx.Mods.Create(&newMod); err != nil {
+			return err
+		}
+		return tx.Members.Create(&newRoomUser)
+	})
+	if err != nil {
+		log.Println("couldn't create room:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error creating room",
 		})
 		return
 	}
 
+	events.Publish(events.NewEvent(events.MemberJoined, newRoom.ID, gin.H{
+		"userID": user.ID,
+	}))
+
 	c.JSON(http.StatusOK, gin.H{
 		"roomID": newRoom.ID,
 	})
@@ -98,6 +128,7 @@ type RoomUpdateRequest struct {
 	RoomID   string `json:"roomID" binding:"required"`
 	Name     string `json:"name"`
 	Password string `json:"password"`
+	Private  *bool  `json:"private"`
 }
 
 func RoomUpdate(c *gin.Context) {
@@ -108,50 +139,20 @@ func RoomUpdate(c *gin.Context) {
 		return
 	}
 
-	if req.RoomID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "missing roomID",
-		})
-		return
-	}
-
-	// get room or return error if it doesn't exist
-	var room models.Room
-	roomResult := database.GDB.First(&room, "id = ?", req.RoomID)
-	if roomResult.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "room not found",
-		})
-		return
-	}
-
-	// get user from request
-	u, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "no auth user",
-		})
-		return
-	}
-
-	user := u.(models.User)
-
-	// make sure user is an owner
-	var roomMod models.RoomMod
-	roomModResult := database.GDB.Where("room_id = ?", req.RoomID).First(&roomMod, "user_id = ?", user.ID)
-	if roomModResult.RowsAffected == 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "are you even a mod bro",
-		})
-		return
+	if req.Password != "" {
+		if err := password.GPolicy.Check(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
 	}
 
-	if roomMod.Role != models.RoomModRoleOwner {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "you're not the owner",
-		})
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionManageRoom)
+	if !ok {
 		return
 	}
+	room := access.Room
 
 	// update room name if the field was found
 	if req.Name != "" {
@@ -160,20 +161,29 @@ func RoomUpdate(c *gin.Context) {
 
 	// update room password if password field was given
 	if req.Password != "" {
-		salt, hash := util.ProcessPassword(req.Password)
 		room.PasswordProtected = true
-		room.Password = hash
-		room.PasswordSalt = salt
+		room.Password = util.HashPassword(req.Password)
+		room.PasswordSalt = ""
+	}
+
+	// update room visibility if the field was given
+	if req.Private != nil {
+		room.Private = *req.Private
 	}
 
-	updateResult := database.GDB.Save(&room)
-	if updateResult.RowsAffected == 0 {
+	if err := repository.GRepos.Rooms.Save(&room); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "something went wrong with the db",
 		})
 		return
 	}
 
+	events.Publish(events.NewEvent(events.RoomUpdated, room.ID, gin.H{
+		"name":              room.Name,
+		"passwordProtected": room.PasswordProtected,
+		"private":           room.Private,
+	}))
+
 	c.JSON(http.StatusOK, nil)
 }
 
@@ -189,54 +199,14 @@ func RoomDelete(c *gin.Context) {
 		return
 	}
 
-	if req.RoomID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "missing roomID",
-		})
-		return
-	}
-
-	// get room or return error if it doesn't exist
-	var room models.Room
-	roomResult := database.GDB.First(&room, "id = ?", req.RoomID)
-	if roomResult.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "room not found",
-		})
-		return
-	}
-
-	// get user from request
-	u, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "no auth user",
-		})
-		return
-	}
-
-	user := u.(models.User)
-
-	// make sure user is an owner
-	var roomMod models.RoomMod
-	roomModResult := database.GDB.Where("room_id = ?", req.RoomID).First(&roomMod, "user_id = ?", user.ID)
-	if roomModResult.RowsAffected == 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "are you even a mod bro",
-		})
-		return
-	}
-
-	if roomMod.Role != models.RoomModRoleOwner {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "you're not the owner",
-		})
+	access, ok := authorizeRoom(c, req.RoomID, permissionOwner)
+	if !ok {
 		return
 	}
+	room := access.Room
 
 	// delete room
-	deleteResult := database.GDB.Delete(&room)
-	if deleteResult.RowsAffected == 0 {
+	if err := repository.GRepos.Rooms.Delete(&room); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "something went wrong deleting the room",
 		})
@@ -250,6 +220,8 @@ type RoomAddModRequest struct {
 	RoomID string `json:"roomID"`
 	UserID string `json:"userID"`
 	Role   int    `json:"role,default=-1"`
+	// RoleID is the custom role to give when Role is RoomModRoleCustom
+	RoleID string `json:"roleID"`
 }
 
 func RoomAddMod(c *gin.Context) {
@@ -267,54 +239,24 @@ func RoomAddMod(c *gin.Context) {
 		return
 	}
 
-	if req.Role != 0 && req.Role != 1 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid role",
-		})
+	newRoomMod, ok := requestedMod(c, req.RoomID, req.UserID, req.Role, req.RoleID)
+	if !ok {
 		return
 	}
+	newRoomMod.ID = uuid.New().String()
 
-	// get room or return error if it doesn't exist
-	var room models.Room
-	roomResult := database.GDB.First(&room, "id = ?", req.RoomID)
-	if roomResult.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "room not found",
-		})
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionManageMods)
+	if !ok {
 		return
 	}
 
-	// get user from request
-	u, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "no auth user",
-		})
-		return
-	}
-
-	user := u.(models.User)
-
-	// make sure user is an owner
-	var roomMod models.RoomMod
-	roomModResult := database.GDB.Where("room_id = ?", req.RoomID).First(&roomMod, "user_id = ?", user.ID)
-	if roomModResult.RowsAffected == 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "are you even a mod bro",
-		})
-		return
-	}
-	if roomMod.Role != models.RoomModRoleOwner {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "you're not the owner",
-		})
+	if !canGrant(c, access, newRoomMod) {
 		return
 	}
 
 	// make sure a mod doesn't already exist
-	var existingRoomMod models.RoomMod
-	existingRoomModResult := database.GDB.Where("room_id = ?", req.RoomID).First(&existingRoomMod, "user_id = ?", req.UserID)
-	if existingRoomModResult.RowsAffected > 0 {
+	_, err = repository.GRepos.Mods.Find(req.RoomID, req.UserID)
+	if err == nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "mod already exists",
 		})
@@ -322,32 +264,33 @@ func RoomAddMod(c *gin.Context) {
 	}
 
 	// get target user
-	var targetUser models.User
-	targetUserResult := database.GDB.First(&targetUser, "id = ?", req.UserID)
-	if targetUserResult.RowsAffected == 0 {
+	_, err = repository.GRepos.Users.FindByID(req.UserID)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "no target user",
 		})
 		return
 	}
 
-	newRoomMod := models.RoomMod{
-		GivenFields: models.GivenFields{
-			ID: uuid.New().String(),
-		},
-		UserID: req.UserID,
-		RoomID: req.RoomID,
-		Role:   req.Role,
-	}
-
-	newRoomModResult := database.GDB.Create(&newRoomMod)
-	if newRoomModResult.RowsAffected == 0 {
+	if err := repository.GRepos.Mods.Create(&newRoomMod); errors.Is(err, repository.ErrDuplicate) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "mod already exists",
+		})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "error saving room mod",
 		})
 		return
 	}
 
+	events.Publish(events.NewEvent(events.ModChanged, req.RoomID, gin.H{
+		"userID": req.UserID,
+		"role":   newRoomMod.Role,
+		"roleID": newRoomMod.RoleID,
+		"action": "added",
+	}))
+
 	c.JSON(http.StatusOK, nil)
 }
 
@@ -355,6 +298,8 @@ type RoomUpdateModRequest struct {
 	RoomID string `json:"roomID"`
 	UserID string `json:"userID"`
 	Role   int    `json:"role,default=-1"`
+	// RoleID is the custom role to give when Role is RoomModRoleCustom
+	RoleID string `json:"roleID"`
 }
 
 func RoomUpdateMod(c *gin.Context) {
@@ -372,169 +317,590 @@ func RoomUpdateMod(c *gin.Context) {
 		return
 	}
 
-	if req.Role != 0 && req.Role != 1 {
+	updated, ok := requestedMod(c, req.RoomID, req.UserID, req.Role, req.RoleID)
+	if !ok {
+		return
+	}
+
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionManageMods)
+	if !ok {
+		return
+	}
+
+	// make sure the room mod exists
+	existingRoomMod, err := repository.GRepos.Mods.Find(req.RoomID, req.UserID)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid role",
+			"error": "mod doesn't exist",
 		})
 		return
 	}
 
-	// get room or return error if it doesn't exist
-	var room models.Room
-	roomResult := database.GDB.First(&room, "id = ?", req.RoomID)
-	if roomResult.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "room not found",
-		})
+	if !canManageMod(c, access, existingRoomMod) || !canGrant(c, access, updated) {
 		return
 	}
 
-	// get user from request
-	u, exists := c.Get("user")
-	if !exists {
+	// get target user
+	_, err = repository.GRepos.Users.FindByID(req.UserID)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "no auth user",
+			"error": "no target user",
 		})
 		return
 	}
 
-	user := u.(models.User)
+	// update room mod
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		if err := lockMod(tx, existingRoomMod); err != nil {
+			return err
+		}
+
+		if existingRoomMod.Role == models.RoomModRoleOwner && updated.Role != models.RoomModRoleOwner {
+			if err := keepsAnOwner(tx, req.RoomID); err != nil {
+				return err
+			}
+		}
+
+		existingRoomMod.Role = updated.Role
+		existingRoomMod.RoleID = updated.RoleID
+		return tx.Mods.Save(&existingRoomMod)
+	})
+	if !ownerChangeOK(c, err, "error updating room mod") {
+		return
+	}
 
-	// make sure user is an owner
-	var roomMod models.RoomMod
-	roomModResult := database.GDB.Where("room_id = ?", req.RoomID).First(&roomMod, "user_id = ?", user.ID)
-	if roomModResult.RowsAffected == 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "are you even a mod bro",
-		})
+	events.Publish(events.NewEvent(events.ModChanged, req.RoomID, gin.H{
+		"userID": req.UserID,
+		"role":   existingRoomMod.Role,
+		"roleID": existingRoomMod.RoleID,
+		"action": "updated",
+	}))
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type RoomDeleteModRequest struct {
+	RoomID string `json:"roomID"`
+	UserID string `json:"userID"`
+}
+
+func RoomDeleteMod(c *gin.Context) {
+	var req RoomDeleteModRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
 		return
 	}
-	if roomMod.Role != models.RoomModRoleOwner {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "you're not the owner",
+
+	if req.RoomID == "" || req.UserID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing details",
 		})
 		return
 	}
 
+	access, ok := authorizeRoom(c, req.RoomID, models.PermissionManageMods)
+	if !ok {
+		return
+	}
+
 	// make sure the room mod exists
-	var existingRoomMod models.RoomMod
-	existingRoomModResult := database.GDB.Where("room_id = ?", req.RoomID).First(&existingRoomMod, "user_id = ?", req.UserID)
-	if existingRoomModResult.RowsAffected == 0 {
+	existingRoomMod, err := repository.GRepos.Mods.Find(req.RoomID, req.UserID)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "mod doesn't exist",
 		})
 		return
 	}
 
+	if !canManageMod(c, access, existingRoomMod) {
+		return
+	}
+
 	// get target user
-	var targetUser models.User
-	targetUserResult := database.GDB.First(&targetUser, "id = ?", req.UserID)
-	if targetUserResult.RowsAffected == 0 {
+	_, err = repository.GRepos.Users.FindByID(req.UserID)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "no target user",
 		})
 		return
 	}
 
-	// update room mod
-	existingRoomMod.Role = req.Role
-
-	saveResult := database.GDB.Save(&existingRoomMod)
-	if saveResult.RowsAffected == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "error updating room mod",
-		})
+	// delete room mod
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		if err := lockMod(tx, existingRoomMod); err != nil {
+			return err
+		}
+
+		if existingRoomMod.Role == models.RoomModRoleOwner {
+			if err := keepsAnOwner(tx, req.RoomID); err != nil {
+				return err
+			}
+		}
+
+		return tx.Mods.Delete(&existingRoomMod)
+	})
+	if !ownerChangeOK(c, err, "error deleting room mod") {
 		return
 	}
 
+	events.Publish(events.NewEvent(events.ModChanged, req.RoomID, gin.H{
+		"userID": req.UserID,
+		"action": "removed",
+	}))
+
 	c.JSON(http.StatusOK, nil)
 }
 
-type RoomDeleteModRequest struct {
-	RoomID string `json:"roomID"`
-	UserID string `json:"userID"`
+type RoomTransferOwnershipRequest struct {
+	RoomID string `json:"roomID" binding:"required"`
+	UserID string `json:"userID" binding:"required"`
+	// Password confirms the transfer when ROOM_TRANSFER_REQUIRE_PASSWORD is on. Users with two-factor can give a
+	// TOTPCode or RecoveryCode instead. Accounts made through OIDC never had a password, so they need to set one with
+	// a password reset or turn on two-factor first.
+	Password     string `json:"password"`
+	TOTPCode     string `json:"totpCode"`
+	RecoveryCode string `json:"recoveryCode"`
 }
 
-func RoomDeleteMod(c *gin.Context) {
-	var req RoomDeleteModRequest
+var (
+	errNoLongerOwner = errors.New("you don't own this room")
+	errNotInRoom     = errors.New("not in room")
+	errAlreadyOwner  = errors.New("already an owner")
+)
+
+// RoomTransferOwnership hands the user's ownership of a room to another member. The two trade places: the old owner
+// takes whatever mod role the new one had, or becomes a plain mod if they had none. The transfer goes in the room's
+// audit log. It can't be done with an API key, it needs the owner themselves.
+func RoomTransferOwnership(c *gin.Context) {
+	if _, usingKey := c.Get("apiKey"); usingKey {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "ownership can't be transferred with an api key",
+		})
+		return
+	}
+
+	var req RoomTransferOwnershipRequest
 	err, res := util.TryBind(&req, c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, res)
 		return
 	}
 
-	if req.RoomID == "" || req.UserID == "" {
+	access, ok := authorizeRoom(c, req.RoomID, permissionOwner)
+	if !ok {
+		return
+	}
+
+	if req.UserID == access.User.ID {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "missing details",
+			"error": "you already own this room",
 		})
 		return
 	}
 
-	// get room or return error if it doesn't exist
-	var room models.Room
-	roomResult := database.GDB.First(&room, "id = ?", req.RoomID)
-	if roomResult.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "room not found",
-		})
+	if transferRequiresPassword() && !confirmTransfer(c, access.User, req) {
 		return
 	}
 
-	// get user from request
-	u, exists := c.Get("user")
-	if !exists {
+	entry := models.RoomAuditEntry{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		RoomID:   req.RoomID,
+		ActorID:  access.User.ID,
+		Action:   models.AuditOwnershipTransferred,
+		TargetID: req.UserID,
+	}
+
+	// everything is checked again once the room is locked so a transfer or demotion running at the same time can't
+	// leave the room with no owner or two of them
+	var oldOwner, newOwner models.RoomMod
+	var wasMod bool
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		err := tx.Rooms.Lock(req.RoomID)
+		if err != nil {
+			return err
+		}
+
+		oldOwner, err = tx.Mods.Find(req.RoomID, access.User.ID)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && oldOwner.Role != models.RoomModRoleOwner) {
+			return errNoLongerOwner
+		}
+		if err != nil {
+			return err
+		}
+
+		// the new owner has to be in the room already
+		if _, err := tx.Members.Find(req.RoomID, req.UserID); errors.Is(err, repository.ErrNotFound) {
+			return errNotInRoom
+		} else if err != nil {
+			return err
+		}
+
+		newOwner, err = tx.Mods.Find(req.RoomID, req.UserID)
+		wasMod = err == nil
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		if wasMod && newOwner.Role == models.RoomModRoleOwner {
+			return errAlreadyOwner
+		}
+
+		if wasMod {
+			oldOwner.Role = newOwner.Role
+			oldOwner.RoleID = newOwner.RoleID
+		} else {
+			oldOwner.Role = models.RoomModRoleMod
+			oldOwner.RoleID = nil
+			newOwner = models.RoomMod{
+				GivenFields: models.GivenFields{
+					ID: uuid.New().String(),
+				},
+				UserID: req.UserID,
+				RoomID: req.RoomID,
+			}
+		}
+		newOwner.Role = models.RoomModRoleOwner
+		newOwner.RoleID = nil
+
+		if err := tx.Mods.Save(&oldOwner); err != nil {
+			return err
+		}
+
+		if wasMod {
+			if err := tx.Mods.Save(&newOwner); err != nil {
+				return err
+			}
+		} else if err := tx.Mods.Create(&newOwner); err != nil {
+			return err
+		}
+
+		return tx.Audit.Create(&entry)
+	})
+	if errors.Is(err, errNoLongerOwner) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, errNotInRoom) || errors.Is(err, errAlreadyOwner) {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "no auth user",
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Println("couldn't transfer ownership:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error transferring ownership",
 		})
 		return
 	}
 
-	user := u.(models.User)
+	newOwnerAction := "added"
+	if wasMod {
+		newOwnerAction = "updated"
+	}
+
+	events.Publish(events.NewEvent(events.ModChanged, req.RoomID, gin.H{
+		"userID": newOwner.UserID,
+		"role":   newOwner.Role,
+		"roleID": newOwner.RoleID,
+		"action": newOwnerAction,
+	}))
+
+	events.Publish(events.NewEvent(events.ModChanged, req.RoomID, gin.H{
+		"userID": oldOwner.UserID,
+		"role":   oldOwner.Role,
+		"roleID": oldOwner.RoleID,
+		"action": "updated",
+	}))
 
-	// make sure user is an owner
-	var roomMod models.RoomMod
-	roomModResult := database.GDB.Where("room_id = ?", req.RoomID).First(&roomMod, "user_id = ?", user.ID)
-	if roomModResult.RowsAffected == 0 {
+	c.JSON(http.StatusOK, nil)
+}
+
+// confirmTransfer makes an owner prove it's really them before they give a room away, with their password or a fresh
+// two-factor code. Failures count against the account like failed logins. If it responds it returns false.
+func confirmTransfer(c *gin.Context, user models.User, req RoomTransferOwnershipRequest) bool {
+	accountKey := accountThrottleKey(user.Email)
+	if wait := loginLockedFor(accountKey); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "too many failed attempts, try again later",
+		})
+		return false
+	}
+
+	var confirmed bool
+	switch {
+	case req.Password != "":
+		confirmed = util.ComparePassword(req.Password, user.PasswordSalt, user.Password)
+	case user.TOTPEnabled && (req.TOTPCode != "" || req.RecoveryCode != ""):
+		confirmed = verifySecondFactor(user, req.TOTPCode, req.RecoveryCode)
+	default:
+		msg := "password required"
+		if user.TOTPEnabled {
+			msg = "password or two-factor code required"
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "are you even a mod bro",
+			"error": msg,
 		})
-		return
+		return false
 	}
-	if roomMod.Role != models.RoomModRoleOwner {
+
+	if !confirmed {
+		recordLoginFailure(accountKey, accountFailureThreshold)
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "you're not the owner",
+			"error": "invalid password or code",
+		})
+		return false
+	}
+
+	return true
+}
+
+// transferRequiresPassword is whether owners have to confirm their password to give a room away
+func transferRequiresPassword() bool {
+	return os.Getenv("ROOM_TRANSFER_REQUIRE_PASSWORD") == "true"
+}
+
+// RoomGetAuditLog lists a room's audit log newest first, pass ?limit= for more or fewer entries. Only owners can see
+// it.
+func RoomGetAuditLog(c *gin.Context) {
+	limit := defaultAuditLimit
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid limit",
+			})
+			return
+		}
+		limit = min(parsed, maxAuditLimit)
+	}
+
+	access, ok := authorizeRoom(c, c.Query("roomID"), permissionOwner)
+	if !ok {
+		return
+	}
+
+	entries, err := repository.GRepos.Audit.List(access.Room.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error getting audit log",
 		})
 		return
 	}
 
-	// make sure the room mod exists
-	var existingRoomMod models.RoomMod
-	existingRoomModResult := database.GDB.Where("room_id = ?", req.RoomID).First(&existingRoomMod, "user_id = ?", req.UserID)
-	if existingRoomModResult.RowsAffected == 0 {
+	out := make([]gin.H, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, gin.H{
+			"id":        e.ID,
+			"actorID":   e.ActorID,
+			"action":    e.Action,
+			"targetID":  e.TargetID,
+			"createdAt": e.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": out,
+	})
+}
+
+// requestedMod validates the role asked for in a request to add or update a mod and returns the record it describes
+func requestedMod(c *gin.Context, roomID string, userID string, role int, roleID string) (models.RoomMod, bool) {
+	mod := models.RoomMod{
+		UserID: userID,
+		RoomID: roomID,
+		Role:   role,
+	}
+
+	switch role {
+	case models.RoomModRoleOwner, models.RoomModRoleMod:
+	case models.RoomModRoleCustom:
+		if roleID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "missing roleID",
+			})
+			return mod, false
+		}
+		mod.RoleID = &roleID
+	default:
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "mod doesn't exist",
+			"error": "invalid role",
 		})
-		return
+		return mod, false
 	}
 
-	// get target user
-	var targetUser models.User
-	targetUserResult := database.GDB.First(&targetUser, "id = ?", req.UserID)
-	if targetUserResult.RowsAffected == 0 {
+	return mod, true
+}
+
+// canGrant makes sure the user can make someone mod, so nobody can hand out more than they have
+func canGrant(c *gin.Context, access roomAccess, mod models.RoomMod) bool {
+	ok, err := access.outranks(mod)
+	if errors.Is(err, repository.ErrNotFound) {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "no target user",
+			"error": "role not found",
 		})
-		return
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error checking permissions",
+		})
+		return false
 	}
 
-	// delete room mod
-	deleteResult := database.GDB.Delete(&existingRoomMod)
-	if deleteResult.RowsAffected == 0 {
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "you can't give out permissions you don't have",
+		})
+		return false
+	}
+
+	return true
+}
+
+// canManageMod makes sure the user can change or act against mod, who is already a mod in the room
+func canManageMod(c *gin.Context, access roomAccess, mod models.RoomMod) bool {
+	ok, err := access.outranks(mod)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error checking permissions",
+		})
+		return false
+	}
+
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "that mod has permissions you don't",
+		})
+		return false
+	}
+
+	return true
+}
+
+var (
+	errLastOwner  = errors.New("a room needs an owner, transfer ownership first")
+	errModChanged = errors.New("that mod was changed by someone else, try again")
+)
+
+// lockMod locks mod's room for the rest of tx and makes sure mod is still what permissions were checked against, in
+// case someone else changed it in the meantime
+func lockMod(tx *repository.Repositories, mod models.RoomMod) error {
+	if err := tx.Rooms.Lock(mod.RoomID); err != nil {
+		return err
+	}
+
+	current, err := tx.Mods.Find(mod.RoomID, mod.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return errModChanged
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.Role != mod.Role || (current.RoleID == nil) != (mod.RoleID == nil) ||
+		(current.RoleID != nil && *current.RoleID != *mod.RoleID) {
+		return errModChanged
+	}
+	return nil
+}
+
+// keepsAnOwner is run in a transaction that has locked roomID before one of its owners stops being one. It returns
+// errLastOwner unless there's another owner left to take over.
+func keepsAnOwner(tx *repository.Repositories, roomID string) error {
+	ownerCount, err := tx.Mods.CountRole(roomID, models.RoomModRoleOwner)
+	if err != nil {
+		return err
+	}
+	if ownerCount <= 1 {
+		return errLastOwner
+	}
+	return nil
+}
+
+// ownerChangeOK responds to err from a transaction that changed a room's mods. It returns true if there wasn't one.
+func ownerChangeOK(c *gin.Context, err error, msg string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errLastOwner):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, errModChanged):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		log.Println(msg+":", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": msg,
+		})
+	}
+	return false
+}
+
+// RoomList lets users find public rooms. ?search= matches on room name and ?protected=false hides password protected
+// rooms. Private rooms are never listed. Rooms come back with the most members first.
+func RoomList(c *gin.Context) {
+	search := c.Query("search")
+	showProtected := c.DefaultQuery("protected", "true") != "false"
+
+	limit := defaultRoomListLimit
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid limit",
+			})
+			return
+		}
+		limit = min(parsed, maxRoomListLimit)
+	}
+
+	offset := 0
+	if o := c.Query("offset"); o != "" {
+		parsed, err := strconv.Atoi(o)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid offset",
+			})
+			return
+		}
+		offset = parsed
+	}
+
+	rooms, err := repository.GRepos.Rooms.ListPublic(repository.RoomListOptions{
+		Search:        search,
+		HideProtected: !showProtected,
+		RoomIDs:       apiKeyRooms(c),
+		Limit:         limit,
+		Offset:        offset,
+	})
+	if err != nil {
+		log.Println(err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "error deleting room mod",
+			"error": "error listing rooms",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, nil)
+	out := make([]gin.H, 0, len(rooms))
+	for _, r := range rooms {
+		out = append(out, gin.H{
+			"roomID":            r.ID,
+			"name":              r.Name,
+			"passwordProtected": r.PasswordProtected,
+			"memberCount":       r.MemberCount,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rooms": out,
+	})
 }
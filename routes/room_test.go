@@ -0,0 +1,560 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRequest runs handler for a request made by user with body as its JSON
+func testRequest(handler gin.HandlerFunc, user models.User, body any) *httptest.ResponseRecorder {
+	// setting the mode every time would race with requests made at the same time
+	if gin.Mode() != gin.TestMode {
+		gin.SetMode(gin.TestMode)
+	}
+
+	b, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user", user)
+
+	handler(c)
+
+	return w
+}
+
+func testUser(t *testing.T, id string) models.User {
+	user := models.User{
+		GivenFields: models.GivenFields{
+			ID: id,
+		},
+		Email: id + "@example.com",
+		Name:  id,
+	}
+	if err := repository.GRepos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// testSession logs user in and returns the session along with its tokens. browser starts a cookie session with a CSRF
+// token.
+func testSession(t *testing.T, user models.User, browser bool) (models.Session, sessionTokens) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+	sesh, tokens, err := createSession(c, user, "test", browser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sesh, tokens
+}
+
+func Test_Room_CreateJoinLeave(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	guest := testUser(t, "guest")
+
+	w := testRequest(RoomCreate, owner, gin.H{"name": "lobby"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Creating a room should work, got %d: %s", w.Code, w.Body)
+	}
+
+	var created struct {
+		RoomID string `json:"roomID"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	mod, err := repository.GRepos.Mods.Find(created.RoomID, owner.ID)
+	if err != nil || mod.Role != models.RoomModRoleOwner {
+		t.Error("The user who made a room should own it.")
+	}
+
+	if w := testRequest(RoomJoin, guest, gin.H{"roomID": created.RoomID}); w.Code != http.StatusOK {
+		t.Fatalf("Joining a public room should work, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := testRequest(RoomJoin, guest, gin.H{"roomID": created.RoomID}); w.Code != http.StatusBadRequest {
+		t.Errorf("Joining a room twice should be refused, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomLeave, owner, gin.H{"roomID": created.RoomID}); w.Code != http.StatusBadRequest {
+		t.Errorf("The last owner shouldn't be able to leave, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomLeave, guest, gin.H{"roomID": created.RoomID}); w.Code != http.StatusOK {
+		t.Errorf("Leaving a room should work, got %d: %s", w.Code, w.Body)
+	}
+
+	if _, err := repository.GRepos.Members.Find(created.RoomID, guest.ID); err == nil {
+		t.Error("A user who left should no longer be a member.")
+	}
+}
+
+func Test_Room_JoinProtected(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	guest := testUser(t, "guest")
+
+	w := testRequest(RoomCreate, owner, gin.H{"name": "secret", "password": "correct horse battery staple"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Creating a protected room should work, got %d: %s", w.Code, w.Body)
+	}
+
+	var created struct {
+		RoomID string `json:"roomID"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	if w := testRequest(RoomJoin, guest, gin.H{"roomID": created.RoomID}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Joining a protected room without a password should be refused, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomJoin, guest, gin.H{"roomID": created.RoomID, "password": "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Joining a protected room with the wrong password should be refused, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomJoin, guest, gin.H{"roomID": created.RoomID, "password": "correct horse battery staple"}); w.Code != http.StatusOK {
+		t.Errorf("Joining a protected room with its password should work, got %d: %s", w.Code, w.Body)
+	}
+}
+
+var errInjected = errors.New("injected failure")
+
+// these fail every write so a handler can be made to fail at any step
+
+type failingRooms struct{ repository.RoomRepository }
+
+func (failingRooms) Create(*models.Room) error { return errInjected }
+
+type failingMods struct{ repository.ModRepository }
+
+func (failingMods) Create(*models.RoomMod) error { return errInjected }
+func (failingMods) Delete(*models.RoomMod) error { return errInjected }
+
+type failingMembers struct{ repository.MemberRepository }
+
+func (failingMembers) Create(*models.RoomUser) error { return errInjected }
+func (failingMembers) Delete(*models.RoomUser) error { return errInjected }
+
+func Test_Room_CreateRollsBack(t *testing.T) {
+	steps := map[string]func(repos *repository.Repositories){
+		"room":   func(repos *repository.Repositories) { repos.Rooms = failingRooms{repos.Rooms} },
+		"owner":  func(repos *repository.Repositories) { repos.Mods = failingMods{repos.Mods} },
+		"member": func(repos *repository.Repositories) { repos.Members = failingMembers{repos.Members} },
+	}
+
+	for step, fail := range steps {
+		t.Run(step, func(t *testing.T) {
+			repository.GRepos = repository.NewMemoryRepositories()
+			owner := testUser(t, "owner")
+
+			working := *repository.GRepos
+			fail(repository.GRepos)
+
+			if w := testRequest(RoomCreate, owner, gin.H{"name": "lobby"}); w.Code != http.StatusInternalServerError {
+				t.Fatalf("Creating a room should fail, got %d: %s", w.Code, w.Body)
+			}
+
+			if rooms, _ := working.Rooms.ListPublic(repository.RoomListOptions{Limit: 10}); len(rooms) != 0 {
+				t.Error("A room that failed to be created shouldn't be left behind.")
+			}
+			if rooms, _ := working.Members.ListRooms(owner.ID); len(rooms) != 0 {
+				t.Error("A room that failed to be created shouldn't have members.")
+			}
+		})
+	}
+}
+
+func Test_Room_LeaveRollsBack(t *testing.T) {
+	steps := map[string]func(repos *repository.Repositories){
+		"mod":    func(repos *repository.Repositories) { repos.Mods = failingMods{repos.Mods} },
+		"member": func(repos *repository.Repositories) { repos.Members = failingMembers{repos.Members} },
+	}
+
+	for step, fail := range steps {
+		t.Run(step, func(t *testing.T) {
+			repository.GRepos = repository.NewMemoryRepositories()
+			owner := testUser(t, "owner")
+			mod := testUser(t, "mod")
+
+			w := testRequest(RoomCreate, owner, gin.H{"name": "lobby"})
+			var created struct {
+				RoomID string `json:"roomID"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &created)
+
+			if w := testRequest(RoomJoin, mod, gin.H{"roomID": created.RoomID}); w.Code != http.StatusOK {
+				t.Fatalf("Joining a public room should work, got %d: %s", w.Code, w.Body)
+			}
+			roomMod := models.RoomMod{
+				GivenFields: models.GivenFields{
+					ID: "mod-record",
+				},
+				RoomID: created.RoomID,
+				UserID: mod.ID,
+				Role:   models.RoomModRoleMod,
+			}
+			if err := repository.GRepos.Mods.Create(&roomMod); err != nil {
+				t.Fatal(err)
+			}
+
+			working := *repository.GRepos
+			fail(repository.GRepos)
+
+			if w := testRequest(RoomLeave, mod, gin.H{"roomID": created.RoomID}); w.Code != http.StatusInternalServerError {
+				t.Fatalf("Leaving a room should fail, got %d: %s", w.Code, w.Body)
+			}
+
+			if _, err := working.Mods.Find(created.RoomID, mod.ID); err != nil {
+				t.Error("A mod who failed to leave should still be a mod.")
+			}
+			if _, err := working.Members.Find(created.RoomID, mod.ID); err != nil {
+				t.Error("A mod who failed to leave should still be a member.")
+			}
+		})
+	}
+}
+
+func Test_Room_TransferOwnership(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	mod := testUser(t, "mod")
+	outsider := testUser(t, "outsider")
+	roomID := testModRoom(t, owner, mod)
+
+	if w := testRequest(RoomAddMod, owner, gin.H{"roomID": roomID, "userID": mod.ID, "role": models.RoomModRoleMod}); w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to add a mod, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := testRequest(RoomTransferOwnership, mod, gin.H{"roomID": roomID, "userID": mod.ID}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Only an owner should be able to give a room away, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": outsider.ID}); w.Code != http.StatusBadRequest {
+		t.Errorf("A room shouldn't be given to someone who isn't in it, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": mod.ID}); w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to give a room to a member, got %d: %s", w.Code, w.Body)
+	}
+
+	if m, _ := repository.GRepos.Mods.Find(roomID, mod.ID); m.Role != models.RoomModRoleOwner {
+		t.Error("The new owner should own the room.")
+	}
+	if m, _ := repository.GRepos.Mods.Find(roomID, owner.ID); m.Role != models.RoomModRoleMod {
+		t.Error("The old owner should take the new owner's old role.")
+	}
+
+	entries, err := repository.GRepos.Audit.List(roomID, 10)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("A transfer should be in the audit log, got %v: %v", entries, err)
+	}
+	if e := entries[0]; e.Action != models.AuditOwnershipTransferred || e.ActorID != owner.ID || e.TargetID != mod.ID {
+		t.Errorf("The audit entry should say who gave the room to who, got %+v", e)
+	}
+
+	if w := testRequest(RoomGetAuditLog, owner, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("The audit log needs a roomID, got %d", w.Code)
+	}
+}
+
+func Test_Room_TransferOwnershipPassword(t *testing.T) {
+	t.Setenv("ROOM_TRANSFER_REQUIRE_PASSWORD", "true")
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	owner.Password = util.HashPassword("correct horse battery staple")
+	if err := repository.GRepos.Users.Save(&owner); err != nil {
+		t.Fatal(err)
+	}
+	guest := testUser(t, "guest")
+	roomID := testModRoom(t, owner, guest)
+
+	if w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Giving a room away without a password should be refused, got %d", w.Code)
+	}
+
+	if w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": guest.ID, "password": "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Giving a room away with the wrong password should be refused, got %d", w.Code)
+	}
+
+	w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": guest.ID, "password": "correct horse battery staple"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Giving a room away with the right password should work, got %d: %s", w.Code, w.Body)
+	}
+
+	// a member who wasn't a mod becomes the owner and the old owner a plain mod
+	if m, _ := repository.GRepos.Mods.Find(roomID, guest.ID); m.Role != models.RoomModRoleOwner {
+		t.Error("The new owner should own the room.")
+	}
+	if m, _ := repository.GRepos.Mods.Find(roomID, owner.ID); m.Role != models.RoomModRoleMod {
+		t.Error("The old owner should become a mod.")
+	}
+}
+
+func Test_Room_TransferOwnershipSecondFactor(t *testing.T) {
+	t.Setenv("ROOM_TRANSFER_REQUIRE_PASSWORD", "true")
+	t.Setenv("APP_KEY", "test key")
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	// someone who signed up through OIDC has no password they know of, just two-factor
+	owner, _ := testEnableTOTP(t, testUser(t, "owner"))
+	guest := testUser(t, "guest")
+	roomID := testModRoom(t, owner, guest)
+
+	w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": guest.ID})
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "two-factor") {
+		t.Errorf("Giving a room away without confirming should ask for a two-factor code, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": guest.ID, "totpCode": "000000"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Giving a room away with the wrong code should be refused, got %d", w.Code)
+	}
+
+	// the current step was used up turning two-factor on
+	code, _ := util.TOTPCode(owner.TOTPSecret, util.TOTPStep(time.Now())+1)
+	if w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": guest.ID, "totpCode": code}); w.Code != http.StatusOK {
+		t.Errorf("Giving a room away with a two-factor code should work, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func Test_Room_TransferOwnershipAPIKey(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	guest := testUser(t, "guest")
+	testModRoom(t, owner, guest)
+
+	w := testRequest(APIKeyCreate, owner, gin.H{"name": "mods", "scopes": []string{models.ScopeModsWrite}})
+	var created struct {
+		Key string `json:"key"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	if w := testAPIKeyRequest(RoomTransferOwnership, models.ScopeModsWrite, created.Key); w.Code != http.StatusForbidden {
+		t.Errorf("An api key shouldn't be able to give a room away, got %d: %s", w.Code, w.Body)
+	}
+}
+
+// slowMods gives requests running at the same time a chance to overlap
+type slowMods struct{ repository.ModRepository }
+
+func (m slowMods) Find(roomID string, userID string) (models.RoomMod, error) {
+	time.Sleep(10 * time.Millisecond)
+	return m.ModRepository.Find(roomID, userID)
+}
+
+func (m slowMods) Save(mod *models.RoomMod) error {
+	time.Sleep(10 * time.Millisecond)
+	return m.ModRepository.Save(mod)
+}
+
+func (m slowMods) Delete(mod *models.RoomMod) error {
+	time.Sleep(10 * time.Millisecond)
+	return m.ModRepository.Delete(mod)
+}
+
+func Test_Room_TransferOwnershipConcurrently(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	var members []models.User
+	for i := range 5 {
+		members = append(members, testUser(t, fmt.Sprintf("member-%d", i)))
+	}
+	roomID := testModRoom(t, owner, members...)
+	repository.GRepos.Mods = slowMods{repository.GRepos.Mods}
+
+	// the owner gives the room to everyone at once, only one of them can get it
+	var wg sync.WaitGroup
+	codes := make([]int, len(members))
+	for i, member := range members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": member.ID}).Code
+		}()
+	}
+	wg.Wait()
+
+	if succeeded := slices.Index(codes, http.StatusOK); succeeded == -1 || slices.Contains(codes[succeeded+1:], http.StatusOK) {
+		t.Errorf("Exactly one transfer should work, got %v", codes)
+	}
+	if owners, _ := repository.GRepos.Mods.CountRole(roomID, models.RoomModRoleOwner); owners != 1 {
+		t.Errorf("The room should end up with one owner, it has %d", owners)
+	}
+}
+
+type failingAudit struct{ repository.AuditRepository }
+
+func (failingAudit) Create(*models.RoomAuditEntry) error { return errInjected }
+
+func Test_Room_TransferOwnershipRollsBack(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	guest := testUser(t, "guest")
+	roomID := testModRoom(t, owner, guest)
+
+	working := *repository.GRepos
+	repository.GRepos.Audit = failingAudit{repository.GRepos.Audit}
+
+	if w := testRequest(RoomTransferOwnership, owner, gin.H{"roomID": roomID, "userID": guest.ID}); w.Code != http.StatusInternalServerError {
+		t.Fatalf("Giving a room away should fail, got %d: %s", w.Code, w.Body)
+	}
+
+	if m, err := working.Mods.Find(roomID, owner.ID); err != nil || m.Role != models.RoomModRoleOwner {
+		t.Error("The owner should still own a room that failed to be given away.")
+	}
+	if _, err := working.Mods.Find(roomID, guest.ID); err == nil {
+		t.Error("A room that failed to be given away shouldn't have a new owner.")
+	}
+}
+
+// testSQLite points the repositories at a freshly migrated SQLite database for the rest of the test
+func testSQLite(t *testing.T) {
+	t.Setenv("DB_DRIVER", database.DriverSQLite)
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "superchat.db"))
+
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	m, err := database.NewMigrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal("couldn't run migrations:", err)
+	}
+
+	gdb, err := database.InitGDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository.GRepos = repository.NewGormRepositories(gdb)
+}
+
+// testRoomList lists rooms with query and returns the names of the ones listed
+func testRoomList(t *testing.T, query string) (int, []string) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+
+	RoomList(c)
+
+	var res struct {
+		Rooms []struct {
+			Name string `json:"name"`
+		} `json:"rooms"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	var names []string
+	for _, r := range res.Rooms {
+		names = append(names, r.Name)
+	}
+	return w.Code, names
+}
+
+func Test_Room_List(t *testing.T) {
+	for name, setUp := range map[string]func(t *testing.T){
+		"memory": func(t *testing.T) { repository.GRepos = repository.NewMemoryRepositories() },
+		"sqlite": testSQLite,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setUp(t)
+
+			owner := testUser(t, "owner")
+			guest := testUser(t, "guest")
+			roomIDs := make(map[string]string)
+			for _, room := range []gin.H{
+				{"name": "100% fun"},
+				{"name": "100 fun"},
+				{"name": "snake_case"},
+				{"name": "snakeXcase"},
+				{"name": "secret club", "private": true},
+				{"name": "locked", "password": "a room password"},
+			} {
+				w := testRequest(RoomCreate, owner, room)
+				if w.Code != http.StatusOK {
+					t.Fatalf("Creating a room should work, got %d: %s", w.Code, w.Body)
+				}
+				var created struct {
+					RoomID string `json:"roomID"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &created)
+				roomIDs[room["name"].(string)] = created.RoomID
+			}
+
+			// the busiest rooms come first
+			if w := testRequest(RoomJoin, guest, gin.H{"roomID": roomIDs["snake_case"]}); w.Code != http.StatusOK {
+				t.Fatalf("Joining a public room should work, got %d: %s", w.Code, w.Body)
+			}
+
+			for query, want := range map[string][]string{
+				"":                 {"snake_case", "100 fun", "100% fun", "locked", "snakeXcase"},
+				"search=%25":       {"100% fun"},
+				"search=_":         {"snake_case"},
+				"search=100%25":    {"100% fun"},
+				"search=FUN":       {"100 fun", "100% fun"},
+				"search=case":      {"snake_case", "snakeXcase"},
+				"search=club":      nil,
+				"protected=false":  {"snake_case", "100 fun", "100% fun", "snakeXcase"},
+				"limit=2":          {"snake_case", "100 fun"},
+				"limit=2&offset=2": {"100% fun", "locked"},
+				"offset=4":         {"snakeXcase"},
+				"offset=5":         nil,
+			} {
+				code, names := testRoomList(t, query)
+				if code != http.StatusOK || !slices.Equal(names, want) {
+					t.Errorf("Listing rooms with %q should give %v, got %d: %v", query, want, code, names)
+				}
+			}
+
+			for _, query := range []string{"limit=0", "limit=-1", "limit=lots", "offset=-1", "offset=first"} {
+				if code, _ := testRoomList(t, query); code != http.StatusBadRequest {
+					t.Errorf("Listing rooms with %q should be refused, got %d", query, code)
+				}
+			}
+
+			for i := range maxRoomListLimit {
+				if w := testRequest(RoomCreate, guest, gin.H{"name": fmt.Sprintf("room %03d", i)}); w.Code != http.StatusOK {
+					t.Fatalf("Creating a room should work, got %d: %s", w.Code, w.Body)
+				}
+			}
+			if _, names := testRoomList(t, ""); len(names) != defaultRoomListLimit {
+				t.Errorf("Rooms should be listed %d at a time by default, got %d", defaultRoomListLimit, len(names))
+			}
+			if _, names := testRoomList(t, "limit=1000"); len(names) != maxRoomListLimit {
+				t.Errorf("The limit should be capped at %d, got %d", maxRoomListLimit, len(names))
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package routes
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/middleware"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	accessTokenLifetime  = 15 * time.Minute
+	refreshTokenLifetime = 30 * 24 * time.Hour
+)
+
+var (
+	errRefreshTokenReused  = errors.New("refresh token reuse detected")
+	errRefreshTokenExpired = errors.New("expired refresh token")
+)
+
+// UserGetSessions lists the authenticated user's active sessions so they can see where they're logged in
+func UserGetSessions(c *gin.Context) {
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+	current := c.MustGet("session").(models.Session)
+
+	sessions, err := repository.GRepos.Sessions.ListActive(user.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error getting sessions",
+		})
+		return
+	}
+
+	out := make([]gin.H, 0, len(sessions))
+	for _, s := range sessions {
+		var lastUsedAt *string
+		if s.LastUsedAt != nil {
+			formatted := s.LastUsedAt.Format(time.RFC3339)
+			lastUsedAt = &formatted
+		}
+
+		out = append(out, gin.H{
+			"sessionID":  s.ID,
+			"device":     s.Device,
+			"userAgent":  s.UserAgent,
+			"ip":         s.IP,
+			"current":    s.ID == current.ID,
+			"createdAt":  s.CreatedAt.Format(time.RFC3339),
+			"lastUsedAt": lastUsedAt,
+			"expiresAt":  s.RefreshExpiresAt.Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": out,
+	})
+}
+
+type UserRevokeSessionRequest struct {
+	SessionID string `json:"sessionID" binding:"required"`
+}
+
+// UserRevokeSession logs out one of the authenticated user's sessions, which can be the current one
+func UserRevokeSession(c *gin.Context) {
+	var req UserRevokeSessionRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	// only let users revoke their own sessions
+	sesh, err := repository.GRepos.Sessions.FindByID(req.SessionID)
+	if err != nil || sesh.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "session not found",
+		})
+		return
+	}
+
+	if err := repository.GRepos.Sessions.Delete(&sesh); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error revoking session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// UserRevokeOtherSessions logs out every session the authenticated user has except the one making the request
+func UserRevokeOtherSessions(c *gin.Context) {
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+	current := c.MustGet("session").(models.Session)
+
+	revoked, err := repository.GRepos.Sessions.DeleteOthers(user.ID, current.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error revoking sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": revoked,
+	})
+}
+
+// UserLogout ends the session making the request
+func UserLogout(c *gin.Context) {
+	current := c.MustGet("session").(models.Session)
+
+	if err := repository.GRepos.Sessions.Delete(&current); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error logging out",
+		})
+		return
+	}
+
+	if current.CSRFToken != "" {
+		clearSessionCookies(c)
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type UserRefreshTokenRequest struct {
+	// RefreshToken can be left out by browser sessions, which send it in a cookie instead
+	RefreshToken string `json:"refreshToken"`
+}
+
+// UserRefreshToken trades a refresh token for a new access token and a new refresh token. Each refresh token works
+// once. If one is ever presented a second time it has probably been stolen, so the whole session is revoked.
+func UserRefreshToken(c *gin.Context) {
+	var req UserRefreshTokenRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	fromCookie := false
+	if req.RefreshToken == "" {
+		// c.Cookie would unescape it but setSessionCookies stores tokens as they are, and they can have a + in them
+		cookie, err := c.Request.Cookie(refreshCookie)
+		if err != nil || cookie.Value == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "missing refresh token",
+			})
+			return
+		}
+		req.RefreshToken = cookie.Value
+		fromCookie = true
+	}
+
+	refresh, err := repository.GRepos.RefreshTokens.FindByToken(util.HashToken(req.RefreshToken))
+	if err != nil || !util.ValidateToken(req.RefreshToken, refresh.Token) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid refresh token",
+		})
+		return
+	}
+
+	// get the session the refresh token belongs to
+	sesh, err := repository.GRepos.Sessions.FindByID(refresh.SessionID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid refresh token",
+		})
+		return
+	}
+
+	// cookies get sent no matter which site made the request
+	if fromCookie && !middleware.ValidCSRF(c, sesh) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "invalid csrf token",
+		})
+		return
+	}
+
+	now := time.Now()
+	token, hash := util.CreateToken()
+	sesh.Token = hash
+	sesh.ExpiresAt = now.Add(accessTokenLifetime)
+	sesh.RefreshExpiresAt = now.Add(refreshTokenLifetime)
+
+	tokens := sessionTokens{
+		Token: util.EncodeBearerToken(sesh.ID, token),
+	}
+	if fromCookie {
+		csrfToken, csrfHash := util.CreateToken()
+		sesh.CSRFToken = csrfHash
+		tokens.CSRFToken = csrfToken
+	}
+
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		// claim the refresh token, only one request can ever do this
+		claimed, err := tx.RefreshTokens.Claim(&refresh, now)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return errRefreshTokenReused
+		}
+
+		if now.After(refresh.ExpiresAt) {
+			return errRefreshTokenExpired
+		}
+
+		if err := tx.Sessions.Save(&sesh); err != nil {
+			return err
+		}
+
+		tokens.RefreshToken, err = createRefreshToken(tx, sesh)
+		return err
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		log.Printf("refresh token reuse detected for session %s, revoking it\n", sesh.ID)
+		repository.GRepos.Sessions.Delete(&sesh)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "refresh token reuse detected",
+		})
+		return
+	}
+	if errors.Is(err, errRefreshTokenExpired) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "expired refresh token",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error refreshing session",
+		})
+		return
+	}
+
+	sessionResponse(c, sesh, tokens)
+}
+
+// sessionTokens are what a client is given for a session, only their hashes are stored
+type sessionTokens struct {
+	Token        string
+	RefreshToken string
+	// CSRFToken is only set for browser sessions, which get the other tokens in cookies
+	CSRFToken string
+}
+
+// createSession starts a new session for user and returns it along with its tokens. The access token is a bearer
+// token that identifies the session on its own. Browser sessions get a CSRF token too.
+func createSession(c *gin.Context, user models.User, device string, browser bool) (models.Session, sessionTokens, error) {
+	// generate token to send to user and store hashed token in database
+	token, hash := util.CreateToken()
+
+	now := time.Now()
+	sesh := models.Session{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		Token:            hash,
+		ExpiresAt:        now.Add(accessTokenLifetime),
+		RefreshExpiresAt: now.Add(refreshTokenLifetime),
+		UserID:           user.ID,
+		Device:           device,
+		UserAgent:        truncate(c.Request.UserAgent(), 255),
+		IP:               c.ClientIP(),
+	}
+
+	tokens := sessionTokens{
+		Token: util.EncodeBearerToken(sesh.ID, token),
+	}
+	if browser {
+		csrfToken, csrfHash := util.CreateToken()
+		sesh.CSRFToken = csrfHash
+		tokens.CSRFToken = csrfToken
+	}
+
+	err := repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		if err := tx.Sessions.Create(&sesh); err != nil {
+			return err
+		}
+
+		var err error
+		tokens.RefreshToken, err = createRefreshToken(tx, sesh)
+		return err
+	})
+
+	return sesh, tokens, err
+}
+
+// createRefreshToken stores a new refresh token for sesh and returns it
+func createRefreshToken(repos *repository.Repositories, sesh models.Session) (string, error) {
+	token, hash := util.CreateToken()
+
+	refresh := models.RefreshToken{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		SessionID: sesh.ID,
+		Token:     hash,
+		ExpiresAt: sesh.RefreshExpiresAt,
+	}
+
+	if err := repos.RefreshTokens.Create(&refresh); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// sessionResponse gives the client its session's tokens. Browser sessions get them in cookies their JavaScript can't
+// read so only the CSRF token is in the body.
+func sessionResponse(c *gin.Context, sesh models.Session, tokens sessionTokens) {
+	if tokens.CSRFToken != "" {
+		setSessionCookies(c, sesh, tokens.Token, tokens.RefreshToken, tokens.CSRFToken)
+
+		c.JSON(http.StatusOK, gin.H{
+			"csrfToken":        tokens.CSRFToken,
+			"userID":           sesh.UserID,
+			"sessionID":        sesh.ID,
+			"expiresAt":        sesh.ExpiresAt.Format(time.RFC3339),
+			"refreshExpiresAt": sesh.RefreshExpiresAt.Format(time.RFC3339),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":            tokens.Token,
+		"tokenType":        "Bearer",
+		"refreshToken":     tokens.RefreshToken,
+		"userID":           sesh.UserID,
+		"sessionID":        sesh.ID,
+		"expiresAt":        sesh.ExpiresAt.Format(time.RFC3339),
+		"refreshExpiresAt": sesh.RefreshExpiresAt.Format(time.RFC3339),
+	})
+}
+
+// truncate cuts s down to at most n bytes so it fits in a VARCHAR column
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,223 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/middleware"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBearerRequest runs handler behind the auth middleware for a request authenticated with token
+func testBearerRequest(handler gin.HandlerFunc, token string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/", middleware.AuthMiddleware, handler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+// testCookieRequest runs handlers for a method request sent by a browser with cookies and, unless it's empty,
+// csrfToken in the CSRF header
+func testCookieRequest(method string, cookies []*http.Cookie, csrfToken string,
+	handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Handle(method, "/", handlers...)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "/", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if csrfToken != "" {
+		req.Header.Set(middleware.CSRFHeader, csrfToken)
+	}
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+// testRefresh trades refreshToken for new tokens, which are left empty if it doesn't work
+func testRefresh(refreshToken string) (*httptest.ResponseRecorder, sessionTokens) {
+	w := testRequest(UserRefreshToken, models.User{}, gin.H{"refreshToken": refreshToken})
+
+	var res struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	return w, sessionTokens{Token: res.Token, RefreshToken: res.RefreshToken}
+}
+
+func Test_Session_Refresh(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	user := testUser(t, "user")
+	sesh, tokens := testSession(t, user, false)
+
+	w, refreshed := testRefresh(tokens.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Refreshing a session should work, got %d: %s", w.Code, w.Body)
+	}
+	if refreshed.Token == tokens.Token || refreshed.RefreshToken == tokens.RefreshToken {
+		t.Error("Refreshing a session should hand out new tokens.")
+	}
+
+	if w := testBearerRequest(UserGetSessions, refreshed.Token); w.Code != http.StatusOK {
+		t.Errorf("The new access token should work, got %d: %s", w.Code, w.Body)
+	}
+	if w := testBearerRequest(UserGetSessions, tokens.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("The old access token should stop working, got %d", w.Code)
+	}
+
+	w, refreshed = testRefresh(refreshed.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("The new refresh token should work, got %d: %s", w.Code, w.Body)
+	}
+
+	if w, _ := testRefresh("nope"); w.Code != http.StatusUnauthorized {
+		t.Errorf("An unknown refresh token should be refused, got %d", w.Code)
+	}
+	if _, err := repository.GRepos.Sessions.FindByID(sesh.ID); err != nil {
+		t.Fatal("An unknown refresh token shouldn't touch any session.")
+	}
+
+	// someone replays the first refresh token, which has already been used
+	if w, _ := testRefresh(tokens.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("A used refresh token should be refused, got %d", w.Code)
+	}
+	if _, err := repository.GRepos.Sessions.FindByID(sesh.ID); err == nil {
+		t.Error("Reusing a refresh token should revoke the whole session.")
+	}
+	if w := testBearerRequest(UserGetSessions, refreshed.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("The latest access token should stop working once the session is revoked, got %d", w.Code)
+	}
+	if w, _ := testRefresh(refreshed.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("The latest refresh token should stop working once the session is revoked, got %d", w.Code)
+	}
+}
+
+func Test_Session_RefreshExpired(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	user := testUser(t, "user")
+	sesh, _ := testSession(t, user, false)
+
+	sesh.RefreshExpiresAt = time.Now().Add(-time.Minute)
+	expired, err := createRefreshToken(repository.GRepos, sesh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w, _ := testRefresh(expired); w.Code != http.StatusUnauthorized {
+		t.Errorf("An expired refresh token should be refused, got %d", w.Code)
+	}
+	if w, _ := testRefresh(expired); w.Code != http.StatusUnauthorized {
+		t.Errorf("An expired refresh token should still be refused the second time, got %d", w.Code)
+	}
+	if _, err := repository.GRepos.Sessions.FindByID(sesh.ID); err != nil {
+		t.Error("An expired refresh token isn't reuse, the session shouldn't be revoked.")
+	}
+}
+
+func Test_Session_Cookie(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	user := testUser(t, "user")
+	user.Password = util.HashPassword("correct horse battery staple")
+	if err := repository.GRepos.Users.Save(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	w := testRequest(UserGetToken, models.User{}, gin.H{
+		"email":    user.Email,
+		"password": "correct horse battery staple",
+		"cookie":   true,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Logging in with cookies should work, got %d: %s", w.Code, w.Body)
+	}
+
+	var login struct {
+		Token     string `json:"token"`
+		CSRFToken string `json:"csrfToken"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &login)
+	if login.Token != "" || login.CSRFToken == "" {
+		t.Fatalf("A cookie login should only give the CSRF token in the body, got %s", w.Body)
+	}
+
+	cookies := w.Result().Cookies()
+	for _, cookie := range cookies {
+		if httpOnly := cookie.Name != csrfCookie; cookie.HttpOnly != httpOnly || !cookie.Secure || cookie.Value == "" {
+			t.Errorf("The %s cookie isn't set up right: %+v", cookie.Name, cookie)
+		}
+	}
+	if len(cookies) != 3 {
+		t.Fatalf("A cookie login should set the session, refresh and CSRF cookies, got %v", cookies)
+	}
+
+	// safe methods can't change anything so they don't need the CSRF token
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		if w := testCookieRequest(method, cookies, "", middleware.AuthMiddleware, UserGetSessions); w.Code != http.StatusOK {
+			t.Errorf("A %s with the session cookie should work without a CSRF token, got %d: %s", method, w.Code, w.Body)
+		}
+	}
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if w := testCookieRequest(method, cookies, "", middleware.AuthMiddleware, UserGetSessions); w.Code != http.StatusForbidden {
+			t.Errorf("A %s with the session cookie and no CSRF token should be refused, got %d", method, w.Code)
+		}
+		if w := testCookieRequest(method, cookies, "wrong", middleware.AuthMiddleware, UserGetSessions); w.Code != http.StatusForbidden {
+			t.Errorf("A %s with the wrong CSRF token should be refused, got %d", method, w.Code)
+		}
+		if w := testCookieRequest(method, cookies, login.CSRFToken, middleware.AuthMiddleware, UserGetSessions); w.Code != http.StatusOK {
+			t.Errorf("A %s with the right CSRF token should work, got %d: %s", method, w.Code, w.Body)
+		}
+	}
+
+	// the refresh cookie is sent along with requests other sites make too
+	if w := testCookieRequest(http.MethodPost, cookies, "", UserRefreshToken); w.Code != http.StatusForbidden {
+		t.Errorf("Refreshing with a cookie and no CSRF token should be refused, got %d", w.Code)
+	}
+	if w := testCookieRequest(http.MethodPost, cookies, "wrong", UserRefreshToken); w.Code != http.StatusForbidden {
+		t.Errorf("Refreshing with a cookie and the wrong CSRF token should be refused, got %d", w.Code)
+	}
+
+	w = testCookieRequest(http.MethodPost, cookies, login.CSRFToken, UserRefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Refreshing with a cookie and the CSRF token should work, got %d: %s", w.Code, w.Body)
+	}
+
+	var refreshed struct {
+		CSRFToken string `json:"csrfToken"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &refreshed)
+	if refreshed.CSRFToken == "" || refreshed.CSRFToken == login.CSRFToken {
+		t.Fatalf("Refreshing a cookie session should hand out a new CSRF token, got %s", w.Body)
+	}
+
+	cookies = w.Result().Cookies()
+	if w := testCookieRequest(http.MethodPost, cookies, login.CSRFToken, middleware.AuthMiddleware, UserGetSessions); w.Code != http.StatusForbidden {
+		t.Errorf("The old CSRF token should stop working after a refresh, got %d", w.Code)
+	}
+	if w := testCookieRequest(http.MethodPost, cookies, refreshed.CSRFToken, middleware.AuthMiddleware, UserGetSessions); w.Code != http.StatusOK {
+		t.Errorf("The new CSRF token should work after a refresh, got %d: %s", w.Code, w.Body)
+	}
+}
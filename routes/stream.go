@@ -0,0 +1,238 @@
+package routes
+
+import (
+	"errors"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/repository"
+	"net/http"
+	"time"
+)
+
+const (
+	streamKeepAlive   = 30 * time.Second
+	streamReplayLimit = 500
+)
+
+// sessionRecheckPeriod is how often gateway connections and event streams make sure they're still authenticated
+var sessionRecheckPeriod = time.Minute
+
+// RoomEvents streams room events as Server-Sent Events for clients that can't use the WebSocket gateway. Rooms are
+// chosen with one or more ?roomID= params.
+//
+// Only message events carry an SSE id (the message ID) so a reconnecting client's Last-Event-ID always points at the
+// last message it saw. Every message in the requested rooms created after that one is replayed before live events
+// resume.
+func RoomEvents(c *gin.Context) {
+	roomIDs := c.QueryArray("roomID")
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		// EventSource can't set headers on the first connection so allow resuming with a param too
+		lastEventID = c.Query("lastEventID")
+	}
+
+	if len(roomIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing roomID",
+		})
+		return
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	// api keys can be limited to certain rooms
+	for _, roomID := range roomIDs {
+		if !apiKeyAllowsRoom(c, roomID) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "api key can't access this room",
+			})
+			return
+		}
+	}
+
+	// make sure user is in every room
+	roomUserCount, err := repository.GRepos.Members.CountRooms(user.ID, roomIDs)
+	if err != nil || roomUserCount != int64(len(uniqueStrings(roomIDs))) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "you're not in one of these rooms",
+		})
+		return
+	}
+
+	var cursor models.RoomMessage
+	if lastEventID != "" {
+		// the last message the client saw may have been deleted since, it's still where the replay starts
+		cursor, err = repository.GRepos.Messages.FindCursor(roomIDs, lastEventID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "last event not found",
+			})
+			return
+		}
+	}
+
+	// subscribe before replaying so nothing posted during the replay is missed
+	sub := events.GHub.NewSubscription()
+	defer sub.Close()
+	subscribed := uniqueStrings(roomIDs)
+	for roomID := range subscribed {
+		sub.Subscribe(roomID)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// stop nginx and friends from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	replayed := make(map[string]struct{})
+	if cursor.ID != "" {
+		missed, _ := repository.GRepos.Messages.ListAfter(roomIDs, cursor, streamReplayLimit+1)
+
+		if len(missed) > streamReplayLimit {
+			// too far behind to replay, the client should refetch history instead
+			c.Render(-1, sse.Event{
+				Event: "replay_truncated",
+				Data:  gin.H{},
+			})
+			missed = missed[:streamReplayLimit]
+		}
+
+		for _, m := range missed {
+			replayed[m.ID] = struct{}{}
+			c.Render(-1, sse.Event{
+				Id:    m.ID,
+				Event: events.MessageCreated,
+				Data: events.Event{
+					ID:     m.ID,
+					Type:   events.MessageCreated,
+					RoomID: m.RoomID,
+					Data:   messageResponse(m),
+				},
+			})
+		}
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	recheck := time.NewTicker(sessionRecheckPeriod)
+	defer recheck.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// the hub dropped us for being too slow, the client will reconnect with Last-Event-ID
+				return
+			}
+
+			deliver, left := checkMembership(sub, user.ID, e)
+			if !deliver {
+				continue
+			}
+
+			ev := sse.Event{
+				Event: e.Type,
+				Data:  e,
+			}
+			if e.Type == events.MessageCreated {
+				if _, seen := replayed[e.ID]; seen {
+					continue
+				}
+				ev.Id = e.ID
+			}
+
+			c.Render(-1, ev)
+			c.Writer.Flush()
+
+			if left {
+				delete(subscribed, e.RoomID)
+				if len(subscribed) == 0 {
+					return
+				}
+			}
+		case <-ticker.C:
+			// comments keep idle connections from being cut by proxies
+			c.Writer.WriteString(": keepalive\n\n")
+			c.Writer.Flush()
+		case <-recheck.C:
+			if !stillAuthenticated(c) {
+				c.Render(-1, sse.Event{
+					Event: "session_ended",
+					Data:  gin.H{},
+				})
+				c.Writer.Flush()
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// checkMembership is run on every event a user's subscription receives before it's sent on. Events from rooms the
+// subscription has dropped are skipped. A member_left event might mean the user left, was kicked or was banned, so if
+// they're no longer in the room it's dropped, after this last event is delivered so the client finds out why. It
+// returns whether to deliver e and whether the room was dropped.
+func checkMembership(sub *events.Subscription, userID string, e events.Event) (bool, bool) {
+	if !sub.Subscribed(e.RoomID) {
+		return false, false
+	}
+
+	if e.Type != events.MemberLeft {
+		return true, false
+	}
+
+	if _, err := repository.GRepos.Members.Find(e.RoomID, userID); !errors.Is(err, repository.ErrNotFound) {
+		return true, false
+	}
+
+	sub.Unsubscribe(e.RoomID)
+	return true, true
+}
+
+// stillAuthenticated is run every sessionRecheckPeriod by connections that stay open. Requests are only authenticated
+// when they start, so this is what cuts a connection off once the session or API key it was opened with is logged out,
+// revoked or expires, or the user is deleted. Errors other than not finding something leave the connection be.
+func stillAuthenticated(c *gin.Context) bool {
+	now := time.Now()
+
+	if s, ok := c.Get("session"); ok {
+		sesh, err := repository.GRepos.Sessions.FindByID(s.(models.Session).ID)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && now.After(sesh.ExpiresAt)) {
+			return false
+		}
+	}
+
+	if k, ok := c.Get("apiKey"); ok {
+		key := k.(models.APIKey)
+		key, err := repository.GRepos.APIKeys.Find(key.CreatedByID, key.ID)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+			return false
+		}
+	}
+
+	_, err := repository.GRepos.Users.FindByID(c.MustGet("user").(models.User).ID)
+	return !errors.Is(err, repository.ErrNotFound)
+}
+
+func uniqueStrings(s []string) map[string]struct{} {
+	out := make(map[string]struct{}, len(s))
+	for _, v := range s {
+		out[v] = struct{}{}
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMessages posts one message to roomID as user for each id, all within the same second
+func testMessages(t *testing.T, roomID string, user models.User, ids ...string) {
+	second := time.Now().Truncate(time.Second)
+	for _, id := range ids {
+		m := models.RoomMessage{
+			GivenFields: models.GivenFields{
+				ID:        id,
+				CreatedAt: second,
+			},
+			RoomID:  roomID,
+			UserID:  user.ID,
+			Message: "message " + id,
+		}
+		if err := repository.GRepos.Messages.Create(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testReplay connects to the event stream for roomID as user, resuming from lastEventID, and returns the IDs of the
+// messages that were replayed. The connection is closed as soon as the replay is done.
+func testReplay(t *testing.T, user models.User, roomID string, lastEventID string) (int, []string) {
+	gin.SetMode(gin.TestMode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?roomID="+roomID, nil).WithContext(ctx)
+	c.Request.Header.Set("Last-Event-ID", lastEventID)
+	c.Set("user", user)
+
+	RoomEvents(c)
+
+	var ids []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if id, ok := strings.CutPrefix(line, "id:"); ok {
+			ids = append(ids, id)
+		}
+	}
+	return w.Code, ids
+}
+
+func Test_Stream_Replay(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	roomID := testModRoom(t, owner)
+
+	// the IDs go down while the messages go up so ordering by ID within the second would get them backwards
+	testMessages(t, roomID, owner, "d", "c", "b", "a")
+
+	code, ids := testReplay(t, owner, roomID, "d")
+	if code != http.StatusOK || !slices.Equal(ids, []string{"c", "b", "a"}) {
+		t.Errorf("Every message after the last event should be replayed in order, got %d: %v", code, ids)
+	}
+
+	code, ids = testReplay(t, owner, roomID, "b")
+	if code != http.StatusOK || !slices.Equal(ids, []string{"a"}) {
+		t.Errorf("Messages from the same second as the last event should be replayed, got %d: %v", code, ids)
+	}
+
+	// the client was last shown c and it's deleted before they reconnect
+	c, _ := repository.GRepos.Messages.Find([]string{roomID}, "c")
+	if err := repository.GRepos.Messages.Delete(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	code, ids = testReplay(t, owner, roomID, "c")
+	if code != http.StatusOK || !slices.Equal(ids, []string{"b", "a"}) {
+		t.Errorf("A deleted last event should still resume the stream, got %d: %v", code, ids)
+	}
+
+	code, ids = testReplay(t, owner, roomID, "d")
+	if code != http.StatusOK || !slices.Equal(ids, []string{"b", "a"}) {
+		t.Errorf("Deleted messages shouldn't be replayed, got %d: %v", code, ids)
+	}
+
+	if code, _ := testReplay(t, owner, roomID, "nope"); code != http.StatusBadRequest {
+		t.Errorf("An unknown last event should be refused, got %d", code)
+	}
+}
+
+func Test_Stream_SessionEnds(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	testSessionRecheck(t)
+
+	user := testUser(t, "user")
+	roomID := testModRoom(t, user)
+	sesh, tokens := testSession(t, user, false)
+	s := testAuthServer(t, RoomEvents)
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"?roomID="+roomID, nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Streaming a room you're in should work, got %d", res.StatusCode)
+	}
+
+	// the session's access token runs out without being refreshed
+	sesh.ExpiresAt = time.Now().Add(-time.Second)
+	if err := repository.GRepos.Sessions.Save(&sesh); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan string)
+	go func() {
+		body, _ := io.ReadAll(res.Body)
+		done <- string(body)
+	}()
+	select {
+	case body := <-done:
+		if !strings.Contains(body, "event:session_ended") {
+			t.Errorf("The stream should say why it ended, got %q", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The stream should end once its session expires.")
+	}
+}
@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// accountFailureThreshold is how many failed logins an account gets before it's locked
+	accountFailureThreshold = 5
+	// ipFailureThreshold is higher since lots of people can share an IP
+	ipFailureThreshold = 20
+	// the first lockout lasts lockoutBase and each failure after that doubles it up to lockoutMax
+	lockoutBase = 30 * time.Second
+	lockoutMax  = time.Hour
+	// failures older than failureWindow are forgotten
+	failureWindow = time.Hour
+	// password reset requests are throttled the same way, every request counts as a failure
+	resetEmailThreshold = 3
+	resetIPThreshold    = 10
+	// and so are requests to resend a verification email
+	verifyEmailThreshold = 3
+	verifyIPThreshold    = 10
+)
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func resetEmailThrottleKey(email string) string {
+	return "reset:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func resetIPThrottleKey(ip string) string {
+	return "reset-ip:" + ip
+}
+
+func verifyEmailThrottleKey(email string) string {
+	return "verify:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func verifyIPThrottleKey(ip string) string {
+	return "verify-ip:" + ip
+}
+
+// loginLockedFor returns how much longer logins are locked out for any of keys, or zero if they aren't
+func loginLockedFor(keys ...string) time.Duration {
+	throttles, err := repository.GRepos.Throttles.List(keys)
+	if err != nil {
+		log.Println("couldn't check login throttles:", err)
+		return 0
+	}
+
+	var wait time.Duration
+	now := time.Now()
+	for _, t := range throttles {
+		if t.LockedUntil != nil && t.LockedUntil.After(now) {
+			wait = max(wait, t.LockedUntil.Sub(now))
+		}
+	}
+
+	return wait
+}
+
+// recordLoginFailure counts a failed login against key and locks it out once it's over threshold
+func recordLoginFailure(key string, threshold int) {
+	now := time.Now()
+	throttles := repository.GRepos.Throttles
+
+	t, err := throttles.Find(key)
+	if errors.Is(err, repository.ErrNotFound) {
+		t = models.LoginThrottle{
+			GivenFields: models.GivenFields{
+				ID: uuid.New().String(),
+			},
+			ThrottleKey: key,
+		}
+		if err = throttles.Create(&t); errors.Is(err, repository.ErrDuplicate) {
+			// someone else created it first
+			t, err = throttles.Find(key)
+		}
+	}
+	if err != nil {
+		log.Println("couldn't record login failure:", err)
+		return
+	}
+
+	restart := t.LastFailedAt != nil && now.Sub(*t.LastFailedAt) > failureWindow
+	if restart {
+		t.Failures = 0
+	}
+	t.Failures++
+
+	var lockedUntil *time.Time
+	if lockout := lockoutFor(t.Failures, threshold); lockout > 0 {
+		until := now.Add(lockout)
+		lockedUntil = &until
+	}
+
+	if err := throttles.AddFailure(&t, now, restart, lockedUntil); err != nil {
+		log.Println("couldn't record login failure:", err)
+	}
+}
+
+// lockoutFor returns how long to lock a key out for after failures failed logins, or zero if it's under threshold
+func lockoutFor(failures int, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	if shift := failures - threshold; shift < 16 {
+		return min(lockoutBase<<shift, lockoutMax)
+	}
+	return lockoutMax
+}
+
+// clearLoginFailures forgets every failed login for key
+func clearLoginFailures(key string) {
+	repository.GRepos.Throttles.Delete(key)
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Throttle_Lockout(t *testing.T) {
+	for _, test := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{accountFailureThreshold - 1, 0},
+		{accountFailureThreshold, lockoutBase},
+		{accountFailureThreshold + 1, 2 * lockoutBase},
+		{accountFailureThreshold + 2, 4 * lockoutBase},
+		{accountFailureThreshold + 10, lockoutMax},
+		{accountFailureThreshold + 100, lockoutMax},
+	} {
+		if got := lockoutFor(test.failures, accountFailureThreshold); got != test.want {
+			t.Errorf("%d failures should lock an account out for %s, got %s", test.failures, test.want, got)
+		}
+	}
+}
+
+func Test_Throttle_Keys(t *testing.T) {
+	if accountThrottleKey(" User@Example.com ") != accountThrottleKey("user@example.com") {
+		t.Error("Emails that only differ in case and spacing should share a throttle.")
+	}
+	if accountThrottleKey("1.2.3.4") == ipThrottleKey("1.2.3.4") {
+		t.Error("Account and IP throttles shouldn't be able to collide.")
+	}
+}
@@ -0,0 +1,289 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+// UserEnrollTOTP starts two-factor enrollment by generating a new secret for the authenticated user. Two-factor isn't
+// turned on until the secret is confirmed with UserConfirmTOTP.
+func UserEnrollTOTP(c *gin.Context) {
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "two-factor is already enabled",
+		})
+		return
+	}
+
+	secret := util.GenerateTOTPSecret()
+
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := repository.GRepos.Users.Save(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "db issue while saving user",
+		})
+		return
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "superchat"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    util.TOTPURI(issuer, user.Email, secret),
+	})
+}
+
+type UserConfirmTOTPRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// UserConfirmTOTP turns on two-factor once the user proves their authenticator app has the secret. The recovery codes
+// are only ever shown here.
+func UserConfirmTOTP(c *gin.Context) {
+	var req UserConfirmTOTPRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "two-factor is already enabled",
+		})
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "two-factor enrollment hasn't been started",
+		})
+		return
+	}
+
+	step, ok := util.ValidateTOTP(user.TOTPSecret, req.Code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid code",
+		})
+		return
+	}
+
+	key, err := appKey()
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "two-factor isn't configured",
+		})
+		return
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	recoveryCodes := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := util.GenerateRecoveryCode()
+		recoveryCodes = append(recoveryCodes, models.RecoveryCode{
+			GivenFields: models.GivenFields{
+				ID: uuid.New().String(),
+			},
+			UserID: user.ID,
+			Lookup: util.KeyedHash(code, key),
+			Code:   util.HashPassword(code),
+		})
+
+		codes = append(codes, code)
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		// replace any recovery codes left over from an earlier enrollment
+		if err := tx.RecoveryCodes.DeleteAll(user.ID); err != nil {
+			return err
+		}
+
+		for i := range recoveryCodes {
+			if err := tx.RecoveryCodes.Create(&recoveryCodes[i]); err != nil {
+				return err
+			}
+		}
+
+		return tx.Users.Save(&user)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error enabling two-factor",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recoveryCodes": codes,
+	})
+}
+
+type UserDisableTOTPRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// UserDisableTOTP turns two-factor off. It needs a current code or a recovery code so a stolen session alone can't do
+// it.
+func UserDisableTOTP(c *gin.Context) {
+	var req UserDisableTOTPRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "two-factor isn't enabled",
+		})
+		return
+	}
+
+	if !verifySecondFactor(user, req.Code, req.RecoveryCode) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid code",
+		})
+		return
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		if err := tx.RecoveryCodes.DeleteAll(user.ID); err != nil {
+			return err
+		}
+
+		return tx.Users.Save(&user)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error disabling two-factor",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// checkSecondFactor is the second step of logging in for a user with two-factor enabled. Wrong codes count as failed
+// logins against accountKey and ipKey. If the code is missing or wrong it responds and returns false.
+func checkSecondFactor(c *gin.Context, user models.User, code string, recoveryCode string, accountKey string,
+	ipKey string) bool {
+	if code == "" && recoveryCode == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"msg":          "two-factor code required",
+			"totpRequired": true,
+		})
+		return false
+	}
+
+	if !verifySecondFactor(user, code, recoveryCode) {
+		recordLoginFailure(accountKey, accountFailureThreshold)
+		recordLoginFailure(ipKey, ipFailureThreshold)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"msg":          "invalid two-factor code",
+			"totpRequired": true,
+		})
+		return false
+	}
+
+	return true
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code for user. Whichever one works is used up.
+func verifySecondFactor(user models.User, code string, recoveryCode string) bool {
+	if code != "" {
+		step, ok := util.ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return false
+		}
+
+		// only one request can claim a step so a code can't be replayed, even concurrently
+		claimed, err := repository.GRepos.Users.ClaimTOTPStep(user.ID, step)
+		return err == nil && claimed
+	}
+
+	if recoveryCode != "" {
+		recoveryCode = strings.ToLower(strings.TrimSpace(recoveryCode))
+
+		key, err := appKey()
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+
+		// codes made before lookups were hashed had theirs cleared, so those are all checked if nothing else matches
+		for _, lookup := range []string{util.KeyedHash(recoveryCode, key), ""} {
+			candidates, err := repository.GRepos.RecoveryCodes.ListUnused(user.ID, lookup)
+			if err != nil {
+				return false
+			}
+
+			for _, candidate := range candidates {
+				if !util.ComparePassword(recoveryCode, candidate.CodeSalt, candidate.Code) {
+					continue
+				}
+
+				claimed, err := repository.GRepos.RecoveryCodes.Claim(&candidate, time.Now())
+				return err == nil && claimed
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnableTOTP turns on two-factor for user and returns it as it's stored along with its recovery codes
+func testEnableTOTP(t *testing.T, user models.User) (models.User, []string) {
+	if w := testRequest(UserEnrollTOTP, user, nil); w.Code != http.StatusOK {
+		t.Fatalf("Starting two-factor enrollment should work, got %d: %s", w.Code, w.Body)
+	}
+	user, _ = repository.GRepos.Users.FindByID(user.ID)
+
+	code, err := util.TOTPCode(user.TOTPSecret, util.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := testRequest(UserConfirmTOTP, user, gin.H{"code": code})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Confirming two-factor should work, got %d: %s", w.Code, w.Body)
+	}
+
+	var res struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	user, _ = repository.GRepos.Users.FindByID(user.ID)
+	return user, res.RecoveryCodes
+}
+
+func Test_TOTP_RecoveryCodes(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	t.Setenv("APP_KEY", "test key")
+
+	user, codes := testEnableTOTP(t, testUser(t, "user"))
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("Enabling two-factor should hand out %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	for _, code := range codes {
+		stored, _ := repository.GRepos.RecoveryCodes.ListUnused(user.ID, util.KeyedHash(code, []byte("test key")))
+		if len(stored) != 1 {
+			t.Fatalf("Every recovery code should be stored under its hashed lookup, found %d", len(stored))
+		}
+		for _, part := range strings.Split(code, "-") {
+			if strings.Contains(stored[0].Lookup, part) || strings.Contains(stored[0].Code, part) {
+				t.Errorf("No part of a recovery code should be stored, found %q in %+v", part, stored[0])
+			}
+		}
+	}
+
+	if !verifySecondFactor(user, "", strings.ToUpper(codes[0])) {
+		t.Error("A recovery code should work as a second factor.")
+	}
+	if verifySecondFactor(user, "", codes[0]) {
+		t.Error("A recovery code should only work once.")
+	}
+	if verifySecondFactor(user, "", "abcde-fghij") {
+		t.Error("A made up recovery code shouldn't work.")
+	}
+
+	// codes from before lookups were hashed had their lookup cleared by the migration
+	legacy := models.RecoveryCode{
+		GivenFields: models.GivenFields{
+			ID: uuid.New().String(),
+		},
+		UserID: user.ID,
+		Code:   util.HashPassword("klmno-pqrst"),
+	}
+	if err := repository.GRepos.RecoveryCodes.Create(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	if !verifySecondFactor(user, "", "klmno-pqrst") {
+		t.Error("A recovery code without a lookup should still work.")
+	}
+}
@@ -1,19 +1,23 @@
 package routes
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/jessehorne/superchat-core/database"
 	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/password"
+	"github.com/jessehorne/superchat-core/repository"
 	"github.com/jessehorne/superchat-core/util"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 type UserCreateRequest struct {
 	Email    string `json:"email" binding:"required,email,max=255"`
 	Name     string `json:"name" binding:"required,max=255"`
-	Password string `json:"password,min=8,max=255"`
+	Password string `json:"password" binding:"required"`
 }
 
 func UserCreate(c *gin.Context) {
@@ -24,29 +28,36 @@ func UserCreate(c *gin.Context) {
 		return
 	}
 
-	salt, hash := util.ProcessPassword(req.Password)
+	if err := password.GPolicy.Check(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
 	// attempt to create user
 	u := models.User{
 		GivenFields: models.GivenFields{
 			ID: uuid.New().String(),
 		},
-		Email:        req.Email,
-		Name:         req.Name,
-		Password:     hash,
-		PasswordSalt: salt,
+		Email:    req.Email,
+		Name:     req.Name,
+		Password: util.HashPassword(req.Password),
 	}
 
-	result := database.GDB.Create(&u)
-
-	if result.RowsAffected == 0 {
+	if err := repository.GRepos.Users.Create(&u); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"msg":    "couldn't create user",
-			"errors": result.Error.Error(),
+			"errors": err.Error(),
 		})
 		return
 	}
 
+	// don't make signing up wait on the mail server
+	sendInBackground("verification email", func() error {
+		return sendVerificationEmail(u)
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"userID": u.ID,
 	})
@@ -55,6 +66,12 @@ func UserCreate(c *gin.Context) {
 type UserGetTokenRequest struct {
 	Email    string `json:"email" binding:"required,email,lte=255"`
 	Password string `json:"password,gte=8,lte=255"`
+	Device   string `json:"device" binding:"lte=255"`
+	// TOTPCode or RecoveryCode is required for users with two-factor enabled
+	TOTPCode     string `json:"totpCode"`
+	RecoveryCode string `json:"recoveryCode"`
+	// Cookie keeps the tokens out of the response and puts them in HttpOnly cookies instead, for browsers
+	Cookie bool `json:"cookie"`
 }
 
 func UserGetToken(c *gin.Context) {
@@ -65,50 +82,74 @@ func UserGetToken(c *gin.Context) {
 		return
 	}
 
-	// get user by email
-	var user models.User
-	result := database.GDB.First(&user, "email = ?", req.Email)
-
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"msg": "no user found",
+	// bail out early if this account or IP has failed too many times
+	accountKey := accountThrottleKey(req.Email)
+	ipKey := ipThrottleKey(c.ClientIP())
+	if wait := loginLockedFor(accountKey, ipKey); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"msg": "too many failed attempts, try again later",
 		})
 		return
 	}
 
-	// validate password
-	if !util.ComparePassword(req.Password, user.PasswordSalt, user.Password) {
+	// get user by email
+	user, err := repository.GRepos.Users.FindByEmail(req.Email)
+
+	// validate password, unknown emails still hash something so they take as long as real ones and get the same
+	// response
+	var validPassword bool
+	if err != nil {
+		validPassword = util.ComparePasswordDummy(req.Password)
+	} else {
+		validPassword = util.ComparePassword(req.Password, user.PasswordSalt, user.Password)
+	}
+
+	if !validPassword {
+		recordLoginFailure(accountKey, accountFailureThreshold)
+		recordLoginFailure(ipKey, ipFailureThreshold)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"msg": "invalid credentials",
 		})
 		return
 	}
 
+	// the password is known to be right so this is our chance to upgrade an outdated hash
+	if util.NeedsRehash(user.Password) {
+		user.Password = util.HashPassword(req.Password)
+		user.PasswordSalt = ""
+		repository.GRepos.Users.UpdatePassword(user.ID, user.Password, user.PasswordSalt)
+	}
+
+	if emailVerificationRequired() && user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"msg":                       "email not verified",
+			"emailVerificationRequired": true,
+		})
+		return
+	}
+
+	// check the second factor if the user has one
+	if user.TOTPEnabled && !checkSecondFactor(c, user, req.TOTPCode, req.RecoveryCode, accountKey, ipKey) {
+		return
+	}
+
 	// user is GOOD TO GO!
-	// generate token to send to user and store hashed token in database
-	token, hash := util.CreateToken()
-
-	// check if session already exists...if so, update it
-	var sesh models.Session
-	sessionResult := database.GDB.First(&sesh, "user_id = ?", user.ID)
-	sesh.Token = hash
-	sesh.ExpiresAt = time.Now().Local().Add(1 * time.Hour)
-	sesh.UserID = user.ID
-
-	if sessionResult.RowsAffected == 0 {
-		// we need to creat the record now
-		sesh.ID = uuid.New().String()
-		database.GDB.Create(&sesh)
-	} else {
-		// we need to update it
-		database.GDB.Save(&sesh)
+	clearLoginFailures(accountKey)
+
+	// every login gets its own session so logging in on one device doesn't log out the others
+	sesh, tokens, err := createSession(c, user, req.Device, req.Cookie)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error creating session",
+		})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token":     token,
-		"userID":    user.ID,
-		"expiresAt": sesh.ExpiresAt.Format(time.RFC3339),
-	})
+	// clean up this user's dead sessions while we're here
+	repository.GRepos.Sessions.DeleteExpired(user.ID, time.Now())
+
+	sessionResponse(c, sesh, tokens)
 }
 
 type UserUpdateRequest struct {
@@ -132,6 +173,13 @@ func UserUpdate(c *gin.Context) {
 		return
 	}
 
+	if err := password.GPolicy.Check(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	// get user from request
 	u, exists := c.Get("user")
 	if !exists {
@@ -151,17 +199,15 @@ func UserUpdate(c *gin.Context) {
 	}
 
 	if req.Password != "" {
-		salt, hash := util.ProcessPassword(req.Password)
-		user.PasswordSalt = salt
-		user.Password = hash
+		user.Password = util.HashPassword(req.Password)
+		user.PasswordSalt = ""
 	}
 
 	if req.Name != "" {
 		user.Name = req.Name
 	}
 
-	saveResult := database.GDB.Save(&user)
-	if saveResult.RowsAffected == 0 {
+	if err := repository.GRepos.Users.Save(&user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "db issue while saving user",
 		})
@@ -208,8 +254,70 @@ func UserDelete(c *gin.Context) {
 		return
 	}
 
-	deleteResult := database.GDB.Delete(&user)
-	if deleteResult.RowsAffected == 0 {
+	// the user's bots are deleted along with them so the rooms they own need another owner too
+	bots, err := repository.GRepos.Users.ListBots(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "db issue while delete user",
+		})
+		return
+	}
+	userIDs := []string{user.ID}
+	for _, bot := range bots {
+		userIDs = append(userIDs, bot.ID)
+	}
+
+	owned, err := repository.GRepos.Mods.ListOwned(userIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "db issue while delete user",
+		})
+		return
+	}
+
+	var soleOwned []string
+	err = repository.GRepos.Transaction(func(tx *repository.Repositories) error {
+		// the rooms are locked in ID order and checked again so no other owner can leave before the user is gone
+		locked := make(map[string]struct{}, len(owned))
+		for _, o := range owned {
+			if err := tx.Rooms.Lock(o.RoomID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+				return err
+			}
+			locked[o.RoomID] = struct{}{}
+		}
+
+		owned, err := tx.Mods.ListOwned(userIDs)
+		if err != nil {
+			return err
+		}
+		for _, o := range owned {
+			if _, ok := locked[o.RoomID]; !ok {
+				return errModChanged
+			}
+			if o.OtherOwners == 0 {
+				soleOwned = append(soleOwned, o.RoomID)
+			}
+		}
+		if len(soleOwned) > 0 {
+			return errLastOwner
+		}
+
+		return tx.Users.Delete(&user)
+	})
+	if errors.Is(err, errLastOwner) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "you're the only owner of some rooms, transfer ownership or delete them first",
+			"roomIDs": soleOwned,
+		})
+		return
+	}
+	if errors.Is(err, errModChanged) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "your rooms changed while deleting your account, try again",
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "db issue while delete user",
 		})
@@ -218,3 +326,47 @@ func UserDelete(c *gin.Context) {
 
 	c.JSON(http.StatusOK, nil)
 }
+
+// UserGetRooms lists every room the authenticated user is in along with their mod role, which is null if they
+// aren't a mod.
+func UserGetRooms(c *gin.Context) {
+	// get user from request
+	u, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no auth user",
+		})
+		return
+	}
+
+	user := u.(models.User)
+
+	rooms, err := repository.GRepos.Members.ListRooms(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error getting rooms",
+		})
+		return
+	}
+
+	out := make([]gin.H, 0, len(rooms))
+	for _, r := range rooms {
+		// a key limited to some rooms shouldn't reveal the user's other memberships
+		if !apiKeyAllowsRoom(c, r.ID) {
+			continue
+		}
+
+		out = append(out, gin.H{
+			"roomID":            r.ID,
+			"name":              r.Name,
+			"passwordProtected": r.PasswordProtected,
+			"private":           r.Private,
+			"muted":             r.Muted,
+			"role":              r.Role,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rooms": out,
+	})
+}
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func Test_User_GetTokenLockout(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	user := testUser(t, "user")
+	user.Password = util.HashPassword("correct horse battery staple")
+	if err := repository.GRepos.Users.Save(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	login := gin.H{"email": user.Email, "password": "correct horse battery staple"}
+	if w := testRequest(UserGetToken, user, login); w.Code != http.StatusOK {
+		t.Fatalf("Logging in with the right password should work, got %d: %s", w.Code, w.Body)
+	}
+
+	for range accountFailureThreshold {
+		if w := testRequest(UserGetToken, user, gin.H{"email": user.Email, "password": "wrong password"}); w.Code != http.StatusBadRequest {
+			t.Fatalf("Logging in with the wrong password should be refused, got %d", w.Code)
+		}
+	}
+
+	w := testRequest(UserGetToken, user, login)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("An account with too many failures should be locked out, got %d: %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("A lockout should say when to try again.")
+	}
+
+	clearLoginFailures(accountThrottleKey(user.Email))
+	if w := testRequest(UserGetToken, user, login); w.Code != http.StatusOK {
+		t.Errorf("Clearing the failures should lift the lockout, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func Test_User_DeleteSoleOwner(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owner := testUser(t, "owner")
+	other := testUser(t, "other")
+	roomID := testModRoom(t, owner, other)
+
+	bot := models.User{
+		GivenFields: models.GivenFields{
+			ID: "bot",
+		},
+		Name:    "bot",
+		Bot:     true,
+		OwnerID: other.ID,
+	}
+	if err := repository.GRepos.Users.Create(&bot); err != nil {
+		t.Fatal(err)
+	}
+	botRoomID := testModRoom(t, bot)
+
+	w := testRequest(UserDelete, owner, gin.H{"userID": owner.ID})
+	var res struct {
+		RoomIDs []string `json:"roomIDs"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if w.Code != http.StatusBadRequest || !slices.Equal(res.RoomIDs, []string{roomID}) {
+		t.Errorf("Deleting the only owner of a room should be refused and say which room, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := repository.GRepos.Users.FindByID(owner.ID); err != nil {
+		t.Fatal("A refused deletion shouldn't delete the user.")
+	}
+
+	w = testRequest(UserDelete, other, gin.H{"userID": other.ID})
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if w.Code != http.StatusBadRequest || !slices.Equal(res.RoomIDs, []string{botRoomID}) {
+		t.Errorf("Rooms owned only by the user's bots should count too, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := testRequest(RoomAddMod, owner, gin.H{"roomID": roomID, "userID": other.ID, "role": models.RoomModRoleOwner}); w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to add another owner, got %d: %s", w.Code, w.Body)
+	}
+	if w := testRequest(UserDelete, owner, gin.H{"userID": owner.ID}); w.Code != http.StatusOK {
+		t.Fatalf("Deleting a user should work once their rooms have another owner, got %d: %s", w.Code, w.Body)
+	}
+	if owners, _ := repository.GRepos.Mods.CountRole(roomID, models.RoomModRoleOwner); owners != 1 {
+		t.Errorf("The room should be left with its other owner, it has %d", owners)
+	}
+}
+
+func Test_User_DeleteOwnersConcurrently(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+
+	owners := []models.User{testUser(t, "first"), testUser(t, "second")}
+	roomID := testModRoom(t, owners[0], owners[1])
+	if w := testRequest(RoomAddMod, owners[0], gin.H{"roomID": roomID, "userID": owners[1].ID, "role": models.RoomModRoleOwner}); w.Code != http.StatusOK {
+		t.Fatalf("An owner should be able to add another owner, got %d: %s", w.Code, w.Body)
+	}
+
+	// both owners delete their accounts at once, one of them has to stay
+	var wg sync.WaitGroup
+	codes := make([]int, len(owners))
+	for i, owner := range owners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = testRequest(UserDelete, owner, gin.H{"userID": owner.ID}).Code
+		}()
+	}
+	wg.Wait()
+
+	if !slices.Contains(codes, http.StatusOK) || !slices.Contains(codes, http.StatusBadRequest) {
+		t.Errorf("Only one of the owners should be able to go, got %v", codes)
+	}
+	if owners, _ := repository.GRepos.Mods.CountRole(roomID, models.RoomModRoleOwner); owners != 1 {
+		t.Errorf("The room should be left with one owner, it has %d", owners)
+	}
+}
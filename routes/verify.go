@@ -0,0 +1,156 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/mailer"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/util"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	verificationTokenLifetime = 24 * time.Hour
+	verificationTokenPurpose  = "verify-email"
+)
+
+// emailVerificationRequired is whether users have to verify their email before they can log in
+func emailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// appKey is the secret used to sign tokens handed out to users
+func appKey() ([]byte, error) {
+	key := os.Getenv("APP_KEY")
+	if key == "" {
+		return nil, errors.New("APP_KEY isn't set")
+	}
+	return []byte(key), nil
+}
+
+// sendVerificationEmail mails user a link to verify their email address. The token is signed rather than stored and
+// carries the address it was sent to, so it stops working once that address is verified or changed.
+func sendVerificationEmail(user models.User) error {
+	key, err := appKey()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(verificationTokenLifetime).Unix()
+	// the email goes last since it's the only part that could contain a |
+	payload := strings.Join([]string{verificationTokenPurpose, user.ID, strconv.FormatInt(expiresAt, 10), user.Email}, "|")
+	token := util.SignToken(payload, key)
+
+	link := fmt.Sprintf("%s/api/user/verify?token=%s", os.Getenv("APP_URL"), url.QueryEscape(token))
+
+	return mailer.GMailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your superchat email",
+		Body: fmt.Sprintf("Hey %s,\n\nClick the link below to verify your email address.\n\n%s\n\n"+
+			"The link expires in 24 hours. If you didn't sign up for superchat you can ignore this email.\n",
+			user.Name, link),
+	})
+}
+
+// UserVerifyEmail marks a user's email as verified using the token from their verification email
+func UserVerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing token",
+		})
+		return
+	}
+
+	key, err := appKey()
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "email verification isn't configured",
+		})
+		return
+	}
+
+	payload, ok := util.VerifySignedToken(token, key)
+	parts := strings.SplitN(payload, "|", 4)
+	if !ok || len(parts) != 4 || parts[0] != verificationTokenPurpose {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid token",
+		})
+		return
+	}
+
+	userID, email := parts[1], parts[3]
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "expired token",
+		})
+		return
+	}
+
+	// the email has to still match so a token for an old address can't verify a new one
+	user, err := repository.GRepos.Users.FindByID(userID)
+	if err != nil || user.Email != email {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid token",
+		})
+		return
+	}
+
+	// tokens are single use, once the email is verified they're dead
+	verified, err := repository.GRepos.Users.MarkEmailVerified(user.ID, time.Now())
+	if err != nil || !verified {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "email already verified",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+type UserResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email,max=255"`
+}
+
+// UserResendVerification sends another verification email. It responds the same way, and just as quickly, whether or
+// not the email belongs to anyone so it can't be used to find accounts. Requests are throttled like password resets.
+func UserResendVerification(c *gin.Context) {
+	var req UserResendVerificationRequest
+	err, res := util.TryBind(&req, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, res)
+		return
+	}
+
+	emailKey := verifyEmailThrottleKey(req.Email)
+	ipKey := verifyIPThrottleKey(c.ClientIP())
+	if wait := loginLockedFor(emailKey, ipKey); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"msg": "too many requests, try again later",
+		})
+		return
+	}
+	recordLoginFailure(emailKey, verifyEmailThreshold)
+	recordLoginFailure(ipKey, verifyIPThreshold)
+
+	user, err := repository.GRepos.Users.FindByEmail(req.Email)
+	if err == nil && user.EmailVerifiedAt == nil {
+		sendInBackground("verification email", func() error {
+			return sendVerificationEmail(user)
+		})
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_Verify_SignUp(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	m := testMailer(t)
+	t.Setenv("APP_KEY", "test key")
+
+	w := testRequest(UserCreate, models.User{}, gin.H{
+		"email":    "new@example.com",
+		"name":     "new",
+		"password": "a long enough password for the policy",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Signing up should work, got %d: %s", w.Code, w.Body)
+	}
+	pendingMail.Wait()
+
+	messages := m.Messages()
+	if len(messages) != 1 || messages[0].To != "new@example.com" {
+		t.Fatalf("Signing up should send a verification email, got %+v", messages)
+	}
+	match := resetTokenPattern.FindStringSubmatch(messages[0].Body)
+	if match == nil {
+		t.Fatal("The verification email should have a link with the token.")
+	}
+	token, _ := url.QueryUnescape(match[1])
+
+	gin.SetMode(gin.TestMode)
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?token="+url.QueryEscape(token), nil)
+	UserVerifyEmail(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Verifying with the mailed token should work, got %d: %s", w.Code, w.Body)
+	}
+
+	if user, _ := repository.GRepos.Users.FindByEmail("new@example.com"); user.EmailVerifiedAt == nil {
+		t.Error("Verifying should mark the email as verified.")
+	}
+}
+
+func Test_Verify_Resend(t *testing.T) {
+	repository.GRepos = repository.NewMemoryRepositories()
+	m := testMailer(t)
+	t.Setenv("APP_KEY", "test key")
+
+	unverified := testUser(t, "unverified")
+	verified := testUser(t, "verified")
+	now := time.Now()
+	verified.EmailVerifiedAt = &now
+	if err := repository.GRepos.Users.Save(&verified); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range []string{unverified.Email, verified.Email, "nobody@example.com"} {
+		if w := testRequest(UserResendVerification, models.User{}, gin.H{"email": email}); w.Code != http.StatusOK {
+			t.Errorf("Resending verification should look the same for every email, got %d for %s", w.Code, email)
+		}
+	}
+	pendingMail.Wait()
+
+	messages := m.Messages()
+	if len(messages) != 1 || messages[0].To != unverified.Email {
+		t.Errorf("Only unverified accounts should be sent another verification email, got %+v", messages)
+	}
+
+	for range verifyEmailThreshold - 1 {
+		testRequest(UserResendVerification, models.User{}, gin.H{"email": unverified.Email})
+	}
+	w := testRequest(UserResendVerification, models.User{}, gin.H{"email": unverified.Email})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Resending verification to the same email too often should be throttled, got %d", w.Code)
+	}
+	pendingMail.Wait()
+
+	if sent := len(m.Messages()); sent != verifyEmailThreshold {
+		t.Errorf("Throttled requests shouldn't send anything, %d emails were sent", sent)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jessehorne/superchat-core/database"
+	"github.com/jessehorne/superchat-core/database/models"
+	"github.com/jessehorne/superchat-core/events"
+	"github.com/jessehorne/superchat-core/mailer"
+	"github.com/jessehorne/superchat-core/middleware"
+	"github.com/jessehorne/superchat-core/oidc"
+	"github.com/jessehorne/superchat-core/password"
+	"github.com/jessehorne/superchat-core/repository"
+	"github.com/jessehorne/superchat-core/routes"
+	"github.com/jessehorne/superchat-core/util"
+	"os"
+)
+
+// Init sets up everything the API depends on from the environment
+func Init() error {
+	if err := util.InitPasswordParams(); err != nil {
+		return err
+	}
+
+	if _, err := password.InitPolicy(); err != nil {
+		return err
+	}
+
+	if _, err := database.InitDB(); err != nil {
+		return err
+	}
+
+	if _, err := database.InitGDB(); err != nil {
+		return err
+	}
+
+	if _, err := repository.InitRepositories(); err != nil {
+		return err
+	}
+
+	if _, err := events.InitBroker(); err != nil {
+		return err
+	}
+
+	if _, err := mailer.InitMailer(); err != nil {
+		return err
+	}
+
+	// verification links are signed with APP_KEY, without it nobody could verify their email and log in
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true" && os.Getenv("APP_KEY") == "" {
+		return errors.New("APP_KEY has to be set when REQUIRE_EMAIL_VERIFICATION=true")
+	}
+
+	if _, err := oidc.InitProvider(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Router returns an engine with every API route registered
+func Router() *gin.Engine {
+	r := gin.Default()
+
+	r.GET("/api/ping", routes.GetPing)
+
+	/* User Routes */
+	r.POST("/api/user", routes.UserCreate)
+	r.GET("/api/user/token", routes.UserGetToken)
+	r.POST("/api/user/token/refresh", routes.UserRefreshToken)
+	r.POST("/api/user/logout", middleware.AuthMiddleware, routes.UserLogout)
+	r.GET("/api/user/verify", routes.UserVerifyEmail)
+	r.POST("/api/user/verify/resend", routes.UserResendVerification)
+	r.POST("/api/user/password/reset", routes.UserRequestPasswordReset)
+	r.POST("/api/user/password/reset/confirm", routes.UserConfirmPasswordReset)
+	r.GET("/api/auth/oidc/login", routes.OIDCLogin)
+	r.GET("/api/auth/oidc/callback", routes.OIDCCallback)
+	r.POST("/api/auth/oidc/totp", routes.OIDCSecondFactor)
+	r.PUT("/api/user", middleware.AuthMiddleware, routes.UserUpdate)
+	r.DELETE("/api/user", middleware.AuthMiddleware, routes.UserDelete)
+	r.GET("/api/me/rooms", middleware.Scope(models.ScopeRoomsRead), middleware.AuthMiddleware, routes.UserGetRooms)
+	r.GET("/api/user/sessions", middleware.AuthMiddleware, routes.UserGetSessions)
+	r.DELETE("/api/user/session", middleware.AuthMiddleware, routes.UserRevokeSession)
+	r.DELETE("/api/user/sessions", middleware.AuthMiddleware, routes.UserRevokeOtherSessions)
+	r.POST("/api/user/totp", middleware.AuthMiddleware, routes.UserEnrollTOTP)
+	r.POST("/api/user/totp/confirm", middleware.AuthMiddleware, routes.UserConfirmTOTP)
+	r.DELETE("/api/user/totp", middleware.AuthMiddleware, routes.UserDisableTOTP)
+
+	/* Bot and API Key Routes */
+	r.POST("/api/bot", middleware.AuthMiddleware, routes.BotCreate)
+	r.GET("/api/bots", middleware.AuthMiddleware, routes.BotList)
+	r.DELETE("/api/bot", middleware.AuthMiddleware, routes.BotDelete)
+	r.POST("/api/user/key", middleware.AuthMiddleware, routes.APIKeyCreate)
+	r.GET("/api/user/keys", middleware.AuthMiddleware, routes.APIKeyList)
+	r.DELETE("/api/user/key", middleware.AuthMiddleware, routes.APIKeyRevoke)
+
+	/* Room Routes */
+	r.GET("/api/rooms", middleware.Scope(models.ScopeRoomsRead), middleware.AuthMiddleware, routes.RoomList)
+	r.POST("/api/room", middleware.Scope(models.ScopeRoomsWrite), middleware.AuthMiddleware, routes.RoomCreate)
+	r.PUT("/api/room", middleware.Scope(models.ScopeRoomsWrite), middleware.AuthMiddleware, routes.RoomUpdate)
+	r.DELETE("/api/room", middleware.Scope(models.ScopeRoomsWrite), middleware.AuthMiddleware, routes.RoomDelete)
+	r.POST("/api/room/mod", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomAddMod)
+	r.PUT("/api/room/mod", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomUpdateMod)
+	r.DELETE("/api/room/mod", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomDeleteMod)
+	r.GET("/api/room/roles", middleware.Scope(models.ScopeRoomsRead), middleware.AuthMiddleware, routes.RoomListRoles)
+	r.POST("/api/room/role", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomCreateRole)
+	r.PUT("/api/room/role", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomUpdateRole)
+	r.DELETE("/api/room/role", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomDeleteRole)
+	r.POST("/api/room/transfer", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomTransferOwnership)
+	r.GET("/api/room/audit", middleware.Scope(models.ScopeRoomsRead), middleware.AuthMiddleware, routes.RoomGetAuditLog)
+	r.POST("/api/room/user", middleware.Scope(models.ScopeRoomsWrite), middleware.AuthMiddleware, routes.RoomJoin)
+	r.DELETE("/api/room/user", middleware.Scope(models.ScopeRoomsWrite), middleware.AuthMiddleware, routes.RoomLeave)
+
+	/* Moderation Routes */
+	r.POST("/api/room/kick", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomKick)
+	r.POST("/api/room/ban", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomBan)
+	r.DELETE("/api/room/ban", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomUnban)
+	r.PUT("/api/room/mute", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomMute)
+	r.DELETE("/api/room/message", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomDeleteMessage)
+	r.PUT("/api/room/message/pin", middleware.Scope(models.ScopeModsWrite), middleware.AuthMiddleware, routes.RoomPinMessage)
+
+	/* Message Routes */
+	r.POST("/api/room/message", middleware.Scope(models.ScopeMessagesWrite), middleware.AuthMiddleware, routes.RoomCreateMessage)
+	r.GET("/api/room/messages", middleware.Scope(models.ScopeMessagesRead), middleware.AuthMiddleware, routes.RoomGetMessages)
+
+	/* Event Routes */
+	r.GET("/api/gateway", middleware.Scope(models.ScopeMessagesRead), middleware.AuthMiddleware, routes.Gateway)
+	r.GET("/api/room/events", middleware.Scope(models.ScopeMessagesRead), middleware.AuthMiddleware, routes.RoomEvents)
+
+	return r
+}
+
+// Run serves the API on APP_HOST:APP_PORT
+func Run() error {
+	if err := Init(); err != nil {
+		return err
+	}
+
+	return Router().Run(fmt.Sprintf("%s:%s", os.Getenv("APP_HOST"), os.Getenv("APP_PORT")))
+}
@@ -6,11 +6,20 @@ import (
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"golang.org/x/crypto/argon2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
 	defaultSaltLength = 16
+	// phcPrefix starts every password hash stored in PHC string format. Hashes without it are legacy ones whose salt
+	// is kept in a separate column.
+	phcPrefix = "$argon2id$"
 )
 
 type params struct {
@@ -21,6 +30,7 @@ type params struct {
 	keyLength   uint32
 }
 
+// argonParams are what new password hashes are made with, see InitPasswordParams
 var argonParams = &params{
 	memory:      64 * 1024,
 	iterations:  3,
@@ -29,36 +39,137 @@ var argonParams = &params{
 	keyLength:   32,
 }
 
+// legacyArgonParams made every hash stored before hashes were kept in PHC format
+var legacyArgonParams = &params{
+	memory:      64 * 1024,
+	iterations:  3,
+	parallelism: 2,
+	saltLength:  defaultSaltLength,
+	keyLength:   32,
+}
+
+// InitPasswordParams sets the argon2 parameters new password hashes are made with from ARGON2_MEMORY (in KiB),
+// ARGON2_ITERATIONS and ARGON2_PARALLELISM. Unset ones keep their defaults. Existing hashes keep working after a change
+// and are rehashed with the new parameters the next time their password is checked.
+func InitPasswordParams() error {
+	memory, err := envUint("ARGON2_MEMORY", 32, argonParams.memory)
+	if err != nil {
+		return err
+	}
+
+	iterations, err := envUint("ARGON2_ITERATIONS", 32, argonParams.iterations)
+	if err != nil {
+		return err
+	}
+
+	parallelism, err := envUint("ARGON2_PARALLELISM", 8, uint32(argonParams.parallelism))
+	if err != nil {
+		return err
+	}
+
+	p := *argonParams
+	p.memory = memory
+	p.iterations = iterations
+	p.parallelism = uint8(parallelism)
+
+	// argon2 needs at least 8KiB per thread
+	if p.memory < 8*uint32(p.parallelism) {
+		return fmt.Errorf("ARGON2_MEMORY must be at least %d with %d threads", 8*uint32(p.parallelism), p.parallelism)
+	}
+
+	argonParams = &p
+
+	return nil
+}
+
+// envUint reads a positive integer that fits in bits from the environment variable name, or returns fallback if it
+// isn't set
+func envUint(name string, bits int, fallback uint32) (uint32, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.ParseUint(raw, 10, bits)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+
+	return uint32(n), nil
+}
+
 func GenerateSalt(len int) []byte {
 	salt := make([]byte, len)
 	rand.Read(salt)
 	return salt
 }
 
-// GenerateHsh takes a password and salt byte array and returns a byte array containing an argon2 key
-func GenerateHash(pass string, salt []byte) []byte {
-	return argon2.IDKey([]byte(pass), salt,
-		argonParams.iterations, argonParams.memory, argonParams.parallelism, argonParams.keyLength)
+// deriveKey returns the argon2id key for pass and salt made with p
+func deriveKey(pass string, salt []byte, p *params) []byte {
+	return argon2.IDKey([]byte(pass), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+}
+
+// HashPassword returns a PHC formatted argon2id hash of pass made with the current parameters, like
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>. The salt and parameters are part of the hash so nothing else needs to
+// be stored.
+func HashPassword(pass string) string {
+	salt := GenerateSalt(int(argonParams.saltLength))
+	key := deriveKey(pass, salt, argonParams)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", phcPrefix, argon2.Version,
+		argonParams.memory, argonParams.iterations, argonParams.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
 }
 
-// ProcessPassword returns the base64 encoded salt and base64 encoded argon2 hash or an error
-func ProcessPassword(pass string) (string, string) {
-	salt := GenerateSalt(defaultSaltLength)
-	saltString := base64.RawStdEncoding.EncodeToString(salt)
+// decodePasswordHash parses a PHC formatted hash made by HashPassword
+func decodePasswordHash(hash string) (*params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || "$"+parts[1]+"$" != phcPrefix {
+		return nil, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, errors.New("unsupported argon2 version")
+	}
+
+	p := &params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, nil, nil, errors.New("invalid argon2 parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid salt")
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid hash")
+	}
 
-	hash := GenerateHash(pass, salt)
-	hashString := base64.RawStdEncoding.EncodeToString(hash)
+	p.saltLength = uint32(len(salt))
+	p.keyLength = uint32(len(key))
 
-	return saltString, hashString
+	return p, salt, key, nil
 }
 
-// ComparePassword compares pass with salt and hash and returns true if it's valid
-// You must provide the base64 encoded salt and hash to this function.
+// ComparePassword returns true if pass matches hash. hash is normally a PHC formatted hash from HashPassword and salt
+// is ignored. Legacy hashes are base64 encoded keys whose base64 encoded salt has to be given separately.
 func ComparePassword(pass string, salt string, hash string) bool {
+	if strings.HasPrefix(hash, phcPrefix) {
+		p, decodedSalt, decodedHash, err := decodePasswordHash(hash)
+		if err != nil {
+			return false
+		}
+
+		return subtle.ConstantTimeCompare(decodedHash, deriveKey(pass, decodedSalt, p)) == 1
+	}
+
 	decodedSalt, _ := base64.RawStdEncoding.DecodeString(salt)
 	decodedHash, _ := base64.RawStdEncoding.DecodeString(hash)
 
-	generatedHash := GenerateHash(pass, decodedSalt)
+	generatedHash := deriveKey(pass, decodedSalt, legacyArgonParams)
 
 	if subtle.ConstantTimeCompare(decodedHash, generatedHash) == 1 {
 		return true
@@ -67,6 +178,38 @@ func ComparePassword(pass string, salt string, hash string) bool {
 	return false
 }
 
+// NeedsRehash returns true if hash is a legacy hash or wasn't made with the current parameters. Call it after
+// ComparePassword succeeds and store a new HashPassword if it's true.
+func NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, phcPrefix) {
+		return true
+	}
+
+	p, _, _, err := decodePasswordHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return *p != *argonParams
+}
+
+var (
+	dummyOnce sync.Once
+	dummyHash string
+)
+
+// ComparePasswordDummy does the same work as ComparePassword against a throwaway hash and always returns false. Use it
+// when there's no real hash to check, like a login for an unknown email, so response times don't give that away.
+func ComparePasswordDummy(pass string) bool {
+	dummyOnce.Do(func() {
+		dummyHash = HashPassword(base64.RawStdEncoding.EncodeToString(GenerateSalt(32)))
+	})
+
+	ComparePassword(pass, "", dummyHash)
+
+	return false
+}
+
 // CreateToken creates a session-based auth token and sha256 hash of it and returns them
 // The token and token hash are base64 encoded.
 func CreateToken() (string, string) {
@@ -82,6 +225,18 @@ func CreateToken() (string, string) {
 	return tokenBase64, hashBase64
 }
 
+// HashToken returns the base64 encoded sha256 hash of a base64 encoded token, which is what's stored in the database.
+// It lets a session be looked up by the token a client sends.
+func HashToken(token string) string {
+	decodedToken, _ := base64.RawStdEncoding.DecodeString(token)
+
+	h := sha256.New()
+	h.Write(decodedToken)
+	bs := h.Sum(nil)
+
+	return base64.RawStdEncoding.EncodeToString(bs)
+}
+
 // ValidateToken takes base64 encoded token and token hash and returns true if they are equal when the token is decoded
 // and sha256'd.
 func ValidateToken(token string, hash string) bool {
@@ -94,3 +249,31 @@ func ValidateToken(token string, hash string) bool {
 
 	return bytes.Equal(bs, decodedHash)
 }
+
+// bearerTokenPrefix starts every session bearer token so they can't be mistaken for raw tokens or API keys
+const bearerTokenPrefix = "scs_"
+
+// EncodeBearerToken packs a session ID and its token into the single opaque token clients send as
+// "Authorization: Bearer <token>"
+func EncodeBearerToken(sessionID string, token string) string {
+	return bearerTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(sessionID+":"+token))
+}
+
+// DecodeBearerToken undoes EncodeBearerToken. ok is false if bearer wasn't made by it.
+func DecodeBearerToken(bearer string) (sessionID string, token string, ok bool) {
+	if !strings.HasPrefix(bearer, bearerTokenPrefix) {
+		return "", "", false
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(bearer, bearerTokenPrefix))
+	if err != nil {
+		return "", "", false
+	}
+
+	sessionID, token, ok = strings.Cut(string(decoded), ":")
+	if !ok || sessionID == "" || token == "" {
+		return "", "", false
+	}
+
+	return sessionID, token, true
+}
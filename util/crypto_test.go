@@ -1,22 +1,90 @@
 package util
 
-import "testing"
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
 
 func Test_Password_All(t *testing.T) {
 	password := "my cabbages"
 
-	salt, hash := ProcessPassword(password)
+	hash := HashPassword(password)
 
-	shouldBeTrue := ComparePassword(password, salt, hash)
+	shouldBeTrue := ComparePassword(password, "", hash)
 
 	if shouldBeTrue != true {
 		t.Errorf("Your crypto library is trash. Valid passwords aren't valid??")
 	}
 
-	shouldBeFalse := ComparePassword("definitely not valid", salt, hash)
+	shouldBeFalse := ComparePassword("definitely not valid", "", hash)
 	if shouldBeFalse != false {
 		t.Errorf("If this happens in production...people are going to be upset.")
 	}
+
+	if NeedsRehash(hash) {
+		t.Error("A hash made with the current parameters shouldn't need rehashing.")
+	}
+}
+
+func Test_Password_Legacy(t *testing.T) {
+	password := "my cabbages"
+
+	// this is how hashes were stored before they were PHC formatted
+	salt := GenerateSalt(defaultSaltLength)
+	saltString := base64.RawStdEncoding.EncodeToString(salt)
+	hashString := base64.RawStdEncoding.EncodeToString(deriveKey(password, salt, legacyArgonParams))
+
+	if !ComparePassword(password, saltString, hashString) {
+		t.Error("Legacy hashes should still be valid.")
+	}
+
+	if ComparePassword("definitely not valid", saltString, hashString) {
+		t.Error("Legacy hashes shouldn't accept the wrong password.")
+	}
+
+	if !NeedsRehash(hashString) {
+		t.Error("Legacy hashes should always need rehashing.")
+	}
+}
+
+func Test_Password_Params(t *testing.T) {
+	defaults := argonParams
+	defer func() {
+		argonParams = defaults
+	}()
+
+	password := "my cabbages"
+	oldHash := HashPassword(password)
+
+	t.Setenv("ARGON2_MEMORY", "16384")
+	t.Setenv("ARGON2_ITERATIONS", "2")
+	t.Setenv("ARGON2_PARALLELISM", "1")
+	if err := InitPasswordParams(); err != nil {
+		t.Fatal(err)
+	}
+
+	newHash := HashPassword(password)
+	if !strings.HasPrefix(newHash, "$argon2id$v=19$m=16384,t=2,p=1$") {
+		t.Errorf("Hashes should record the parameters they were made with, got %s", newHash)
+	}
+
+	if !ComparePassword(password, "", oldHash) {
+		t.Error("Changing parameters shouldn't break existing hashes.")
+	}
+
+	if !NeedsRehash(oldHash) {
+		t.Error("A hash made with old parameters should need rehashing.")
+	}
+
+	if NeedsRehash(newHash) {
+		t.Error("A hash made with the current parameters shouldn't need rehashing.")
+	}
+
+	t.Setenv("ARGON2_PARALLELISM", "300")
+	if err := InitPasswordParams(); err == nil {
+		t.Error("Out of range parameters should be refused.")
+	}
 }
 
 func Test_Token_All(t *testing.T) {
@@ -31,4 +99,54 @@ func Test_Token_All(t *testing.T) {
 	if shouldBeFalse != false {
 		t.Error("This definitely shouldn't happen in production. This token is invalid but it says it isn't.")
 	}
+
+	if HashToken(token) != tokenHash {
+		t.Error("Hashing a token should give the same hash CreateToken did.")
+	}
+}
+
+func Test_Password_Dummy(t *testing.T) {
+	for _, password := range []string{"", "my cabbages", "definitely not valid"} {
+		if ComparePasswordDummy(password) {
+			t.Errorf("Comparing %q against the dummy hash should never work.", password)
+		}
+	}
+}
+
+func Test_SignedToken_All(t *testing.T) {
+	secret := []byte("super secret")
+
+	token := SignToken("some payload", secret)
+
+	payload, ok := VerifySignedToken(token, secret)
+	if !ok || payload != "some payload" {
+		t.Error("A freshly signed token should verify and give back its payload.")
+	}
+
+	if _, ok := VerifySignedToken(token, []byte("wrong secret")); ok {
+		t.Error("A token signed with another secret shouldn't verify.")
+	}
+
+	if _, ok := VerifySignedToken(token+"x", secret); ok {
+		t.Error("A tampered token shouldn't verify.")
+	}
+}
+
+func Test_BearerToken_All(t *testing.T) {
+	token, _ := CreateToken()
+
+	bearer := EncodeBearerToken("some-session-id", token)
+
+	sessionID, decodedToken, ok := DecodeBearerToken(bearer)
+	if !ok || sessionID != "some-session-id" || decodedToken != token {
+		t.Error("A bearer token should decode back to the session ID and token it was made from.")
+	}
+
+	if _, _, ok := DecodeBearerToken(token); ok {
+		t.Error("A raw token shouldn't decode as a bearer token.")
+	}
+
+	if _, _, ok := DecodeBearerToken("not a bearer token!"); ok {
+		t.Error("Garbage shouldn't decode as a bearer token.")
+	}
 }
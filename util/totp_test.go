@@ -0,0 +1,51 @@
+package util
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func Test_TOTP_RFC6238(t *testing.T) {
+	// SHA1 test vectors from RFC 6238 Appendix B, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range vectors {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("at %d got %s, expected %s", unix, code, expected)
+		}
+	}
+}
+
+func Test_TOTP_Validate(t *testing.T) {
+	secret := GenerateTOTPSecret()
+	now := time.Now()
+
+	code, _ := TOTPCode(secret, TOTPStep(now))
+	step, ok := ValidateTOTP(secret, code, now, 0)
+	if !ok {
+		t.Fatal("A fresh code should be valid.")
+	}
+
+	if _, ok := ValidateTOTP(secret, code, now, step); ok {
+		t.Error("A code shouldn't be usable twice.")
+	}
+
+	old, _ := TOTPCode(secret, TOTPStep(now)-5)
+	if _, ok := ValidateTOTP(secret, old, now, 0); ok {
+		t.Error("A code from a couple minutes ago shouldn't be valid.")
+	}
+}